@@ -0,0 +1,36 @@
+package ssmenv
+
+import "strings"
+
+// isParameterARN reports whether name references a parameter by its ARN, like
+// arn:aws:ssm:us-east-1:123456789012:parameter/app/secret, rather than by its
+// name. GetParameters accepts either.
+func isParameterARN(name string) bool {
+	return strings.HasPrefix(name, "arn:")
+}
+
+// parameterAccount returns the account ID in name, when it's the ARN of a
+// parameter shared from another account, e.g.
+// arn:aws:ssm:us-east-1:123456789012:parameter/app/secret. Names that aren't
+// ARNs are parameters in the account of the caller.
+func parameterAccount(name string) (account string, isARN bool) {
+	if !isParameterARN(name) {
+		return "", false
+	}
+	parts := strings.SplitN(name, ":", 6)
+	if len(parts) < 6 {
+		return "", true
+	}
+	return parts[4], true
+}
+
+// allowedAccount reports whether the parameter named name can be read. When
+// allowedAccounts is set, ARNs have to be in one of its accounts. Malformed
+// ARNs are never allowed.
+func (e *expander) allowedAccount(name string) bool {
+	if e.allowedAccounts == nil {
+		return true
+	}
+	account, isARN := parameterAccount(name)
+	return !isARN || e.allowedAccounts[account]
+}
@@ -0,0 +1,141 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	allowedARN = "arn:aws:ssm:us-east-1:111111111111:parameter/shared/secret"
+	blockedARN = "arn:aws:ssm:us-east-1:222222222222:parameter/shared/secret"
+)
+
+func TestExpandEnviron_AllowedAccount(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:               template.Must(parseTemplate(DefaultTemplate)),
+		os:              os,
+		ssm:             c,
+		batchSize:       defaultBatchSize,
+		allowedAccounts: map[string]bool{"111111111111": true},
+	}
+
+	os.Setenv("SHARED_SECRET", "ssm://"+allowedARN)
+	os.Setenv("OWN_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String(allowedARN), aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String(allowedARN), Value: aws.String("shared")},
+			{Name: aws.String("secret"), Value: aws.String("own")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "shared", os["SHARED_SECRET"])
+	assert.Equal(t, "own", os["OWN_SECRET"])
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_BlockedAccount(t *testing.T) {
+	tests := []struct {
+		nofail bool
+		err    string
+	}{
+		{false, "SHARED_SECRET references " + blockedARN + ", which isn't in an allowed account"},
+		{true, ""},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:               template.Must(parseTemplate(DefaultTemplate)),
+			os:              os,
+			ssm:             c,
+			batchSize:       defaultBatchSize,
+			allowedAccounts: map[string]bool{"111111111111": true},
+		}
+
+		os.Setenv("SHARED_SECRET", "ssm://"+blockedARN)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		// Rejected before any call.
+		assert.Equal(t, "ssm://"+blockedARN, os["SHARED_SECRET"])
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_ARN(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:              template.Must(parseTemplate(DefaultTemplate)),
+		os:             os,
+		ssm:            c,
+		batchSize:      defaultBatchSize,
+		normalizePaths: true,
+	}
+
+	os.Setenv("SHARED_SECRET", "ssm://"+allowedARN)
+	os.Setenv("PINNED_SECRET", "ssm://"+allowedARN+":2")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String(allowedARN), aws.String(allowedARN + ":2")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String(allowedARN), Selector: aws.String(":2"), Value: aws.String("pinned")},
+			{Name: aws.String(allowedARN), Value: aws.String("shared")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "shared", os["SHARED_SECRET"])
+	assert.Equal(t, "pinned", os["PINNED_SECRET"])
+
+	c.AssertExpectations(t)
+}
+
+func TestParameterAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		isARN   bool
+	}{
+		{"/app/secret", "", false},
+		{"secret:1", "", false},
+		{allowedARN, "111111111111", true},
+		{allowedARN + ":2", "111111111111", true},
+		{"arn:aws:ssm", "", true},
+	}
+
+	for _, tt := range tests {
+		account, isARN := parameterAccount(tt.name)
+		assert.Equal(t, tt.account, account, tt.name)
+		assert.Equal(t, tt.isARN, isARN, tt.name)
+	}
+}
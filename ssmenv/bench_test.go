@@ -0,0 +1,104 @@
+package ssmenv
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// latencySSM is an SSMClient that resolves every requested parameter to a
+// value derived from its name, after simulating the round trip latency of a
+// GetParameters call.
+type latencySSM struct {
+	latency time.Duration
+}
+
+func (c *latencySSM) GetParametersWithContext(ctx aws.Context, input *ssm.GetParametersInput, opts ...request.Option) (*ssm.GetParametersOutput, error) {
+	time.Sleep(c.latency)
+
+	out := new(ssm.GetParametersOutput)
+	for _, name := range input.Names {
+		out.Parameters = append(out.Parameters, &ssm.Parameter{
+			Name:  name,
+			Value: aws.String("value-" + *name),
+		})
+	}
+	return out, nil
+}
+
+func (c *latencySSM) DescribeParametersWithContext(ctx aws.Context, input *ssm.DescribeParametersInput, opts ...request.Option) (*ssm.DescribeParametersOutput, error) {
+	time.Sleep(c.latency)
+	return new(ssm.DescribeParametersOutput), nil
+}
+
+func (c *latencySSM) GetParametersByPathWithContext(ctx aws.Context, input *ssm.GetParametersByPathInput, opts ...request.Option) (*ssm.GetParametersByPathOutput, error) {
+	time.Sleep(c.latency)
+	return new(ssm.GetParametersByPathOutput), nil
+}
+
+// benchEnviron returns an environment with n variables referencing distinct
+// SSM parameters.
+func benchEnviron(n int) fakeEnviron {
+	env := newFakeEnviron()
+	for i := 0; i < n; i++ {
+		env.Setenv(fmt.Sprintf("SECRET_%d", i), fmt.Sprintf("ssm:///bench/secret-%d", i))
+	}
+	return env
+}
+
+func BenchmarkExpandEnviron(b *testing.B) {
+	t := template.Must(parseTemplate(DefaultTemplate))
+
+	for _, refs := range []int{1, 10, 100} {
+		for _, batchSize := range []int{1, 5, defaultBatchSize} {
+			b.Run(fmt.Sprintf("refs=%d/batch=%d", refs, batchSize), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					e := expander{
+						t:         t,
+						os:        benchEnviron(refs),
+						ssm:       &latencySSM{latency: 100 * time.Microsecond},
+						batchSize: batchSize,
+					}
+					b.StartTimer()
+
+					if err := e.expandEnviron(false, false); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkFetchBatches compares fetching the batches for 100 parameters with
+// fetchParameters one after the other, with the default -ssm-concurrency and
+// all of them at once.
+func BenchmarkFetchBatches(b *testing.B) {
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = fmt.Sprintf("/bench/secret-%d", i)
+	}
+
+	for _, concurrency := range []int{1, defaultSSMConcurrency, len(names) / defaultBatchSize} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			e := expander{
+				ssm:            &latencySSM{latency: time.Millisecond},
+				batchSize:      defaultBatchSize,
+				ssmConcurrency: concurrency,
+			}
+
+			for i := 0; i < b.N; i++ {
+				if _, err := e.fetchParameters(context.Background(), names, nil, false, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package ssmenv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheKeySize is the size of the AES-256 keys caches are encrypted with.
+const cacheKeySize = 32
+
+// paramCache holds the values of parameters across runs, in a file, so
+// that containers restarting often don't fetch parameters that rarely
+// change every time. The file is encrypted with AES-GCM, unless key is nil.
+//
+// Its methods are no-ops on a nil cache, so that callers don't have to
+// check whether caching is enabled.
+type paramCache struct {
+	path string
+	ttl  time.Duration
+	key  []byte
+
+	// scope is what the values are fetched from, from cacheScope, so that
+	// runs against another region, profile or endpoint don't use them.
+	scope string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+// cacheEntry is the value of a parameter, and when it was fetched.
+type cacheEntry struct {
+	Value   string    `json:"value"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// loadCache returns the cache held in the file at path, encrypted with key,
+// if it's not nil, for the values fetched from scope. A file that doesn't
+// exist is an empty cache, and so is one that can't be decrypted or decoded,
+// since it's rewritten anyway.
+func loadCache(path string, ttl time.Duration, key []byte, scope string) (*paramCache, error) {
+	c := &paramCache{path: path, ttl: ttl, key: key, scope: scope, entries: make(map[string]cacheEntry)}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		if b, err = c.open(b); err != nil {
+			return c, nil
+		}
+	}
+	var entries map[string]cacheEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return c, nil
+	}
+	c.entries = entries
+	return c, nil
+}
+
+// cacheScope is the scope of the values fetched with config: parameters of
+// the same name in another region, through another profile, which may be
+// another account, or from another endpoint are other parameters.
+func cacheScope(config awsConfig) string {
+	return config.region + "|" + config.profile + "|" + config.endpointURL
+}
+
+// cacheKey is the key of a parameter in the cache. Values fetched with and
+// without decryption are kept apart, since they differ for SecureString
+// parameters, and so are values fetched from other scopes.
+func cacheKey(scope, name string, decrypt bool) string {
+	if decrypt {
+		return "decrypted:" + scope + ":" + name
+	}
+	return "raw:" + scope + ":" + name
+}
+
+// get returns the value of a parameter fetched less than ttl before now.
+func (c *paramCache) get(name string, decrypt bool, now time.Time) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(c.scope, name, decrypt)]
+	if !ok || now.Sub(entry.Fetched) >= c.ttl {
+		return "", false
+	}
+	return entry.Value, true
+}
+
+// put records the value of a parameter, fetched at now.
+func (c *paramCache) put(name string, decrypt bool, value string, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(c.scope, name, decrypt)] = cacheEntry{Value: value, Fetched: now}
+	c.dirty = true
+}
+
+// write writes the cache back to its file, if anything was added to it,
+// leaving out the entries that expired by now. Only the current user can
+// read the file.
+func (c *paramCache) write(now time.Time) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	entries := make(map[string]cacheEntry)
+	for k, entry := range c.entries {
+		if now.Sub(entry.Fetched) < c.ttl {
+			entries[k] = entry
+		}
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if c.key != nil {
+		if b, err = c.seal(b); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(c.path, b, 0600)
+}
+
+// seal encrypts plaintext, prefixing it with the nonce it's encrypted with.
+func (c *paramCache) seal(plaintext []byte) ([]byte, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts a ciphertext sealed by seal.
+func (c *paramCache) open(ciphertext []byte) ([]byte, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func (c *paramCache) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readCacheKey reads the key caches are encrypted with from the file at
+// path, which holds it hex encoded.
+func readCacheKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != cacheKeySize {
+		return nil, fmt.Errorf("%s doesn't hold a hex encoded %d byte key", path, cacheKeySize)
+	}
+	return key, nil
+}
+
+// cachedParameters adds the values of the parameters among names that are
+// in the cache to values, and returns the names of the others.
+func (e *expander) cachedParameters(names []string, decrypt bool, values map[string]string) []string {
+	if e.cache == nil {
+		return names
+	}
+	now := e.now()
+	var rest []string
+	for _, name := range names {
+		if v, ok := e.cache.get(name, decrypt, now); ok {
+			e.logf("%s: cached", name)
+			values[name] = v
+			continue
+		}
+		rest = append(rest, name)
+	}
+	return rest
+}
+
+// cacheParameters adds the values of parameters that were just fetched to
+// the cache. Values only given to missing parameters, with
+// -missing-sentinel, aren't cached.
+func (e *expander) cacheParameters(values map[string]string, decrypt bool) {
+	if e.cache == nil {
+		return
+	}
+	now := e.now()
+	for name, v := range values {
+		if e.missingSentinel != "" && v == e.missingSentinel {
+			continue
+		}
+		e.cache.put(name, decrypt, v, now)
+	}
+}
@@ -0,0 +1,144 @@
+package ssmenv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_Cache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	key := bytes.Repeat([]byte{1}, cacheKeySize)
+	clk := newFakeClock()
+
+	// resolve resolves SUPER_SECRET with a cache loaded from path, and
+	// expects GetParameters to be called if fetch is set.
+	resolve := func(fetch bool) {
+		env := newFakeEnviron()
+		c := new(mockSSM)
+		cache, err := loadCache(path, time.Hour, key, "")
+		assert.NoError(t, err)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        env,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+			clock:     clk,
+			cache:     cache,
+		}
+
+		env.Setenv("SUPER_SECRET", "ssm://secret")
+		if fetch {
+			c.On("GetParameters", &ssm.GetParametersInput{
+				Names:          []*string{aws.String("secret")},
+				WithDecryption: aws.Bool(true),
+			}).Return(&ssm.GetParametersOutput{
+				Parameters: []*ssm.Parameter{
+					{Name: aws.String("secret"), Value: aws.String("hehe")},
+				},
+			}, nil)
+		}
+
+		decrypt := true
+		nofail := false
+		err = e.expandEnviron(decrypt, nofail)
+		assert.NoError(t, err)
+		assert.Equal(t, "hehe", envMap(env.Environ())["SUPER_SECRET"])
+		assert.NoError(t, cache.write(clk.now()))
+
+		c.AssertExpectations(t)
+	}
+
+	resolve(true)
+
+	// The cache is encrypted, and only the current user can read it.
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(b, []byte("hehe")))
+
+	// Until the value expires, it's not fetched again.
+	clk.advance(59 * time.Minute)
+	resolve(false)
+	clk.advance(time.Minute)
+	resolve(true)
+}
+
+func TestLoadCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	key := bytes.Repeat([]byte{1}, cacheKeySize)
+
+	// A missing file is an empty cache.
+	c, err := loadCache(filepath.Join(dir, "missing"), time.Hour, nil, "")
+	assert.NoError(t, err)
+	_, ok := c.get("secret", false, now)
+	assert.False(t, ok)
+
+	// Values fetched with and without decryption are kept apart.
+	path := filepath.Join(dir, "cache")
+	c, err = loadCache(path, time.Hour, nil, "")
+	assert.NoError(t, err)
+	c.put("secret", true, "hehe", now)
+	assert.NoError(t, c.write(now))
+
+	c, err = loadCache(path, time.Hour, nil, "")
+	assert.NoError(t, err)
+	v, ok := c.get("secret", true, now)
+	assert.True(t, ok)
+	assert.Equal(t, "hehe", v)
+	_, ok = c.get("secret", false, now)
+	assert.False(t, ok)
+
+	// Values fetched from other scopes are kept apart.
+	c, err = loadCache(path, time.Hour, nil, cacheScope(awsConfig{region: "eu-west-1"}))
+	assert.NoError(t, err)
+	_, ok = c.get("secret", true, now)
+	assert.False(t, ok)
+
+	// A cache that can't be decrypted is empty.
+	c, err = loadCache(path, time.Hour, key, "")
+	assert.NoError(t, err)
+	_, ok = c.get("secret", true, now)
+	assert.False(t, ok)
+
+	// Nil caches do nothing.
+	var nilCache *paramCache
+	nilCache.put("secret", true, "hehe", now)
+	_, ok = nilCache.get("secret", true, now)
+	assert.False(t, ok)
+	assert.NoError(t, nilCache.write(now))
+}
+
+func TestReadCacheKey(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "key")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Repeat("ab", cacheKeySize)+"\n"), 0600))
+	key, err := readCacheKey(path)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0xab}, cacheKeySize), key)
+
+	short := filepath.Join(dir, "short")
+	assert.NoError(t, os.WriteFile(short, []byte("abab"), 0600))
+	_, err = readCacheKey(short)
+	assert.EqualError(t, err, short+" doesn't hold a hex encoded 32 byte key")
+}
+
+func TestRun_CacheFileNeedsKey(t *testing.T) {
+	c := new(mockSSM)
+	code, _, stderr := runWith(c, newFakeEnviron(), "-cache-file", filepath.Join(t.TempDir(), "cache"), "-print")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "ssm-env: -cache-file needs -cache-key-file to encrypt the cache, or -cache-plaintext to store values in plaintext\n", stderr)
+	c.AssertExpectations(t)
+}
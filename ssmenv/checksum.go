@@ -0,0 +1,90 @@
+package ssmenv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// ChecksumSuffix is appended to the name of a parameter to get the name of
+// its sibling parameter holding the hex encoded SHA-256 checksum of its
+// value, e.g. /app/secret.sha256 for /app/secret.
+const ChecksumSuffix = ".sha256"
+
+// verifyChecksums compares the values of the named parameters with the
+// checksums in their sibling parameters, if they have one. Parameters that
+// can't be verified are an error, or with nofail, removed from values.
+func (e *expander) verifyChecksums(ctx context.Context, values map[string]string, names []string, decrypt bool, nofail bool) error {
+	sums, err := e.checksums(ctx, names, decrypt)
+	if err != nil {
+		err = fmt.Errorf("getting checksums: %v", err)
+		e.count(metricFailed, int64(len(names)))
+		if !nofail {
+			return err
+		}
+		e.warnings().warn(err)
+		for _, name := range names {
+			delete(values, name)
+		}
+		return nil
+	}
+
+	for _, name := range names {
+		sum, ok := sums[name]
+		if !ok {
+			continue
+		}
+
+		actual := sha256.Sum256([]byte(values[name]))
+		if strings.EqualFold(strings.TrimSpace(sum), hex.EncodeToString(actual[:])) {
+			continue
+		}
+
+		err := fmt.Errorf("value of %s doesn't match the checksum in %s", name, name+ChecksumSuffix)
+		e.count(metricFailed, 1)
+		if !nofail {
+			return err
+		}
+		e.warnings().warn(err)
+		delete(values, name)
+	}
+	return nil
+}
+
+// checksums returns the checksums in the sibling parameters of names, keyed
+// by name. Names without a sibling, and names with a version or label
+// selector, which the sibling can't be for, are missing from the result.
+func (e *expander) checksums(ctx context.Context, names []string, decrypt bool) (map[string]string, error) {
+	input := &ssm.GetParametersInput{
+		WithDecryption: aws.Bool(decrypt),
+	}
+	for _, name := range names {
+		if baseName(name) == name {
+			input.Names = append(input.Names, aws.String(name+ChecksumSuffix))
+		}
+	}
+
+	sums := make(map[string]string)
+	if len(input.Names) == 0 {
+		return sums, nil
+	}
+
+	start := e.now()
+	resp, err := e.ssm.GetParametersWithContext(ctx, input)
+	e.count(metricCalls, 1)
+	e.logCall(fmt.Sprintf("ssm:GetParameters of %d checksums", len(input.Names)), start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range resp.Parameters {
+		e.iam.add("ssm:GetParameters", aws.StringValue(p.ARN))
+		sums[strings.TrimSuffix(aws.StringValue(p.Name), ChecksumSuffix)] = aws.StringValue(p.Value)
+	}
+	return sums, nil
+}
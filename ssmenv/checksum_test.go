@@ -0,0 +1,118 @@
+package ssmenv
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+// heheChecksum is the SHA-256 checksum of "hehe".
+const heheChecksum = "0ebe2eca800cf7bd9d9d9f9f4aafbc0c77ae155f43bbbeca69cb256a24c7f9bb"
+
+func TestExpandEnviron_Checksum(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:              template.Must(parseTemplate(DefaultTemplate)),
+		os:             os,
+		ssm:            c,
+		batchSize:      defaultBatchSize,
+		verifyChecksum: true,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+	os.Setenv("OTHER_SECRET", "ssm://other")
+	os.Setenv("OLD_SECRET", "ssm://secret:1")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("other"), aws.String("secret"), aws.String("secret:1")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("other"), Value: aws.String("haha")},
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+			{Name: aws.String("secret"), Selector: aws.String(":1"), Value: aws.String("hoho")},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("other.sha256"), aws.String("secret.sha256")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret.sha256"), Value: aws.String(strings.ToUpper(heheChecksum) + "\n")},
+		},
+		InvalidParameters: []*string{aws.String("other.sha256")},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"OLD_SECRET=hoho",
+		"OTHER_SECRET=haha",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_ChecksumMismatch(t *testing.T) {
+	tests := []struct {
+		nofail bool
+		err    string
+	}{
+		{false, "value of secret doesn't match the checksum in secret.sha256"},
+		{true, ""},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:              template.Must(parseTemplate(DefaultTemplate)),
+			os:             os,
+			ssm:            c,
+			batchSize:      defaultBatchSize,
+			verifyChecksum: true,
+		}
+
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret"), Value: aws.String("tampered")},
+			},
+		}, nil)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret.sha256")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret.sha256"), Value: aws.String(heheChecksum)},
+			},
+		}, nil)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, "ssm://secret", os["SUPER_SECRET"])
+
+		c.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,73 @@
+package ssmenv
+
+import (
+	"context"
+	"time"
+)
+
+// clock tells the time and waits, so that time based behavior, like
+// caching, rate limiting and backing off, can be tested without depending on
+// the actual passing of time.
+type clock interface {
+	now() time.Time
+	sleep(d time.Duration)
+
+	// after returns a channel the time is sent on once d has passed.
+	after(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock of the system.
+type realClock struct{}
+
+func (realClock) now() time.Time        { return time.Now() }
+func (realClock) sleep(d time.Duration) { time.Sleep(d) }
+
+func (realClock) after(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// now and sleep use the clock of the expander, or the system clock if it
+// doesn't have one.
+
+func (e *expander) now() time.Time {
+	if e.clock == nil {
+		return time.Now()
+	}
+	return e.clock.now()
+}
+
+func (e *expander) sleep(d time.Duration) {
+	if e.clock == nil {
+		time.Sleep(d)
+		return
+	}
+	e.clock.sleep(d)
+}
+
+// sleepContext is sleep, returning ctx.Err() as soon as ctx is done,
+// rather than once d has passed.
+func (e *expander) sleepContext(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var c <-chan time.Time
+	if e.clock == nil {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		c = t.C
+	} else {
+		c = e.clock.after(d)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c:
+		return nil
+	}
+}
+
+// since returns the time elapsed since t, according to the clock of the
+// expander.
+func (e *expander) since(t time.Time) time.Duration {
+	return e.now().Sub(t)
+}
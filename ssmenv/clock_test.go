@@ -0,0 +1,103 @@
+package ssmenv
+
+import (
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpandEnviron_LatencyWithFakeClock(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	m := newFakeMetrics()
+	clk := newFakeClock()
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		metrics:   m,
+		clock:     clk,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Run(func(mock.Arguments) {
+		clk.advance(250 * time.Millisecond)
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []time.Duration{250 * time.Millisecond}, m.durations[metricLatency])
+
+	c.AssertExpectations(t)
+}
+
+func TestFakeClock(t *testing.T) {
+	clk := newFakeClock()
+	e := expander{clock: clk}
+
+	start := e.now()
+	e.sleep(time.Second)
+	e.sleep(2 * time.Second)
+	clk.advance(time.Minute)
+
+	assert.Equal(t, time.Minute+3*time.Second, e.since(start))
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, clk.sleeps)
+}
+
+// fakeClock is a clock that only moves when it's told to. Sleeping advances
+// it immediately, and is recorded.
+type fakeClock struct {
+	sync.Mutex
+	t      time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.t = c.t.Add(d)
+}
+
+// after is sleep, returning a channel the new time is already sent on.
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now()
+	return ch
+}
+
+// advance moves the clock forward by d.
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
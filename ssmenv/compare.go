@@ -0,0 +1,122 @@
+package ssmenv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// mapEnviron is an environment held in memory, so it can be resolved
+// without changing the environment of the process.
+type mapEnviron map[string]string
+
+func (e mapEnviron) Environ() []string {
+	env := make([]string, 0, len(e))
+	for k, v := range e {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+func (e mapEnviron) Setenv(key, val string) {
+	e[key] = val
+}
+
+func (e mapEnviron) Unsetenv(key string) {
+	delete(e, key)
+}
+
+// prefixRename returns a function replacing the prefix from of parameter
+// names with to, so that the same variables can be resolved from another
+// tree of parameters, like /myapp/staging instead of /myapp/prod.
+func prefixRename(from, to string) func(string) string {
+	return func(name string) string {
+		if strings.HasPrefix(name, from) {
+			return to + strings.TrimPrefix(name, from)
+		}
+		return name
+	}
+}
+
+// difference is a variable whose resolved value isn't the same in two
+// environments. Values are only ever kept as a hash.
+type difference struct {
+	name string
+
+	// a and b are the hashes of the values, or "" if the variable wasn't
+	// resolved.
+	a, b string
+}
+
+func (d difference) String() string {
+	a, b := d.a, d.b
+	if a == "" {
+		a = "unresolved"
+	}
+	if b == "" {
+		b = "unresolved"
+	}
+	return fmt.Sprintf("%s: %s != %s", d.name, a, b)
+}
+
+// compare resolves vars with both a and b, and returns the variables whose
+// resolved values differ, sorted by name.
+func compare(a, b expander, vars map[string]string, decrypt bool, nofail bool) ([]difference, error) {
+	hashes := make([]map[string]string, 2)
+	for i, e := range []*expander{&a, &b} {
+		env := make(mapEnviron)
+		for k, v := range vars {
+			env[k] = v
+		}
+		e.os = env
+		e.stream = nil
+
+		if err := e.expandEnviron(decrypt, nofail); err != nil {
+			return nil, err
+		}
+
+		hashes[i] = make(map[string]string)
+		for _, name := range e.resolvedVars() {
+			hashes[i][name] = valueHash(env[name])
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, h := range hashes {
+		for name := range h {
+			names[name] = true
+		}
+	}
+
+	var diffs []difference
+	for name := range names {
+		if hashes[0][name] != hashes[1][name] {
+			diffs = append(diffs, difference{name, hashes[0][name], hashes[1][name]})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].name < diffs[j].name })
+	return diffs, nil
+}
+
+// valueHash returns a short hash of a value, to tell values apart without
+// revealing them.
+func valueHash(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// printDifferences writes a line for each difference to w, with the names
+// matching r masked.
+func printDifferences(w io.Writer, diffs []difference, r nameRedactor) error {
+	for _, d := range diffs {
+		d.name = r.redact(d.name)
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
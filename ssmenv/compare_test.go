@@ -0,0 +1,79 @@
+package ssmenv
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	prod := new(mockSSM)
+	staging := new(mockSSM)
+	a := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		ssm:       prod,
+		batchSize: defaultBatchSize,
+	}
+	b := a
+	b.ssm = staging
+	b.rename = prefixRename("/myapp/prod/", "/myapp/staging/")
+
+	vars := map[string]string{
+		"API_KEY":     "ssm:///myapp/prod/api-key",
+		"DB_PASSWORD": "ssm:///myapp/prod/db-password",
+		"NEW_SECRET":  "ssm:///myapp/prod/new-secret",
+		"RAILS_ENV":   "production",
+	}
+
+	prod.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/prod/api-key"), aws.String("/myapp/prod/db-password"), aws.String("/myapp/prod/new-secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/prod/api-key"), Value: aws.String("shared")},
+			{Name: aws.String("/myapp/prod/db-password"), Value: aws.String("prod-password")},
+			{Name: aws.String("/myapp/prod/new-secret"), Value: aws.String("new")},
+		},
+	}, nil)
+	staging.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/staging/api-key"), aws.String("/myapp/staging/db-password"), aws.String("/myapp/staging/new-secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/staging/api-key"), Value: aws.String("shared")},
+			{Name: aws.String("/myapp/staging/db-password"), Value: aws.String("staging-password")},
+		},
+		InvalidParameters: []*string{aws.String("/myapp/staging/new-secret")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	diffs, err := compare(a, b, vars, decrypt, nofail)
+	assert.NoError(t, err)
+	assert.Equal(t, []difference{
+		{"DB_PASSWORD", valueHash("prod-password"), valueHash("staging-password")},
+		{"NEW_SECRET", valueHash("new"), ""},
+	}, diffs)
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, printDifferences(out, diffs, nil))
+	assert.Equal(t, "DB_PASSWORD: "+valueHash("prod-password")+" != "+valueHash("staging-password")+"\n"+
+		"NEW_SECRET: "+valueHash("new")+" != unresolved\n", out.String())
+	assert.NotContains(t, out.String(), "prod-password")
+
+	// The given environment isn't changed.
+	assert.Equal(t, "ssm:///myapp/prod/db-password", vars["DB_PASSWORD"])
+
+	prod.AssertExpectations(t)
+	staging.AssertExpectations(t)
+}
+
+func TestPrefixRename(t *testing.T) {
+	rename := prefixRename("/myapp/prod/", "/myapp/staging/")
+	assert.Equal(t, "/myapp/staging/db", rename("/myapp/prod/db"))
+	assert.Equal(t, "/other/prod/db", rename("/other/prod/db"))
+}
@@ -0,0 +1,71 @@
+package ssmenv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Precedence of the kinds of references, when more than one targets the
+// same environment variable. A variable referencing a parameter, or holding
+// KMS ciphertext, itself takes precedence over ssm-json:// references, which
+// take precedence over path references. Between references of the same
+// kind, the first one resolved wins.
+const (
+	precedencePath = iota
+	precedenceJSON
+	precedenceExplicit
+)
+
+// target is the reference an environment variable is set by.
+type target struct {
+	// ref is the name of the variable holding the reference.
+	ref        string
+	precedence int
+}
+
+// claim records that the reference held by the variable ref sets the
+// variable name, and reports whether it should, given the references that
+// already did. Conflicting references are reported as a warning, or as an
+// error with failOnConflict, unless nofail is set.
+func (e *expander) claim(name, ref string, precedence int, nofail bool) (bool, error) {
+	prev, ok := e.targets[name]
+	if !ok {
+		e.targets[name] = target{ref, precedence}
+		return true, nil
+	}
+
+	winner := prev.ref
+	if precedence > prev.precedence {
+		winner = ref
+		e.targets[name] = target{ref, precedence}
+	}
+
+	err := fmt.Errorf("%s is targeted by both %s and %s, using %s", name, prev.ref, ref, winner)
+	if e.failOnConflict && !nofail {
+		return false, err
+	}
+	e.warnings().warn(err)
+	return winner == ref, nil
+}
+
+// setVars sets the environment variables in vars, which are set by the
+// reference held by the variable ref, in sorted order. Variables that were
+// claimed by a reference of higher precedence are skipped.
+func (e *expander) setVars(vars map[string]string, ref string, precedence int, nofail bool) error {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ok, err := e.claim(name, ref, precedence, nofail)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e.setResolved(name, vars[name])
+		}
+	}
+	return nil
+}
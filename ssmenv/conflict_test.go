@@ -0,0 +1,123 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_Conflict(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("APP", "ssm:///myapp/*")
+	os.Setenv("BUNDLE", "ssm-json:///bundle")
+	os.Setenv("DB_PASSWORD", "!kms Y2lwaGVydGV4dA==")
+
+	c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+		Path:           aws.String("/myapp"),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersByPathOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/api-key"), Value: aws.String("from-path")},
+			{Name: aws.String("/myapp/port"), Value: aws.String("5432")},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/bundle")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/bundle"), Value: aws.String(`{"api_key": "from-json", "db_password": "from-json"}`)},
+		},
+	}, nil)
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("from-kms"),
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"API_KEY=from-json",
+		"DB_PASSWORD=from-kms",
+		"PORT=5432",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_FailOnConflict(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:              template.Must(parseTemplate(DefaultTemplate)),
+		os:             os,
+		ssm:            c,
+		batchSize:      defaultBatchSize,
+		failOnConflict: true,
+	}
+
+	os.Setenv("BUNDLE", "ssm-json:///bundle")
+	os.Setenv("DB_PASSWORD", "ssm:///db-password")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/bundle"), aws.String("/db-password")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/bundle"), Value: aws.String(`{"db_password": "from-json"}`)},
+			{Name: aws.String("/db-password"), Value: aws.String("explicit")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "splitting BUNDLE into variables: DB_PASSWORD is targeted by both DB_PASSWORD and BUNDLE, using DB_PASSWORD")
+
+	c.AssertExpectations(t)
+}
+
+func TestClaim(t *testing.T) {
+	e := expander{targets: make(map[string]target)}
+
+	tests := []struct {
+		ref        string
+		precedence int
+		ok         bool
+	}{
+		{"APP", precedencePath, true},
+		{"OTHER_APP", precedencePath, false},
+		{"BUNDLE", precedenceJSON, true},
+		{"OTHER_BUNDLE", precedenceJSON, false},
+		{"APP", precedencePath, false},
+	}
+
+	for _, tt := range tests {
+		ok, err := e.claim("API_KEY", tt.ref, tt.precedence, false)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.ok, ok, tt.ref)
+	}
+	assert.Equal(t, target{"BUNDLE", precedenceJSON}, e.targets["API_KEY"])
+}
@@ -0,0 +1,71 @@
+package ssmenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeCredentials writes each of the named environment variables into dir,
+// as a file named after the variable containing its value. Files are
+// readable only by their owner, which is what systemd expects of
+// credentials loaded with LoadCredential. Existing files are replaced.
+func writeCredentials(dir string, env environ, names []string) error {
+	vars := envMap(env.Environ())
+
+	for _, name := range names {
+		if !validFileName(name) {
+			return fmt.Errorf("can't write %q as a credential: not a valid file name", name)
+		}
+
+		v, ok := vars[name]
+		if !ok {
+			continue
+		}
+
+		if err := writeFileAtomic(filepath.Join(dir, name), []byte(v), 0400); err != nil {
+			return fmt.Errorf("writing credential %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// validFileName reports whether name can be used as a file name on its own,
+// without escaping the directory it's written to.
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path, and renames it into place. Readers never observe a partially
+// written file, and an existing read-only file can be replaced.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
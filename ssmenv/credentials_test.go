@@ -0,0 +1,52 @@
+package ssmenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteCredentials(t *testing.T) {
+	dir := t.TempDir()
+
+	env := newFakeEnviron()
+	env.Setenv("SUPER_SECRET_A", "val-a")
+	env.Setenv("SUPER_SECRET_B", "val-b")
+
+	// An existing, read-only credential is replaced.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "SUPER_SECRET_B"), []byte("old"), 0400))
+
+	err := writeCredentials(dir, env, []string{"SUPER_SECRET_A", "SUPER_SECRET_B"})
+	assert.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	for name, val := range map[string]string{
+		"SUPER_SECRET_A": "val-a",
+		"SUPER_SECRET_B": "val-b",
+	} {
+		path := filepath.Join(dir, name)
+
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0400), info.Mode().Perm())
+
+		b, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, val, string(b))
+	}
+}
+
+func TestWriteCredentials_InvalidName(t *testing.T) {
+	dir := t.TempDir()
+
+	env := newFakeEnviron()
+	env.Setenv("../escape", "val")
+
+	err := writeCredentials(dir, env, []string{"../escape"})
+	assert.EqualError(t, err, `can't write "../escape" as a credential: not a valid file name`)
+}
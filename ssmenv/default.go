@@ -0,0 +1,18 @@
+package ssmenv
+
+import "strings"
+
+// defaultSeparator separates the name of a parameter from the value used
+// when it doesn't exist, as in ssm:///myapp/log-level|info. Parameter names
+// can't contain "|".
+const defaultSeparator = "|"
+
+// splitDefault splits a reference into the name of the parameter and its
+// default value, if it has one.
+func splitDefault(s string) (name string, def *string) {
+	if i := strings.Index(s, defaultSeparator); i >= 0 {
+		v := s[i+len(defaultSeparator):]
+		return s[:i], &v
+	}
+	return s, nil
+}
@@ -0,0 +1,218 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_Default(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("LOG_LEVEL", "ssm:///myapp/log-level|info")
+	os.Setenv("EMPTY", "ssm:///myapp/empty|")
+	os.Setenv("SUPER_SECRET", "ssm:///myapp/secret|not-used")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/empty"), aws.String("/myapp/log-level"), aws.String("/myapp/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/secret"), Value: aws.String("value")},
+		},
+		InvalidParameters: []*string{aws.String("/myapp/empty"), aws.String("/myapp/log-level")},
+	}, nil)
+
+	// Missing parameters with a default aren't an error, even without
+	// -no-fail.
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"EMPTY=",
+		"LOG_LEVEL=info",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=value",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_DefaultSharedParameter(t *testing.T) {
+	tests := []struct {
+		nofail bool
+		err    error
+		out    []string
+	}{
+		// The reference without a default still needs the parameter.
+		{false, &invalidParametersError{InvalidParameters: []string{"/myapp/log-level"}}, []string{
+			"LOG_LEVEL=ssm:///myapp/log-level|info",
+			"OTHER_LOG_LEVEL=ssm:///myapp/log-level",
+			"SHELL=/bin/bash",
+			"TERM=screen-256color",
+		}},
+		{true, nil, []string{
+			"LOG_LEVEL=info",
+			"OTHER_LOG_LEVEL=ssm:///myapp/log-level",
+			"SHELL=/bin/bash",
+			"TERM=screen-256color",
+		}},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+		}
+
+		os.Setenv("LOG_LEVEL", "ssm:///myapp/log-level|info")
+		os.Setenv("OTHER_LOG_LEVEL", "ssm:///myapp/log-level")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("/myapp/log-level")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			InvalidParameters: []*string{aws.String("/myapp/log-level")},
+		}, nil)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		assert.Equal(t, tt.err, err)
+		assert.Equal(t, tt.out, os.Environ())
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_DefaultMissingSentinel(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:               template.Must(parseTemplate(DefaultTemplate)),
+		os:              os,
+		ssm:             c,
+		batchSize:       defaultBatchSize,
+		missingSentinel: "MISSING",
+	}
+
+	os.Setenv("LOG_LEVEL", "ssm:///myapp/log-level|info")
+	os.Setenv("SUPER_SECRET", "ssm:///myapp/secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/log-level"), aws.String("/myapp/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("/myapp/log-level"), aws.String("/myapp/secret")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"LOG_LEVEL=info",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=MISSING",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_DefaultPath(t *testing.T) {
+	os := newFakeEnviron()
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       new(mockSSM),
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("APP", "ssm:///myapp/*|default")
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "APP references the path /myapp, which can't have a default value")
+}
+
+func TestSplitDefault(t *testing.T) {
+	tests := []struct {
+		in   string
+		name string
+		def  *string
+	}{
+		{"/myapp/log-level", "/myapp/log-level", nil},
+		{"/myapp/log-level|info", "/myapp/log-level", aws.String("info")},
+		{"/myapp/log-level|", "/myapp/log-level", aws.String("")},
+		{"/myapp/url|https://example.com/?a=b|c", "/myapp/url", aws.String("https://example.com/?a=b|c")},
+	}
+
+	for _, tt := range tests {
+		name, def := splitDefault(tt.in)
+		assert.Equal(t, tt.name, name, tt.in)
+		assert.Equal(t, tt.def, def, tt.in)
+	}
+}
+
+func TestExpandEnviron_SkipEmpty(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		skipEmpty: true,
+	}
+
+	os.Setenv("LOG_LEVEL", "ssm:///myapp/log-level|info")
+	os.Setenv("EXTRA_FLAGS", "ssm:///myapp/extra-flags")
+	os.Setenv("SUPER_SECRET", "ssm:///myapp/secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/extra-flags"), aws.String("/myapp/log-level"), aws.String("/myapp/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/extra-flags"), Value: aws.String("")},
+			{Name: aws.String("/myapp/log-level"), Value: aws.String("")},
+			{Name: aws.String("/myapp/secret"), Value: aws.String("value")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	// Empty parameters don't overwrite the variable, which gets its
+	// default value if it has one.
+	assert.Equal(t, []string{
+		"EXTRA_FLAGS=ssm:///myapp/extra-flags",
+		"LOG_LEVEL=info",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=value",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
@@ -0,0 +1,144 @@
+package ssmenv
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// parameterNameChars matches the characters SSM allows in parameter names.
+var parameterNameChars = regexp.MustCompile(`^[a-zA-Z0-9_.\-/]+$`)
+
+// checkParameterName returns an error if name, without its selector, can't
+// be the name of an SSM parameter: it has characters SSM doesn't allow, or
+// it's hierarchical without starting with a slash. The error completes
+// "NAME, which". ARNs are left to allowedAccount.
+func checkParameterName(name string) error {
+	if isParameterARN(name) {
+		return nil
+	}
+	base := baseName(name)
+	if !parameterNameChars.MatchString(base) {
+		return errors.New("has characters parameter names can't have")
+	}
+	if strings.Contains(base, "/") && !strings.HasPrefix(base, "/") {
+		return errors.New("has a / but doesn't start with one")
+	}
+	return nil
+}
+
+// dryRun checks every reference in the environment like resolving it would,
+// without making any AWS call, and returns a problem per malformed reference,
+// in the order of the variables' names.
+func (e *expander) dryRun() []error {
+	vars := envMap(e.os.Environ())
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var problems []error
+	schemas := make(map[string]error)
+	for _, k := range names {
+		if !e.considered(k) {
+			continue
+		}
+		if err := e.checkReference(k, vars[k], vars, schemas); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// checkReference checks the value v of the environment variable k, given
+// every variable, and the errors of loading the schemas already loaded.
+func (e *expander) checkReference(k, v string, vars map[string]string, schemas map[string]error) error {
+	v, err := e.preTransform(v)
+	if err != nil {
+		return fmt.Errorf("pre-transforming %s: %v", k, err)
+	}
+
+	if e.isKMSValue(v) {
+		if _, err := e.kmsCiphertext(v); err != nil {
+			return fmt.Errorf("decoding ciphertext of %s: %v", k, err)
+		}
+		return nil
+	}
+
+	if e.vault != nil && hasPrefixFold(v, VaultPrefix) {
+		ref, _ := splitDefault(trimPrefixFold(v, VaultPrefix))
+		if _, _, err := parseVaultReference(ref); err != nil {
+			return fmt.Errorf("%s references Vault: %v", k, err)
+		}
+		return nil
+	}
+
+	ref, err := e.parseReference(k, v)
+	if err != nil {
+		return fmt.Errorf("determining name of parameter for %s: %v", k, err)
+	}
+	spec, isPath := ref.spec, ref.path
+	if spec == nil {
+		if e.interpolate {
+			return e.checkInterpolated(k, v)
+		}
+		return nil
+	}
+
+	if spec.Schema != "" {
+		err, ok := schemas[spec.Schema]
+		if !ok {
+			_, err = loadSchema(spec.Schema)
+			schemas[spec.Schema] = err
+		}
+		if err != nil {
+			return fmt.Errorf("loading schema for %s: %v", k, err)
+		}
+	}
+
+	p, err := expandVars(spec.Name, vars)
+	if err != nil {
+		return fmt.Errorf("determining name of parameter for %s: %v", k, err)
+	}
+	if path, ok := wildcardPath(p); ok {
+		p, isPath = path, true
+	}
+	if e.normalizePaths {
+		p = normalizePath(p)
+	}
+
+	if isPath && spec.Default != nil {
+		return fmt.Errorf("%s references the path %s, which can't have a default value", k, p)
+	}
+	if isPath && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("%s references the path %s, which doesn't start with a /", k, p)
+	}
+	if err := checkParameterName(p); err != nil {
+		return fmt.Errorf("%s references %q, which %v", k, p, err)
+	}
+	if !e.allowedAccount(p) {
+		return fmt.Errorf("%s references %s, which isn't in an allowed account", k, p)
+	}
+	if e.namePattern != nil && !e.namePattern.MatchString(p) {
+		return fmt.Errorf("%s references %s, which doesn't match %s", k, p, e.namePattern)
+	}
+	return nil
+}
+
+// checkInterpolated checks the references embedded in the value v of the
+// environment variable k, with -interpolate.
+func (e *expander) checkInterpolated(k, v string) error {
+	for _, m := range interpolationPattern.FindAllStringSubmatch(v, -1) {
+		p := e.interpolatedName(m[1])
+		if err := checkParameterName(p); err != nil {
+			return fmt.Errorf("%s references %q, which %v", k, p, err)
+		}
+		if e.namePattern != nil && !e.namePattern.MatchString(p) {
+			return fmt.Errorf("%s references %s, which doesn't match %s", k, p, e.namePattern)
+		}
+	}
+	return nil
+}
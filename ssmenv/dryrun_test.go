@@ -0,0 +1,102 @@
+package ssmenv
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_DryRun(t *testing.T) {
+	tests := []struct {
+		env    map[string]string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{
+			map[string]string{
+				"SUPER_SECRET": "ssm:///app/secret",
+				"DOTTED":       "ssm://prod.app.secret",
+				"VERSIONED":    "ssm:///app/secret:3",
+				"APP":          "ssm:///app/*",
+				"SHARED":       "ssm://arn:aws:ssm:us-east-1:111111111111:parameter/shared/secret",
+				"KMS_SECRET":   "!kms " + base64.StdEncoding.EncodeToString([]byte("ciphertext")),
+			},
+			[]string{"-dry-run"},
+			0,
+			"",
+		},
+		{
+			map[string]string{
+				"NO_SLASH":   "ssm://app/secret",
+				"SPACE":      "ssm:///app/my secret",
+				"MISSING":    "ssm:///app/${UNSET}",
+				"KMS_SECRET": "!kms not base64!",
+				"PATH_REF":   "ssm-path://app",
+				"GOOD":       "ssm:///app/secret",
+			},
+			[]string{"-dry-run", "sh"},
+			1,
+			"ssm-env: decoding ciphertext of KMS_SECRET: illegal base64 data at input byte 3\n" +
+				"ssm-env: determining name of parameter for MISSING: /app/${UNSET} references unset environment variables: [UNSET]\n" +
+				"ssm-env: NO_SLASH references \"app/secret\", which has a / but doesn't start with one\n" +
+				"ssm-env: PATH_REF references the path app, which doesn't start with a /\n" +
+				"ssm-env: SPACE references \"/app/my secret\", which has characters parameter names can't have\n",
+		},
+		{
+			map[string]string{"SUPER_SECRET": "ssm:///other/secret"},
+			[]string{"-dry-run", "-name-pattern", "^/app/"},
+			1,
+			"ssm-env: SUPER_SECRET references /other/secret, which doesn't match ^/app/\n",
+		},
+		{
+			map[string]string{"DATABASE_URL": "postgres://ssm:///app/user:ssm://db/pass@host/db"},
+			[]string{"-dry-run", "-interpolate"},
+			1,
+			"ssm-env: DATABASE_URL references \"db/pass\", which has a / but doesn't start with one\n",
+		},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		for k, v := range tt.env {
+			os.Setenv(k, v)
+		}
+
+		// Nothing is expected of the client, so any call fails the test.
+		c := new(mockSSM)
+		code, stdout, stderr := runWith(c, os, tt.args...)
+		assert.Equal(t, tt.code, code, "%v", tt.env)
+		assert.Equal(t, "", stdout)
+		assert.Equal(t, tt.stderr, stderr, "%v", tt.env)
+		c.AssertExpectations(t)
+	}
+}
+
+func TestCheckParameterName(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"secret", ""},
+		{"prod.app.secret", ""},
+		{"/app/secret", ""},
+		{"/app/secret:3", ""},
+		{"/app/secret:Prod-Label", ""},
+		{"arn:aws:ssm:us-east-1:111111111111:parameter/shared/secret", ""},
+		{"app/secret", "has a / but doesn't start with one"},
+		{"/app/my secret", "has characters parameter names can't have"},
+		{"/app/sécret", "has characters parameter names can't have"},
+		{"", "has characters parameter names can't have"},
+	}
+
+	for _, tt := range tests {
+		err := checkParameterName(tt.name)
+		if tt.err == "" {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.EqualError(t, err, tt.err, tt.name)
+		}
+	}
+}
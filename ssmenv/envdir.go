@@ -0,0 +1,36 @@
+package ssmenv
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// writeEnvdir writes each of the named environment variables into dir, in
+// the layout read by daemontools' envdir: a file per variable, named after
+// it, holding its value. Existing files are replaced.
+//
+// envdir only reads the first line of a file, and turns NUL bytes into
+// newlines, so newlines in values are written as NUL bytes. It also strips
+// trailing spaces and tabs, which can't be preserved.
+func writeEnvdir(dir string, env environ, names []string) error {
+	vars := envMap(env.Environ())
+
+	for _, name := range names {
+		if !validFileName(name) || strings.Contains(name, "=") {
+			return fmt.Errorf("can't write %q to an envdir: not a valid file name", name)
+		}
+
+		v, ok := vars[name]
+		if !ok {
+			continue
+		}
+
+		data := strings.Replace(v, "\n", "\x00", -1) + "\n"
+		if err := writeFileAtomic(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			return fmt.Errorf("writing %s to envdir: %v", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package ssmenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteEnvdir(t *testing.T) {
+	dir := t.TempDir()
+
+	env := newFakeEnviron()
+	env.Setenv("SUPER_SECRET_A", "val-a")
+	env.Setenv("SUPER_SECRET_B", "line1\nline2")
+	env.Setenv("EMPTY", "")
+
+	// Existing files are replaced.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "SUPER_SECRET_A"), []byte("old\n"), 0600))
+
+	err := writeEnvdir(dir, env, []string{"EMPTY", "SUPER_SECRET_A", "SUPER_SECRET_B"})
+	assert.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.Equal(t, []string{"EMPTY", "SUPER_SECRET_A", "SUPER_SECRET_B"}, names)
+
+	for name, contents := range map[string]string{
+		"EMPTY":          "\n",
+		"SUPER_SECRET_A": "val-a\n",
+		"SUPER_SECRET_B": "line1\x00line2\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, contents, string(b))
+	}
+}
+
+func TestWriteEnvdir_InvalidName(t *testing.T) {
+	dir := t.TempDir()
+
+	env := newFakeEnviron()
+
+	err := writeEnvdir(dir, env, []string{"A=B"})
+	assert.EqualError(t, err, `can't write "A=B" to an envdir: not a valid file name`)
+
+	err = writeEnvdir(dir, env, []string{"a/b"})
+	assert.EqualError(t, err, `can't write "a/b" to an envdir: not a valid file name`)
+}
@@ -0,0 +1,57 @@
+package ssmenv
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readEnvFile reads the environment variables in an env file, in the format
+// of docker's --env-file: a KEY=VALUE line per variable, with the value used
+// as is, without quotes being removed. Blank lines and lines starting with #
+// are ignored, as are lines without a value, which docker takes from its own
+// environment. A variable set more than once takes its last value.
+func readEnvFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]string)
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		k := strings.TrimSpace(parts[0])
+		if k == "" {
+			return nil, fmt.Errorf("%s:%d: missing variable name", path, i+1)
+		}
+		if len(parts) < 2 {
+			continue
+		}
+		vars[k] = parts[1]
+	}
+	return vars, nil
+}
+
+// loadEnvFile sets the environment variables in the env file at path in env,
+// so they're resolved and passed to the command like the others. Variables
+// already set keep their value, unless override is set.
+func loadEnvFile(env environ, path string, override bool) error {
+	vars, err := readEnvFile(path)
+	if err != nil {
+		return err
+	}
+
+	set := envMap(env.Environ())
+	for k, v := range vars {
+		if _, ok := set[k]; ok && !override {
+			continue
+		}
+		env.Setenv(k, v)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package ssmenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	err := os.WriteFile(path, []byte("# comment\n\nSUPER_SECRET=ssm://secret\r\nQUOTED=\"as is\"\nEMPTY=\nFROM_ENV\nURL=postgres://host/db?a=b\nQUOTED=last\n"), 0600)
+	assert.NoError(t, err)
+
+	vars, err := readEnvFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"SUPER_SECRET": "ssm://secret",
+		"QUOTED":       "last",
+		"EMPTY":        "",
+		"URL":          "postgres://host/db?a=b",
+	}, vars)
+}
+
+func TestReadEnvFile_MissingName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	err := os.WriteFile(path, []byte("A=1\n=2\n"), 0600)
+	assert.NoError(t, err)
+
+	_, err = readEnvFile(path)
+	assert.EqualError(t, err, path+":2: missing variable name")
+}
+
+func TestRun_EnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	err := os.WriteFile(path, []byte("SUPER_SECRET=ssm://secret\nTERM=dumb\n"), 0600)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		args   []string
+		stdout string
+	}{
+		{[]string{"-env-file", path, "-print-all"}, "SHELL=/bin/bash\nSUPER_SECRET=value\nTERM=screen-256color\n"},
+		{[]string{"-env-file", path, "-env-file-override", "-print-all"}, "SHELL=/bin/bash\nSUPER_SECRET=value\nTERM=dumb\n"},
+	}
+
+	for _, tt := range tests {
+		c := new(mockSSM)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret"), Value: aws.String("value")},
+			},
+		}, nil)
+
+		code, stdout, stderr := runWith(c, newFakeEnviron(), tt.args...)
+		assert.Equal(t, 0, code, stderr)
+		assert.Equal(t, tt.stdout, stdout, "%v", tt.args)
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestRun_EnvFileMissing(t *testing.T) {
+	code, _, stderr := runWith(new(mockSSM), newFakeEnviron(), "-env-file", filepath.Join(t.TempDir(), "missing.env"), "-print")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "no such file or directory")
+}
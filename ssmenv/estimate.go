@@ -0,0 +1,159 @@
+package ssmenv
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// estimate is the number of AWS API calls, and of Vault reads, resolving the
+// environment makes.
+type estimate struct {
+	GetParameters       int
+	GetParametersByPath int
+	DescribeParameters  int
+	Decrypt             int
+
+	// VaultRead is the number of secrets read from Vault, with -vault.
+	VaultRead int
+}
+
+// estimate returns the number of calls expandEnviron would make, without
+// making any. The parameters of references embedded with -interpolate are
+// fetched in batches of their own, and every Vault secret is read once. The
+// counts are a lower bound: paths with more than a page of parameters,
+// retries, placeholder values, Secrets Manager fallbacks and KMS ciphertext
+// held in SSM parameters all make calls that can't be known without
+// resolving anything.
+func (e *expander) estimate(decrypt bool) (*estimate, error) {
+	var est estimate
+
+	envvars := e.os.Environ()
+	vars := envMap(envvars)
+
+	uniqNames := make(map[bool]map[string]bool)
+	uniqCiphertexts := make(map[string]bool)
+	uniqInterpolated := make(map[string]bool)
+	uniqSecrets := make(map[string]bool)
+	for _, envvar := range envvars {
+		k, v := splitVar(envvar)
+
+		if !e.considered(k) {
+			continue
+		}
+
+		v, err := e.preTransform(v)
+		if err != nil {
+			return nil, fmt.Errorf("pre-transforming %s: %v", k, err)
+		}
+
+		if e.isKMSValue(v) {
+			// Variables holding the same ciphertext share a Decrypt call.
+			uniqCiphertexts[v] = true
+			continue
+		}
+
+		if e.vault != nil && hasPrefixFold(v, VaultPrefix) {
+			ref, _ := splitDefault(trimPrefixFold(v, VaultPrefix))
+			path, _, err := parseVaultReference(ref)
+			if err != nil {
+				return nil, fmt.Errorf("%s references Vault: %v", k, err)
+			}
+			uniqSecrets[path] = true
+			continue
+		}
+
+		ref, err := e.parseReference(k, v)
+		if err != nil {
+			return nil, fmt.Errorf("determining name of parameter for %s: %v", k, err)
+		}
+		spec := ref.spec
+		if spec == nil {
+			if e.interpolate {
+				for _, m := range interpolationPattern.FindAllStringSubmatch(v, -1) {
+					uniqInterpolated[e.interpolatedName(m[1])] = true
+				}
+			}
+			continue
+		}
+		if ref.path {
+			est.GetParametersByPath++
+			continue
+		}
+
+		p, err := expandVars(spec.Name, vars)
+		if err != nil {
+			return nil, fmt.Errorf("determining name of parameter for %s: %v", k, err)
+		}
+		if _, isPath := wildcardPath(p); isPath {
+			est.GetParametersByPath++
+			continue
+		}
+		if e.normalizePaths {
+			p = normalizePath(p)
+		}
+
+		d := decrypt
+		if spec.Decrypt != nil {
+			d = *spec.Decrypt
+		}
+		if uniqNames[d] == nil {
+			uniqNames[d] = make(map[string]bool)
+		}
+		uniqNames[d][p] = true
+	}
+
+	est.Decrypt = len(uniqCiphertexts)
+	if e.maxKMSDecrypts > 0 && est.Decrypt > e.maxKMSDecrypts {
+		est.Decrypt = e.maxKMSDecrypts
+	}
+
+	addBatches := func(uniq map[string]bool) {
+		names := make([]string, 0, len(uniq))
+		for k := range uniq {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for _, b := range e.batches(names) {
+			est.GetParameters++
+			if e.denyAdvancedTier {
+				est.DescribeParameters++
+			}
+			if e.verifyChecksum && hasUnversioned(b) {
+				est.GetParameters++
+			}
+		}
+	}
+	for _, d := range []bool{false, true} {
+		addBatches(uniqNames[d])
+	}
+	addBatches(uniqInterpolated)
+	est.VaultRead = len(uniqSecrets)
+
+	return &est, nil
+}
+
+// hasUnversioned reports whether any of names is without a version or label
+// selector, so that a checksum is looked up for it.
+func hasUnversioned(names []string) bool {
+	for _, name := range names {
+		if baseName(name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// print writes the number of calls of each kind, and their total, to w.
+func (est *estimate) print(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "ssm:GetParameters %d\nssm:GetParametersByPath %d\nssm:DescribeParameters %d\nkms:Decrypt %d\nvault:read %d\ntotal %d\n",
+		est.GetParameters,
+		est.GetParametersByPath,
+		est.DescribeParameters,
+		est.Decrypt,
+		est.VaultRead,
+		est.GetParameters+est.GetParametersByPath+est.DescribeParameters+est.Decrypt+est.VaultRead,
+	)
+	return err
+}
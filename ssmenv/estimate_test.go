@@ -0,0 +1,125 @@
+package ssmenv
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		tmpl      string
+		env       map[string]string
+		batchSize int
+		advanced  bool
+		checksum  bool
+		maxKMS    int
+		interp    bool
+		vault     bool
+		est       estimate
+	}{
+		{
+			env:       map[string]string{"A": "ssm://a", "B": "ssm://b", "C": "ssm://a"},
+			batchSize: defaultBatchSize,
+			est:       estimate{GetParameters: 1},
+		},
+		{
+			env:       map[string]string{"A": "ssm://a", "B": "ssm://b", "C": "ssm://c"},
+			batchSize: 2,
+			est:       estimate{GetParameters: 2},
+		},
+		{
+			tmpl:      `{{ if hasPrefix .Value "plain://" }}{"name": "{{ trimPrefix .Value "plain://" }}", "decrypt": false}{{ end }}`,
+			env:       map[string]string{"A": "ssm-json://a", "B": "plain://b", "C": "ssm-or-sm://a"},
+			batchSize: defaultBatchSize,
+			est:       estimate{GetParameters: 2},
+		},
+		{
+			env:       map[string]string{"A": "ssm://a", "B": "ssm://b:1", "C": "ssm:///app/*", "D": "!kms Y2lwaGVydGV4dA==", "E": "!kms Zm9v"},
+			batchSize: 1,
+			advanced:  true,
+			checksum:  true,
+			est:       estimate{GetParameters: 3, GetParametersByPath: 1, DescribeParameters: 2, Decrypt: 2},
+		},
+		{
+			env:       map[string]string{"A": "ssm://a", "B": "ssm://a|fallback", "C": "ssm://b|"},
+			batchSize: 1,
+			est:       estimate{GetParameters: 2},
+		},
+		{
+			env:       map[string]string{"D": "!kms Y2lwaGVydGV4dA==", "E": "!kms Y2lwaGVydGV4dA==", "F": "!kms Zm9v"},
+			batchSize: defaultBatchSize,
+			est:       estimate{Decrypt: 2},
+		},
+		{
+			env:       map[string]string{"D": "!kms Y2lwaGVydGV4dA==", "E": "!kms Zm9v", "F": "!kms YmFy"},
+			batchSize: defaultBatchSize,
+			maxKMS:    2,
+			est:       estimate{Decrypt: 2},
+		},
+		{
+			// Embedded references are fetched apart from the others.
+			env:       map[string]string{"A": "ssm://a", "URL": "postgres://ssm://db/user:ssm://db/pass@host/db", "DSN": "ssm://db/user@host"},
+			batchSize: defaultBatchSize,
+			interp:    true,
+			est:       estimate{GetParameters: 2},
+		},
+		{
+			env:       map[string]string{"URL": "postgres://ssm://db/user:ssm://db/pass@host/db"},
+			batchSize: defaultBatchSize,
+			est:       estimate{},
+		},
+		{
+			env:       map[string]string{"A": "vault://secret/data/app#a", "B": "vault://secret/data/app#b|default", "C": "vault://secret/data/other#c"},
+			batchSize: defaultBatchSize,
+			vault:     true,
+			est:       estimate{VaultRead: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		tmpl := DefaultTemplate
+		if tt.tmpl != "" {
+			tmpl = tt.tmpl
+		}
+
+		os := fakeEnviron{}
+		c := new(mockSSM)
+		k := new(mockKMS)
+		e := expander{
+			t:                template.Must(parseTemplate(tmpl)),
+			os:               os,
+			ssm:              c,
+			kms:              k,
+			batchSize:        tt.batchSize,
+			denyAdvancedTier: tt.advanced,
+			verifyChecksum:   tt.checksum,
+			maxKMSDecrypts:   tt.maxKMS,
+			interpolate:      tt.interp,
+		}
+		if tt.vault {
+			e.vault = new(fakeVault)
+		}
+		for name, v := range tt.env {
+			os.Setenv(name, v)
+		}
+
+		decrypt := true
+		est, err := e.estimate(decrypt)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.est, *est, "%v", tt.env)
+
+		// Nothing is resolved.
+		c.AssertExpectations(t)
+		k.AssertExpectations(t)
+	}
+}
+
+func TestEstimatePrint(t *testing.T) {
+	b := new(bytes.Buffer)
+	est := estimate{GetParameters: 3, GetParametersByPath: 1, Decrypt: 2, VaultRead: 1}
+	assert.NoError(t, est.print(b))
+	assert.Equal(t, "ssm:GetParameters 3\nssm:GetParametersByPath 1\nssm:DescribeParameters 0\nkms:Decrypt 2\nvault:read 1\ntotal 7\n", b.String())
+}
@@ -0,0 +1,37 @@
+package ssmenv
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// envExample returns a KEY= line for every environment variable holding a
+// reference one of the phases resolves, in the format of a .env.example
+// file. It lists the variables an application needs without any of their
+// values, so nothing is resolved.
+//
+// The variables set from ssm-json:// and path references aren't known
+// without resolving them, so the variable holding the reference is listed
+// instead.
+func (e *expander) envExample() ([]byte, error) {
+	b := new(bytes.Buffer)
+	for _, envvar := range e.os.Environ() {
+		k, v := splitVar(envvar)
+
+		tv, err := e.preTransform(v)
+		if err != nil {
+			return nil, fmt.Errorf("pre-transforming %s: %v", k, err)
+		}
+
+		if !e.isReference(k, v) {
+			// isReference doesn't tell a template that fails from one
+			// that doesn't return a name.
+			if _, err := e.parameter(k, tv); err != nil {
+				return nil, fmt.Errorf("determining name of parameter for %s: %v", k, err)
+			}
+			continue
+		}
+		fmt.Fprintf(b, "%s=\n", k)
+	}
+	return b.Bytes(), nil
+}
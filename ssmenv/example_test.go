@@ -0,0 +1,64 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvExample(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("COOKIE_SECRET", "ssm:///prod/cookie-secret")
+	os.Setenv("DB_PASSWORD", "ssm-or-sm:///prod/db-password")
+	os.Setenv("BUNDLE", "ssm-json:///prod/bundle")
+	os.Setenv("API_KEY", "!kms Y2lwaGVydGV4dA==")
+	os.Setenv("SIGNING_KEY", "ssm+secure:///prod/signing-key")
+	os.Setenv("TOKEN", "ssm+required:///prod/token")
+	os.Setenv("RAILS_ENV", "production")
+
+	b, err := e.envExample()
+	assert.NoError(t, err)
+	assert.Equal(t, "API_KEY=\nBUNDLE=\nCOOKIE_SECRET=\nDB_PASSWORD=\nSIGNING_KEY=\nTOKEN=\n", string(b))
+
+	// Nothing is resolved.
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestEnvExample_Vault(t *testing.T) {
+	for _, vault := range []bool{false, true} {
+		os := newFakeEnviron()
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       new(mockSSM),
+			batchSize: defaultBatchSize,
+		}
+		if vault {
+			e.vault = new(fakeVault)
+		}
+
+		os.Setenv("COOKIE_SECRET", "ssm:///prod/cookie-secret")
+		os.Setenv("DB_PASSWORD", "vault://secret/data/myapp#password")
+
+		b, err := e.envExample()
+		assert.NoError(t, err)
+		if vault {
+			assert.Equal(t, "COOKIE_SECRET=\nDB_PASSWORD=\n", string(b))
+		} else {
+			// Without -vault, vault:// values aren't references.
+			assert.Equal(t, "COOKIE_SECRET=\n", string(b))
+		}
+	}
+}
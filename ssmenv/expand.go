@@ -0,0 +1,129 @@
+package ssmenv
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Expander resolves the references in environments, like ssm-env does with
+// its default flags, so that programs can resolve them without running
+// ssm-env. The zero value is ready to use.
+type Expander struct {
+	// Template determines the name of the parameter every variable
+	// references, like -template. DefaultTemplate is used if it's empty.
+	Template string
+
+	// WithDecryption decrypts SecureString parameters, like
+	// -with-decryption.
+	WithDecryption bool
+
+	// NoFail leaves the references that can't be resolved in place,
+	// instead of returning an error, like -no-fail.
+	NoFail bool
+
+	// Log is where warnings, like the ones about references left in place
+	// with NoFail, are written. They're written to os.Stderr if it's nil.
+	Log io.Writer
+
+	// SSM, SecretsManager and KMS are the clients references are resolved
+	// with. The ones that aren't set are created from the AWS
+	// configuration of the process the first time they're needed.
+	SSM            SSMClient
+	SecretsManager SecretsManagerClient
+	KMS            KMSClient
+
+	// ResolveVault resolves vault:// references, like -vault. They're left
+	// alone otherwise.
+	ResolveVault bool
+
+	// Vault is the client vault:// references are resolved with, with
+	// ResolveVault. If it isn't set, it's created from VAULT_ADDR and
+	// VAULT_TOKEN the first time it's needed.
+	Vault VaultClient
+}
+
+// Expand resolves the references in environ, a list of KEY=value strings
+// like os.Environ returns, with a zero Expander.
+func Expand(environ []string) ([]string, error) {
+	return new(Expander).Expand(environ)
+}
+
+// Expand returns environ, a list of KEY=value strings like os.Environ
+// returns, with the references it holds resolved, sorted by name. environ
+// isn't modified.
+func (x *Expander) Expand(environ []string) ([]string, error) {
+	return x.ExpandWithContext(context.Background(), environ)
+}
+
+// ExpandWithContext is Expand, with no new requests being made once ctx is
+// done.
+func (x *Expander) ExpandWithContext(ctx context.Context, environ []string) ([]string, error) {
+	env := mapEnviron(envMap(environ))
+	e, err := x.expander(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.expandEnvironWithContext(ctx, x.WithDecryption, x.NoFail); err != nil {
+		return nil, err
+	}
+	return env.Environ(), nil
+}
+
+// ExpandTo resolves the references in the environment of the process, and
+// writes the variables they're in to w in format, one of the -format
+// formats, as soon as they're resolved, like -format does. The environment
+// of the process isn't modified.
+func (x *Expander) ExpandTo(w io.Writer, format string) error {
+	e, err := x.expander(mapEnviron(envMap(os.Environ())))
+	if err != nil {
+		return err
+	}
+	return e.expandTo(w, format, x.WithDecryption, x.NoFail)
+}
+
+// expander returns the expander resolving the references in env with the
+// settings of x.
+func (x *Expander) expander(env environ) (*expander, error) {
+	templateText := x.Template
+	if templateText == "" {
+		templateText = DefaultTemplate
+	}
+	t, err := parseTemplate(templateText)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &expander{
+		batchSize:      defaultBatchSize,
+		t:              t,
+		ssm:            x.SSM,
+		sm:             x.SecretsManager,
+		kms:            x.KMS,
+		os:             env,
+		clock:          realClock{},
+		ssmConcurrency: defaultSSMConcurrency,
+		kmsConcurrency: defaultKMSConcurrency,
+	}
+	if x.Log != nil {
+		e.log = &logger{w: x.Log}
+	}
+	sessions := new(sessionProvider)
+	if e.ssm == nil {
+		e.ssm = &lazySSMClient{sessions: sessions}
+	}
+	if e.sm == nil {
+		e.sm = &lazySecretsManagerClient{sessions: sessions}
+	}
+	if e.kms == nil {
+		e.kms = &lazyKMSClient{sessions: sessions}
+	}
+	if x.ResolveVault {
+		e.vault = x.Vault
+		if e.vault == nil {
+			e.vault = &lazyVaultClient{}
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,102 @@
+package ssmenv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpander_Expand(t *testing.T) {
+	c := new(mockSSM)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/app/secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/app/secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	environ := []string{"SUPER_SECRET=ssm:///app/secret", "TERM=screen-256color"}
+	x := &Expander{WithDecryption: true, SSM: c}
+	env, err := x.Expand(environ)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SUPER_SECRET=hehe", "TERM=screen-256color"}, env)
+
+	// The environment given isn't modified.
+	assert.Equal(t, []string{"SUPER_SECRET=ssm:///app/secret", "TERM=screen-256color"}, environ)
+
+	c.AssertExpectations(t)
+}
+
+func TestExpander_ExpandNoFail(t *testing.T) {
+	tests := []struct {
+		nofail bool
+		env    []string
+		err    error
+		log    string
+	}{
+		{false, nil, &invalidParametersError{InvalidParameters: []string{"secret"}}, ""},
+		{true, []string{"SUPER_SECRET=ssm://secret"}, nil, "ssm-env: invalid parameters: [secret]\n"},
+	}
+
+	for _, tt := range tests {
+		c := new(mockSSM)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			InvalidParameters: []*string{aws.String("secret")},
+		}, nil)
+
+		log := new(bytes.Buffer)
+		x := &Expander{NoFail: tt.nofail, Log: log, SSM: c}
+		env, err := x.Expand([]string{"SUPER_SECRET=ssm://secret"})
+		assert.Equal(t, tt.err, err)
+		assert.Equal(t, tt.env, env)
+		assert.Equal(t, tt.log, log.String())
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpander_ExpandTemplate(t *testing.T) {
+	x := &Expander{Template: "{{ .Value"}
+	_, err := x.Expand(nil)
+	assert.Error(t, err)
+}
+
+func TestExpander_ExpandTo(t *testing.T) {
+	t.Setenv("SUPER_SECRET", "ssm:///app/secret")
+
+	c := new(mockSSM)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/app/secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/app/secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	b := new(bytes.Buffer)
+	x := &Expander{WithDecryption: true, SSM: c}
+	err := x.ExpandTo(b, FormatJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"SUPER_SECRET":"hehe"}`+"\n", b.String())
+
+	// The environment of the process isn't modified.
+	assert.Equal(t, "ssm:///app/secret", os.Getenv("SUPER_SECRET"))
+
+	c.AssertExpectations(t)
+}
+
+func TestExpander_ExpandToUnknownFormat(t *testing.T) {
+	x := new(Expander)
+	err := x.ExpandTo(new(bytes.Buffer), "yaml")
+	assert.EqualError(t, err, `unknown format "yaml"`)
+}
@@ -0,0 +1,44 @@
+package ssmenv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// filterResolved runs the filter template for every environment variable
+// that was resolved, and unsets the ones it returns a false value for.
+func (e *expander) filterResolved() error {
+	vars := envMap(e.os.Environ())
+	for _, k := range e.resolvedVars() {
+		keep, err := e.keep(k, vars[k])
+		if err != nil {
+			return fmt.Errorf("filtering %s: %v", k, err)
+		}
+
+		if !keep {
+			e.os.Unsetenv(k)
+			delete(e.resolved, k)
+		}
+	}
+	return nil
+}
+
+// keep reports whether the filter template returns a true value for an
+// environment variable. Empty output, and anything strconv.ParseBool parses
+// as false, such as "false" or "0", are false. Everything else is true.
+func (e *expander) keep(k, v string) (bool, error) {
+	b := new(strings.Builder)
+	if err := e.filter.Execute(b, struct{ Name, Value string }{k, v}); err != nil {
+		return false, err
+	}
+
+	out := strings.TrimSpace(b.String())
+	if out == "" {
+		return false, nil
+	}
+	if keep, err := strconv.ParseBool(out); err == nil {
+		return keep, nil
+	}
+	return true, nil
+}
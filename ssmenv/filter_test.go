@@ -0,0 +1,73 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_Filter(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		filter:    template.Must(parseTemplate(`{{ ne .Value "disabled" }}`)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("FEATURE_A", "ssm://feature-a")
+	os.Setenv("FEATURE_B", "ssm://feature-b")
+	os.Setenv("UNRESOLVED", "disabled")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("feature-a"), aws.String("feature-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("feature-a"), Value: aws.String("enabled")},
+			{Name: aws.String("feature-b"), Value: aws.String("disabled")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"FEATURE_A=enabled",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+		"UNRESOLVED=disabled",
+	}, os.Environ())
+	assert.Equal(t, []string{"FEATURE_A"}, e.resolvedVars())
+
+	c.AssertExpectations(t)
+}
+
+func TestKeep(t *testing.T) {
+	tests := []struct {
+		out  string
+		keep bool
+	}{
+		{"", false},
+		{"  ", false},
+		{"false", false},
+		{"0", false},
+		{"true", true},
+		{"1", true},
+		{"yes", true},
+	}
+
+	for _, tt := range tests {
+		e := expander{filter: template.Must(parseTemplate(tt.out))}
+		keep, err := e.keep("NAME", "value")
+		assert.NoError(t, err)
+		assert.Equal(t, tt.keep, keep, "%q", tt.out)
+	}
+}
@@ -0,0 +1,95 @@
+package ssmenv
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// iamPolicyVersion is the version of the IAM policy language.
+const iamPolicyVersion = "2012-10-17"
+
+// iamReport accumulates the IAM actions exercised during a run, and the
+// resources they were exercised on, for -report-iam. It's safe for
+// concurrent use, and a nil *iamReport records nothing.
+type iamReport struct {
+	mu        sync.Mutex
+	resources map[string]map[string]bool
+}
+
+func newIAMReport() *iamReport {
+	return &iamReport{resources: make(map[string]map[string]bool)}
+}
+
+// add records that action was exercised on the resources. Empty resources,
+// like the ARNs of parameters that don't exist, are skipped.
+func (r *iamReport) add(action string, resources ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, resource := range resources {
+		if resource == "" {
+			continue
+		}
+		if r.resources[action] == nil {
+			r.resources[action] = make(map[string]bool)
+		}
+		r.resources[action][resource] = true
+	}
+}
+
+// iamPolicy is an IAM policy document.
+type iamPolicy struct {
+	Version   string
+	Statement []iamStatement
+}
+
+type iamStatement struct {
+	Effect   string
+	Action   string
+	Resource []string
+}
+
+// policy returns the policy document allowing exactly the actions and
+// resources that were recorded, with a statement per action.
+func (r *iamReport) policy() iamPolicy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := iamPolicy{Version: iamPolicyVersion, Statement: []iamStatement{}}
+	for action, resources := range r.resources {
+		s := iamStatement{Effect: "Allow", Action: action}
+		for resource := range resources {
+			s.Resource = append(s.Resource, resource)
+		}
+		sort.Strings(s.Resource)
+		p.Statement = append(p.Statement, s)
+	}
+	sort.Slice(p.Statement, func(i, j int) bool { return p.Statement[i].Action < p.Statement[j].Action })
+	return p
+}
+
+// write writes the policy document to w, as indented JSON.
+func (r *iamReport) write(w io.Writer) error {
+	b, err := json.MarshalIndent(r.policy(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// pathARN returns the ARN of the path a parameter was found under, given the
+// name and ARN of the parameter, or "" if the ARN doesn't end with the name.
+func pathARN(path, name, arn string) string {
+	name = strings.TrimPrefix(name, "/")
+	if !strings.HasSuffix(arn, name) {
+		return ""
+	}
+	return strings.TrimSuffix(arn, name) + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,124 @@
+package ssmenv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_ReportIAM(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+		iam:       newIAMReport(),
+	}
+
+	os.Setenv("APP", "ssm:///myapp/*")
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+	os.Setenv("MISSING_SECRET", "ssm://missing")
+	os.Setenv("KMS_SECRET", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+	c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+		Path:           aws.String("/myapp"),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersByPathOutput{
+		Parameters: []*ssm.Parameter{
+			{
+				ARN:   aws.String("arn:aws:ssm:us-east-1:123456789012:parameter/myapp/port"),
+				Name:  aws.String("/myapp/port"),
+				Value: aws.String("5432"),
+			},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("missing"), aws.String("secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{
+				ARN:   aws.String("arn:aws:ssm:us-east-1:123456789012:parameter/secret"),
+				Name:  aws.String("secret"),
+				Value: aws.String("value"),
+			},
+		},
+		InvalidParameters: []*string{aws.String("missing")},
+	}, nil)
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		KeyId:     aws.String("arn:aws:kms:us-east-1:123456789012:key/1234abcd"),
+		Plaintext: []byte("value-kms"),
+	}, nil)
+
+	decrypt := true
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, iamPolicy{
+		Version: "2012-10-17",
+		Statement: []iamStatement{
+			{Effect: "Allow", Action: "kms:Decrypt", Resource: []string{"arn:aws:kms:us-east-1:123456789012:key/1234abcd"}},
+			{Effect: "Allow", Action: "ssm:GetParameters", Resource: []string{"arn:aws:ssm:us-east-1:123456789012:parameter/secret"}},
+			{Effect: "Allow", Action: "ssm:GetParametersByPath", Resource: []string{"arn:aws:ssm:us-east-1:123456789012:parameter/myapp"}},
+		},
+	}, e.iam.policy())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestIAMReport_Write(t *testing.T) {
+	r := newIAMReport()
+	r.add("ssm:GetParameters", "arn:b", "arn:a", "")
+	r.add("ssm:GetParameters", "arn:a")
+	r.add("ssm:DescribeParameters", "*")
+
+	b := new(bytes.Buffer)
+	err := r.write(b)
+	assert.NoError(t, err)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &doc))
+	assert.Equal(t, map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []interface{}{
+			map[string]interface{}{"Effect": "Allow", "Action": "ssm:DescribeParameters", "Resource": []interface{}{"*"}},
+			map[string]interface{}{"Effect": "Allow", "Action": "ssm:GetParameters", "Resource": []interface{}{"arn:a", "arn:b"}},
+		},
+	}, doc)
+}
+
+func TestIAMReport_Nil(t *testing.T) {
+	var r *iamReport
+	r.add("ssm:GetParameters", "arn:a")
+}
+
+func TestPathARN(t *testing.T) {
+	tests := []struct {
+		path, name, arn string
+		out             string
+	}{
+		{"/myapp", "/myapp/db/password", "arn:aws:ssm:us-east-1:123456789012:parameter/myapp/db/password", "arn:aws:ssm:us-east-1:123456789012:parameter/myapp"},
+		{"/myapp/", "/myapp/port", "arn:aws:ssm:us-east-1:123456789012:parameter/myapp/port", "arn:aws:ssm:us-east-1:123456789012:parameter/myapp/"},
+		{"/myapp", "/myapp/port", "", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, pathARN(tt.path, tt.name, tt.arn))
+	}
+}
@@ -0,0 +1,129 @@
+package ssmenv
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// interpolationPattern matches the references embedded in a value with
+// -interpolate, like the two in postgres://ssm:///db/user:ssm:///db/pass@host/db.
+// A reference ends at the first character parameter names can't have, so
+// selectors, default values and schemas aren't supported.
+var interpolationPattern = regexp.MustCompile(`(?i)ssm://([a-zA-Z0-9_.\-/]+)`)
+
+// interpolationPhase returns the phase that replaces every reference
+// embedded in the values of environment variables that aren't a reference
+// themselves.
+func (e *expander) interpolationPhase() phase {
+	return phase{
+		expand: e.expandInterpolated,
+		matches: func(k, v string) bool {
+			return !e.isSSMReference(k, v) && interpolationPattern.MatchString(v)
+		},
+	}
+}
+
+// expandInterpolated resolves the references embedded in environment
+// variables. The parameters of every variable are fetched together, and a
+// variable is only set if all of its parameters were resolved.
+func (e *expander) expandInterpolated(ctx context.Context, decrypt bool, nofail bool) error {
+	refs := make(map[string]string)
+	uniq := make(map[string]bool)
+	for _, envvar := range e.os.Environ() {
+		k, v := splitVar(envvar)
+
+		if !e.considered(k) || e.resolved[k] {
+			continue
+		}
+
+		v, err := e.preTransform(v)
+		if err != nil {
+			return fmt.Errorf("pre-transforming %s: %v", k, err)
+		}
+		if e.isSSMReference(k, v) {
+			continue
+		}
+
+		matches := interpolationPattern.FindAllStringSubmatch(v, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var names []string
+		for _, m := range matches {
+			p := e.interpolatedName(m[1])
+			if e.namePattern != nil && !e.namePattern.MatchString(p) {
+				err := fmt.Errorf("%s references %s, which doesn't match %s", k, p, e.namePattern)
+				e.count(metricFailed, 1)
+				if !nofail {
+					return err
+				}
+				e.warnings().warn(err)
+				names = nil
+				break
+			}
+			names = append(names, p)
+		}
+		if names == nil {
+			continue
+		}
+
+		e.logf("%s: parameters %v", k, names)
+		for _, p := range names {
+			uniq[p] = true
+		}
+		refs[k] = v
+	}
+
+	if len(uniq) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(uniq))
+	for p := range uniq {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	values, err := e.fetchParameters(ctx, names, nil, decrypt, nofail)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(refs))
+	for k := range refs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		resolved := true
+		val := interpolationPattern.ReplaceAllStringFunc(refs[k], func(ref string) string {
+			p := e.interpolatedName(interpolationPattern.FindStringSubmatch(ref)[1])
+			v, ok := values[p]
+			if !ok {
+				resolved = false
+			}
+			return v
+		})
+		if resolved {
+			e.targets[k] = target{k, precedenceExplicit}
+			e.setResolved(k, val)
+		}
+	}
+	return nil
+}
+
+// interpolatedName returns the name of the parameter an embedded reference
+// is looked up as, normalized and renamed like any other reference.
+func (e *expander) interpolatedName(name string) string {
+	if e.normalizePaths {
+		name = normalizePath(name)
+	}
+	if e.rename != nil {
+		name = e.rename(name)
+	}
+	return name
+}
@@ -0,0 +1,51 @@
+package ssmenv
+
+import (
+	"math/rand"
+	"time"
+)
+
+// waitStartupJitter sleeps for a random duration of up to startupJitter
+// before the first AWS call, so that a fleet of containers starting at once
+// doesn't make its calls at once too. Nothing is waited for when the
+// environment doesn't hold any references.
+func (e *expander) waitStartupJitter() {
+	if e.startupJitter <= 0 || !e.hasReferences() {
+		return
+	}
+
+	random := e.random
+	if random == nil {
+		random = rand.Int63n
+	}
+	e.sleep(time.Duration(random(int64(e.startupJitter))))
+}
+
+// hasReferences reports whether any environment variable holds a reference
+// that one of the phases resolves.
+func (e *expander) hasReferences() bool {
+	for _, envvar := range e.os.Environ() {
+		k, v := splitVar(envvar)
+
+		if e.considered(k) && e.isReference(k, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReference reports whether v, the value of the environment variable k, is
+// a reference one of the phases resolves.
+func (e *expander) isReference(k, v string) bool {
+	v, err := e.preTransform(v)
+	if err != nil {
+		// The error is returned when resolving.
+		return true
+	}
+	for _, p := range e.phases() {
+		if p.matches(k, v) {
+			return true
+		}
+	}
+	return false
+}
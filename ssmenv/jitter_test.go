@@ -0,0 +1,76 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpandEnviron_StartupJitter(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	clk := newFakeClock()
+	var max int64
+	e := expander{
+		t:             template.Must(parseTemplate(DefaultTemplate)),
+		os:            os,
+		ssm:           c,
+		batchSize:     defaultBatchSize,
+		clock:         clk,
+		startupJitter: 4 * time.Second,
+		random: func(n int64) int64 {
+			max = n
+			return n / 4
+		},
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Run(func(mock.Arguments) {
+		// The delay was waited for before the first call.
+		assert.Equal(t, []time.Duration{time.Second}, clk.sleeps)
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(4*time.Second), max)
+	assert.Equal(t, []time.Duration{time.Second}, clk.sleeps)
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_StartupJitterNoReferences(t *testing.T) {
+	os := newFakeEnviron()
+	clk := newFakeClock()
+	e := expander{
+		t:             template.Must(parseTemplate(DefaultTemplate)),
+		os:            os,
+		batchSize:     defaultBatchSize,
+		clock:         clk,
+		startupJitter: 4 * time.Second,
+	}
+
+	os.Setenv("RAILS_ENV", "production")
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Empty(t, clk.sleeps)
+}
@@ -0,0 +1,150 @@
+package ssmenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// JSONPrefix marks an environment variable value as a reference to an SSM
+// parameter holding a JSON object. Each top-level key of the object is set as
+// its own environment variable, and the variable holding the reference is
+// unset.
+const JSONPrefix = "ssm-json://"
+
+// setJSONVars sets an environment variable for each key of the JSON object
+// held by the variable k, and unsets k.
+func (e *expander) setJSONVars(k, v string, nofail bool) error {
+	vars, err := jsonVars(v, e.flattenJSON, e.keyCase)
+	if err != nil {
+		return err
+	}
+
+	e.os.Unsetenv(k)
+	return e.setVars(vars, k, precedenceJSON, nofail)
+}
+
+// jsonVars returns the environment variables for a JSON object. Keys are
+// turned into environment variable names with kc. Nested objects are an
+// error, unless flatten is set, in which case their keys are joined to the
+// key of the parent with an underscore. Strings are used as is, and other
+// values as their JSON encoding.
+func jsonVars(s string, flatten bool, kc keyCase) (map[string]string, error) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+
+	var obj map[string]interface{}
+	if err := d.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("parsing JSON object: %v", err)
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("parsing JSON object: got null")
+	}
+
+	vars := make(map[string]string)
+	if err := addJSONVars(vars, make(map[string]string), "", obj, flatten, kc); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// addJSONVars adds the values of obj to vars. The keys of nested objects
+// are joined to the key of their parent with an underscore in the variable
+// names. keys maps the environment variable names already in vars to the
+// keys they came from, to catch different keys that end up with the same
+// name.
+func addJSONVars(vars, keys map[string]string, prefix string, obj map[string]interface{}, flatten bool, kc keyCase) error {
+	names := make([]string, 0, len(obj))
+	for k := range obj {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		key := prefix + k
+
+		v, ok := obj[k].(map[string]interface{})
+		if ok {
+			if !flatten {
+				return fmt.Errorf("%q is a nested object", key)
+			}
+			if err := addJSONVars(vars, keys, key+".", v, flatten, kc); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := kc.envName(key)
+		if other, ok := keys[name]; ok {
+			return fmt.Errorf("%q and %q are both set as %s", other, key, name)
+		}
+
+		val, err := jsonValue(obj[k])
+		if err != nil {
+			return err
+		}
+		vars[name] = val
+		keys[name] = key
+	}
+	return nil
+}
+
+// jsonValue returns the value of an environment variable for a JSON value.
+func jsonValue(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	b := new(bytes.Buffer)
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// keyCase is what the case of keys is changed to, when they're turned into
+// environment variable names.
+type keyCase string
+
+const (
+	keyCaseUpper keyCase = "upper"
+	keyCaseLower keyCase = "lower"
+	keyCaseAsIs  keyCase = "asis"
+)
+
+// validKeyCase reports whether c is one of the key cases.
+func validKeyCase(c keyCase) bool {
+	return c == keyCaseUpper || c == keyCaseLower || c == keyCaseAsIs
+}
+
+// envName turns a key into an environment variable name, by changing its
+// case, upper by default, and replacing anything other than letters, digits
+// and underscores with an underscore. Names starting with a digit are
+// prefixed with an underscore.
+func (c keyCase) envName(k string) string {
+	switch c {
+	case keyCaseLower:
+		k = strings.ToLower(k)
+	case keyCaseAsIs:
+	default:
+		k = strings.ToUpper(k)
+	}
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, k)
+
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,130 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_JSON(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("BUNDLE", "ssm-json:///myapp/bundle")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/bundle")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/bundle"), Value: aws.String(`{"db-password": "hunter2", "api_key": "abc", "port": 5432}`)},
+		},
+	}, nil)
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"API_KEY=abc",
+		"DB_PASSWORD=hunter2",
+		"PORT=5432",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+	assert.Equal(t, []string{"API_KEY", "DB_PASSWORD", "PORT"}, e.resolvedVars())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_JSONNested(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("BUNDLE", "ssm-json:///myapp/bundle")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/bundle")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/bundle"), Value: aws.String(`{"db": {"user": "app"}}`)},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, `splitting BUNDLE into variables: "db" is a nested object`)
+
+	assert.Equal(t, []string{
+		"BUNDLE=ssm-json:///myapp/bundle",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestJSONVars(t *testing.T) {
+	tests := []struct {
+		in      string
+		flatten bool
+		vars    map[string]string
+		err     string
+	}{
+		{`{"a": "1", "b": true, "c": null, "d": [1, "x"]}`, false, map[string]string{"A": "1", "B": "true", "C": "null", "D": `[1,"x"]`}, ""},
+		{`{"big": 12345678901234567890, "html": "<a&b>"}`, false, map[string]string{"BIG": "12345678901234567890", "HTML": "<a&b>"}, ""},
+		{`{"my.key": "v", "9lives": "cat"}`, false, map[string]string{"MY_KEY": "v", "_9LIVES": "cat"}, ""},
+		{`{"db": {"user": "app", "tls": {"mode": "on"}}}`, true, map[string]string{"DB_USER": "app", "DB_TLS_MODE": "on"}, ""},
+		{`{"db": {"user": "app"}}`, false, nil, `"db" is a nested object`},
+		{`{"a": {"b": {"c": {}}}}`, false, nil, `"a" is a nested object`},
+		{`{"db": {"user": "app"}, "db_user": "other"}`, true, nil, `"db.user" and "db_user" are both set as DB_USER`},
+		{`{"a-b": "1", "a_b": "2"}`, false, nil, `"a-b" and "a_b" are both set as A_B`},
+		{`["a"]`, false, nil, "parsing JSON object: json: cannot unmarshal array into Go value of type map[string]interface {}"},
+		{`null`, false, nil, "parsing JSON object: got null"},
+	}
+
+	for _, tt := range tests {
+		vars, err := jsonVars(tt.in, tt.flatten, keyCaseUpper)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.vars, vars, tt.in)
+	}
+}
+
+func TestKeyCaseEnvName(t *testing.T) {
+	tests := []struct {
+		kc      keyCase
+		in, out string
+	}{
+		{keyCaseUpper, "db/Password", "DB_PASSWORD"},
+		{keyCaseLower, "db/Password", "db_password"},
+		{keyCaseAsIs, "db/Password", "db_Password"},
+		{"", "db/Password", "DB_PASSWORD"},
+		{keyCaseAsIs, "9lives", "_9lives"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, tt.kc.envName(tt.in), "%s %s", tt.kc, tt.in)
+	}
+}
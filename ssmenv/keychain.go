@@ -0,0 +1,70 @@
+package ssmenv
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// keychainService is the service parameters are stored under in the
+// keychain of the OS, with the name of the parameter as the account.
+const keychainService = "ssm-env"
+
+// keychain looks up secrets in the keychain of the OS.
+type keychain interface {
+	// Get returns the secret stored for name. found is false if there
+	// isn't one.
+	Get(name string) (value string, found bool, err error)
+}
+
+// keychainClient resolves parameters and secrets from a keychain instead of
+// AWS, so that developers without AWS access can run the same entrypoints
+// offline. Names are looked up as is, selectors included.
+type keychainClient struct {
+	keychain keychain
+}
+
+func (c *keychainClient) GetParametersWithContext(ctx aws.Context, input *ssm.GetParametersInput, opts ...request.Option) (*ssm.GetParametersOutput, error) {
+	out := new(ssm.GetParametersOutput)
+	for _, name := range input.Names {
+		value, found, err := c.keychain.Get(aws.StringValue(name))
+		if err != nil {
+			return nil, fmt.Errorf("looking up %s in the keychain: %v", aws.StringValue(name), err)
+		}
+		if !found {
+			out.InvalidParameters = append(out.InvalidParameters, name)
+			continue
+		}
+		out.Parameters = append(out.Parameters, &ssm.Parameter{Name: name, Value: aws.String(value)})
+	}
+	return out, nil
+}
+
+// DescribeParametersWithContext describes no parameters, since there are no tiers in a
+// keychain.
+func (c *keychainClient) DescribeParametersWithContext(ctx aws.Context, input *ssm.DescribeParametersInput, opts ...request.Option) (*ssm.DescribeParametersOutput, error) {
+	return new(ssm.DescribeParametersOutput), nil
+}
+
+// GetParametersByPathWithContext always fails, since keychains can't be listed by
+// path.
+func (c *keychainClient) GetParametersByPathWithContext(ctx aws.Context, input *ssm.GetParametersByPathInput, opts ...request.Option) (*ssm.GetParametersByPathOutput, error) {
+	return nil, errors.New("paths can't be resolved from the keychain")
+}
+
+func (c *keychainClient) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	id := aws.StringValue(input.SecretId)
+	value, found, err := c.keychain.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("looking up %s in the keychain: %v", id, err)
+	}
+	if !found {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found in the keychain", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: input.SecretId, SecretString: aws.String(value)}, nil
+}
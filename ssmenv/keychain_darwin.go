@@ -0,0 +1,31 @@
+package ssmenv
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// newOSKeychain returns the macOS Keychain, read with the security command.
+func newOSKeychain() (keychain, error) {
+	return securityKeychain{}, nil
+}
+
+// securityKeychain reads generic passwords from the macOS Keychain.
+type securityKeychain struct{}
+
+// errSecItemNotFound is the exit status of security when there's no such
+// item.
+const errSecItemNotFound = 44
+
+func (securityKeychain) Get(name string) (string, bool, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", name, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == errSecItemNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSuffix(string(out), "\n"), true, nil
+}
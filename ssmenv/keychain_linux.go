@@ -0,0 +1,33 @@
+package ssmenv
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// newOSKeychain returns the Secret Service (GNOME Keyring, KWallet), read
+// with the secret-tool command.
+func newOSKeychain() (keychain, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, errors.New("-keychain needs secret-tool, from libsecret")
+	}
+	return secretToolKeychain{}, nil
+}
+
+// secretToolKeychain reads secrets stored with
+// secret-tool store --label NAME service ssm-env account NAME.
+type secretToolKeychain struct{}
+
+func (secretToolKeychain) Get(name string) (string, bool, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService, "account", name).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(out) == 0 && len(exitErr.Stderr) == 0 {
+		// secret-tool fails without saying anything when there's no
+		// such secret.
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(out), true, nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package ssmenv
+
+import "errors"
+
+// newOSKeychain fails, since there's no keychain support on this platform.
+func newOSKeychain() (keychain, error) {
+	return nil, errors.New("-keychain is not supported on this platform")
+}
@@ -0,0 +1,84 @@
+package ssmenv
+
+import (
+	"errors"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKeychain is a keychain holding the secrets in a map.
+type fakeKeychain map[string]string
+
+func (k fakeKeychain) Get(name string) (string, bool, error) {
+	if name == "broken" {
+		return "", false, errors.New("keychain is locked")
+	}
+	v, ok := k[name]
+	return v, ok, nil
+}
+
+func TestExpandEnviron_Keychain(t *testing.T) {
+	os := newFakeEnviron()
+	c := &keychainClient{keychain: fakeKeychain{
+		"/myapp/db-password":  "hunter2",
+		"/myapp/api-key:2":    "versioned",
+		"myapp/legacy-secret": "from-fallback",
+	}}
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		sm:        c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("DB_PASSWORD", "ssm:///myapp/db-password")
+	os.Setenv("API_KEY", "ssm:///myapp/api-key:2")
+	os.Setenv("LEGACY_SECRET", "ssm-or-sm:///myapp/legacy-secret")
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"API_KEY=versioned",
+		"DB_PASSWORD=hunter2",
+		"LEGACY_SECRET=from-fallback",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+}
+
+func TestExpandEnviron_KeychainErrors(t *testing.T) {
+	tests := []struct {
+		ref string
+		err string
+	}{
+		{"ssm:///myapp/missing", "invalid parameters: [/myapp/missing]"},
+		{"ssm://broken", "looking up broken in the keychain: keychain is locked"},
+		{"ssm:///myapp/*", "resolving parameters under /myapp for SUPER_SECRET: paths can't be resolved from the keychain"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := &keychainClient{keychain: fakeKeychain{}}
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       c,
+			sm:        c,
+			batchSize: defaultBatchSize,
+		}
+
+		os.Setenv("SUPER_SECRET", tt.ref)
+
+		decrypt := false
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		assert.EqualError(t, err, tt.err, tt.ref)
+		assert.Equal(t, tt.ref, os["SUPER_SECRET"])
+	}
+}
@@ -0,0 +1,275 @@
+package ssmenv
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+const (
+	// KMSPrefix marks an environment variable value as base64 encoded KMS
+	// ciphertext, which is replaced with its plaintext.
+	KMSPrefix = "!kms "
+
+	// KMSHexPrefix marks an environment variable value as hex encoded KMS
+	// ciphertext.
+	KMSHexPrefix = "!kms:hex "
+
+	// KMSBase64Prefix marks an environment variable value as base64 encoded
+	// KMS ciphertext of binary data, like a certificate or a key. It's
+	// replaced with its plaintext, base64 encoded.
+	KMSBase64Prefix = "!kms:b64 "
+)
+
+// KMSClient is the part of the KMS API ssm-env uses, implemented by
+// *kms.KMS.
+type KMSClient interface {
+	DecryptWithContext(aws.Context, *kms.DecryptInput, ...request.Option) (*kms.DecryptOutput, error)
+}
+
+// lazyKMSClient wraps the AWS SDK KMS client such that the AWS session and
+// KMS client are not initialized until Decrypt is called for the first time.
+type lazyKMSClient struct {
+	sessions *sessionProvider
+
+	mu   sync.Mutex
+	kms  KMSClient
+	sess *session.Session
+}
+
+func (c *lazyKMSClient) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	client, err := c.client(nil)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.DecryptWithContext(ctx, input, opts...)
+	if isExpiredToken(err) {
+		// Retried once with fresh credentials, like lazySSMClient.
+		if client, err = c.client(client); err != nil {
+			return nil, err
+		}
+		out, err = client.DecryptWithContext(ctx, input, opts...)
+	}
+	return out, err
+}
+
+// client returns the KMS client, initializing it (and the AWS session) if it
+// hasn't been already, or if it's the stale client.
+func (c *lazyKMSClient) client(stale KMSClient) (KMSClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.kms == nil || c.kms == stale {
+		sess, err := c.sessions.session(c.sess)
+		if err != nil {
+			return nil, err
+		}
+		c.kms = c.sessions.config.clients().newKMS(sess)
+		c.sess = sess
+	}
+	return c.kms, nil
+}
+
+// expandKMS decrypts the environment variables holding KMS ciphertext.
+func (e *expander) expandKMS(ctx context.Context, nofail bool) error {
+	var keys, values []string
+	for _, envvar := range e.os.Environ() {
+		k, v := splitVar(envvar)
+
+		if !e.considered(k) {
+			continue
+		}
+
+		// Values resolved by an earlier phase aren't what the user wrote, so
+		// they're used as is.
+		if !e.resolved[k] {
+			var err error
+			if v, err = e.preTransform(v); err != nil {
+				return fmt.Errorf("pre-transforming %s: %v", k, err)
+			}
+		}
+
+		if e.isKMSValue(v) {
+			e.logf("%s: KMS value", k)
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+	}
+
+	// Variables holding the same ciphertext share a Decrypt call.
+	var uniq []string
+	index := make(map[string]int)
+	for _, v := range values {
+		if _, ok := index[v]; !ok {
+			index[v] = len(uniq)
+			uniq = append(uniq, v)
+		}
+	}
+
+	budget := len(uniq)
+	if e.maxKMSDecrypts > 0 && len(uniq) > e.maxKMSDecrypts {
+		err := fmt.Errorf("%d KMS values to decrypt, more than the maximum of %d", len(uniq), e.maxKMSDecrypts)
+		if !nofail {
+			e.count(metricFailed, int64(len(keys)))
+			return err
+		}
+		e.warnings().warn(err)
+		budget = e.maxKMSDecrypts
+	}
+
+	plaintexts := make([]string, budget)
+	errs := make([]error, budget)
+	forEach(budget, e.kmsConcurrency, func(i int) {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			return
+		}
+		plaintexts[i], errs[i] = e.decryptKmsValue(ctx, uniq[i])
+	})
+
+	for i, k := range keys {
+		j := index[values[i]]
+		if j >= budget {
+			// Over the budget, with nofail.
+			e.count(metricFailed, 1)
+			continue
+		}
+
+		if err := errs[j]; err != nil {
+			err = fmt.Errorf("decrypting %s: %v", k, err)
+			e.count(metricFailed, 1)
+			if !nofail {
+				return err
+			}
+			e.warnings().warn(err)
+			continue
+		}
+
+		if !isText(plaintexts[j]) {
+			e.warnings().warnf("%s decrypted to binary data, which may be corrupted in the environment; use %q to base64 encode it", k, KMSBase64Prefix)
+		}
+		e.targets[k] = target{k, precedenceExplicit}
+		e.setResolved(k, plaintexts[j])
+	}
+
+	return nil
+}
+
+// kmsPrefixes returns the prefixes of base64 and hex encoded KMS values, and
+// of KMS values with binary plaintext. With a custom kmsPrefix, only the
+// first is supported, and hexPrefix and b64Prefix are empty.
+func (e *expander) kmsPrefixes() (prefix, hexPrefix, b64Prefix string) {
+	if e.kmsPrefix == "" || e.kmsPrefix == KMSPrefix {
+		return KMSPrefix, KMSHexPrefix, KMSBase64Prefix
+	}
+	return e.kmsPrefix, "", ""
+}
+
+// isKMSValue reports whether an environment variable value holds KMS
+// ciphertext.
+func (e *expander) isKMSValue(v string) bool {
+	if e.disableKMS {
+		return false
+	}
+	prefix, hexPrefix, b64Prefix := e.kmsPrefixes()
+	return strings.HasPrefix(v, prefix) ||
+		(hexPrefix != "" && strings.HasPrefix(v, hexPrefix)) ||
+		(b64Prefix != "" && strings.HasPrefix(v, b64Prefix))
+}
+
+// kmsCiphertext decodes the ciphertext in a KMS environment variable value.
+func (e *expander) kmsCiphertext(v string) ([]byte, error) {
+	prefix, hexPrefix, b64Prefix := e.kmsPrefixes()
+	if hexPrefix != "" && strings.HasPrefix(v, hexPrefix) {
+		return hex.DecodeString(strings.TrimSpace(strings.TrimPrefix(v, hexPrefix)))
+	}
+	if b64Prefix != "" && strings.HasPrefix(v, b64Prefix) {
+		return decodeBase64(strings.TrimPrefix(v, b64Prefix), e.strictBase64)
+	}
+	return decodeBase64(strings.TrimPrefix(v, prefix), e.strictBase64)
+}
+
+// isBinaryKMSValue reports whether a KMS environment variable value asks for
+// its plaintext to be base64 encoded.
+func (e *expander) isBinaryKMSValue(v string) bool {
+	_, _, b64Prefix := e.kmsPrefixes()
+	return b64Prefix != "" && strings.HasPrefix(v, b64Prefix)
+}
+
+// isText reports whether a value can be set as an environment variable
+// without being corrupted: it's valid UTF-8, and has no NUL bytes, which
+// would cut it short when passed to the command.
+func isText(v string) bool {
+	return utf8.ValidString(v) && !strings.ContainsRune(v, 0)
+}
+
+// decryptKmsValue decrypts a KMS ciphertext environment variable value.
+func (e *expander) decryptKmsValue(ctx context.Context, v string) (string, error) {
+	ciphertext, err := e.kmsCiphertext(v)
+	if err != nil {
+		return "", fmt.Errorf("decoding ciphertext: %v", err)
+	}
+
+	start := e.now()
+	result, err := e.kms.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: e.kmsEncryptionContext,
+	})
+	e.count(metricCalls, 1)
+	e.logCall("kms:Decrypt", start, err)
+	if err != nil {
+		return "", err
+	}
+	e.iam.add("kms:Decrypt", aws.StringValue(result.KeyId))
+
+	plaintext := string(result.Plaintext)
+	if e.isBinaryKMSValue(v) {
+		plaintext = base64.StdEncoding.EncodeToString(result.Plaintext)
+	}
+	if e.zeroPlaintext {
+		zeroBytes(result.Plaintext)
+	}
+	return plaintext, nil
+}
+
+// parseEncryptionContext parses a KMS encryption context given as a comma
+// separated list of key=value pairs.
+func parseEncryptionContext(s string) (map[string]*string, error) {
+	pairs := splitList(s)
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	ec := make(map[string]*string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("-kms-encryption-context must be key=value pairs, got %q", pair)
+		}
+		ec[parts[0]] = aws.String(parts[1])
+	}
+	return ec, nil
+}
+
+// decodeBase64 decodes base64 ciphertext. Missing padding is added back,
+// since it's commonly lost when values are copied around, unless strict is
+// set, in which case only exact, well-formed base64 is accepted.
+func decodeBase64(s string, strict bool) ([]byte, error) {
+	if strict {
+		return base64.StdEncoding.Strict().DecodeString(s)
+	}
+
+	s = strings.TrimSpace(s)
+	if n := len(s) % 4; n != 0 {
+		s += strings.Repeat("=", 4-n)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
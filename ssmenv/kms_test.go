@@ -0,0 +1,571 @@
+package ssmenv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpandEnviron_KMS(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("hehe"),
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_KMSHex(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms:hex "+hex.EncodeToString([]byte("ciphertext")))
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("hehe"),
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_KMSInvalidHex(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms:hex not-hex")
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "decrypting SUPER_SECRET: decoding ciphertext: encoding/hex: invalid byte: U+006E 'n'")
+
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_KMSInvalidHexNoFail(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms:hex not-hex")
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=!kms:hex not-hex",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_KMSBase64(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	b := new(bytes.Buffer)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+		log:       &logger{w: b},
+	}
+
+	os.Setenv("TLS_KEY", "!kms:b64 "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte{0x30, 0x82, 0x00, 0xff},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+		"TLS_KEY=MIIA/w==",
+	}, os.Environ())
+	assert.Equal(t, "", b.String())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_KMSBinaryPlaintext(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	b := new(bytes.Buffer)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+		log:       &logger{w: b},
+	}
+
+	os.Setenv("TLS_KEY", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte{0x30, 0x82, 0x00, 0xff},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "ssm-env: TLS_KEY decrypted to binary data, which may be corrupted in the environment; use \"!kms:b64 \" to base64 encode it\n", b.String())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestIsText(t *testing.T) {
+	assert.True(t, isText("hehe"))
+	assert.True(t, isText("héhé"))
+	assert.False(t, isText("he\x00he"))
+	assert.False(t, isText("\x30\x82\xff"))
+}
+
+func TestExpandEnviron_KMSFromSSM(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", mock.Anything).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("!kms " + base64.StdEncoding.EncodeToString([]byte("ciphertext")))},
+		},
+	}, nil)
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("hehe"),
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_KMSFirst(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+		kmsFirst:  true,
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms Y2lwaGVydGV4dA==")
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("ssm://secret"),
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	b := new(bytes.Buffer)
+	decrypt := false
+	nofail := false
+	err := e.expandTo(b, FormatEnv, decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	// The SSM reference in between isn't written.
+	assert.Equal(t, "SUPER_SECRET=hehe\n", b.String())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestDecodeBase64(t *testing.T) {
+	for _, in := range []string{"aGVoZQ==", "aGVoZQ", " aGVoZQ==\n"} {
+		b, err := decodeBase64(in, false)
+		assert.NoError(t, err)
+		assert.Equal(t, "hehe", string(b))
+	}
+}
+
+func TestDecodeBase64_Strict(t *testing.T) {
+	b, err := decodeBase64("aGVoZQ==", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "hehe", string(b))
+
+	for _, in := range []string{"aGVoZQ", " aGVoZQ==\n", "aGVoZR=="} {
+		_, err := decodeBase64(in, true)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestExpandEnviron_KMSStrictBase64(t *testing.T) {
+	os := newFakeEnviron()
+	k := new(mockKMS)
+	e := expander{
+		t:            template.Must(parseTemplate(DefaultTemplate)),
+		os:           os,
+		kms:          k,
+		batchSize:    defaultBatchSize,
+		strictBase64: true,
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms Y2lwaGVydGV4dA")
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.Error(t, err)
+
+	k.AssertNotCalled(t, "Decrypt", mock.Anything)
+}
+
+type mockKMS struct {
+	mock.Mock
+}
+
+func (m *mockKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	args := m.MethodCalled("Decrypt", input)
+	return args.Get(0).(*kms.DecryptOutput), args.Error(1)
+}
+
+func TestExpandEnviron_KMSDuplicateCiphertext(t *testing.T) {
+	os := newFakeEnviron()
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		kms:       k,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+	os.Setenv("SAME_SECRET", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("hehe"),
+	}, nil).Once()
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SAME_SECRET=hehe",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	k.AssertNumberOfCalls(t, "Decrypt", 1)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_MaxKMSDecrypts(t *testing.T) {
+	tests := []struct {
+		max    int
+		nofail bool
+		err    string
+		env    []string
+	}{
+		// Variables holding the same ciphertext count once.
+		{2, false, "", []string{
+			"SECRET_A=plaintext-one",
+			"SECRET_B=plaintext-two",
+			"SECRET_C=plaintext-one",
+		}},
+		{1, false, "2 KMS values to decrypt, more than the maximum of 1", []string{
+			"SECRET_A=!kms " + base64.StdEncoding.EncodeToString([]byte("one")),
+			"SECRET_B=!kms " + base64.StdEncoding.EncodeToString([]byte("two")),
+			"SECRET_C=!kms " + base64.StdEncoding.EncodeToString([]byte("one")),
+		}},
+		// With nofail, the values over the budget are left undecrypted.
+		{1, true, "", []string{
+			"SECRET_A=plaintext-one",
+			"SECRET_B=!kms " + base64.StdEncoding.EncodeToString([]byte("two")),
+			"SECRET_C=plaintext-one",
+		}},
+	}
+
+	for _, tt := range tests {
+		os := make(fakeEnviron)
+		k := new(mockKMS)
+		e := expander{
+			t:              template.Must(parseTemplate(DefaultTemplate)),
+			os:             os,
+			kms:            k,
+			batchSize:      defaultBatchSize,
+			maxKMSDecrypts: tt.max,
+		}
+
+		os.Setenv("SECRET_A", "!kms "+base64.StdEncoding.EncodeToString([]byte("one")))
+		os.Setenv("SECRET_B", "!kms "+base64.StdEncoding.EncodeToString([]byte("two")))
+		os.Setenv("SECRET_C", "!kms "+base64.StdEncoding.EncodeToString([]byte("one")))
+
+		if tt.err == "" {
+			k.On("Decrypt", &kms.DecryptInput{
+				CiphertextBlob: []byte("one"),
+			}).Return(&kms.DecryptOutput{
+				Plaintext: []byte("plaintext-one"),
+			}, nil).Once()
+		}
+		if tt.err == "" && !tt.nofail {
+			k.On("Decrypt", &kms.DecryptInput{
+				CiphertextBlob: []byte("two"),
+			}).Return(&kms.DecryptOutput{
+				Plaintext: []byte("plaintext-two"),
+			}, nil).Once()
+		}
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tt.env, os.Environ())
+
+		k.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_KMSEncryptionContext(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: defaultBatchSize,
+		kmsEncryptionContext: map[string]*string{
+			"app": aws.String("myapp"),
+			"env": aws.String("prod"),
+		},
+	}
+
+	os.Setenv("SUPER_SECRET", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+		EncryptionContext: map[string]*string{
+			"app": aws.String("myapp"),
+			"env": aws.String("prod"),
+		},
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("hehe"),
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestParseEncryptionContext(t *testing.T) {
+	tests := []struct {
+		in  string
+		out map[string]*string
+		err bool
+	}{
+		{"", nil, false},
+		{"app=myapp", map[string]*string{"app": aws.String("myapp")}, false},
+		{"app=myapp, env=prod", map[string]*string{"app": aws.String("myapp"), "env": aws.String("prod")}, false},
+		{"key=a=b", map[string]*string{"key": aws.String("a=b")}, false},
+		{"app", nil, true},
+		{"=myapp", nil, true},
+	}
+
+	for _, tt := range tests {
+		out, err := parseEncryptionContext(tt.in)
+		if tt.err {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.out, out, tt.in)
+	}
+}
+
+func TestExpandEnviron_KMSPrefix(t *testing.T) {
+	tests := []struct {
+		kmsPrefix  string
+		disableKMS bool
+		out        []string
+	}{
+		// The default prefix.
+		{"", false, []string{"CUSTOM=kms://Y2lwaGVydGV4dA==", "DEFAULT=plaintext", "HEX=plaintext"}},
+		{KMSPrefix, false, []string{"CUSTOM=kms://Y2lwaGVydGV4dA==", "DEFAULT=plaintext", "HEX=plaintext"}},
+		// A custom prefix replaces the default one, and the hex one.
+		{"kms://", false, []string{"CUSTOM=plaintext", "DEFAULT=!kms Y2lwaGVydGV4dA==", "HEX=!kms:hex 63697068657274657874"}},
+		// KMS values are left alone.
+		{"", true, []string{"CUSTOM=kms://Y2lwaGVydGV4dA==", "DEFAULT=!kms Y2lwaGVydGV4dA==", "HEX=!kms:hex 63697068657274657874"}},
+	}
+
+	for _, tt := range tests {
+		os := make(fakeEnviron)
+		k := new(mockKMS)
+		e := expander{
+			t:          template.Must(parseTemplate(DefaultTemplate)),
+			os:         os,
+			ssm:        new(mockSSM),
+			kms:        k,
+			batchSize:  defaultBatchSize,
+			kmsPrefix:  tt.kmsPrefix,
+			disableKMS: tt.disableKMS,
+		}
+
+		os.Setenv("DEFAULT", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+		os.Setenv("HEX", "!kms:hex "+hex.EncodeToString([]byte("ciphertext")))
+		os.Setenv("CUSTOM", "kms://"+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+		k.On("Decrypt", &kms.DecryptInput{
+			CiphertextBlob: []byte("ciphertext"),
+		}).Return(&kms.DecryptOutput{
+			Plaintext: []byte("plaintext"),
+		}, nil).Maybe()
+
+		decrypt := false
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		assert.NoError(t, err)
+
+		assert.Equal(t, tt.out, os.Environ(), "%q", tt.kmsPrefix)
+	}
+}
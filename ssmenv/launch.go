@@ -0,0 +1,13 @@
+package ssmenv
+
+import (
+	"fmt"
+	"time"
+)
+
+// launchEvent returns the entry logged with -launch-event right before the
+// command named name is started, resolution having taken d. Since exec
+// replaces ssm-env, it's written synchronously, before exec is attempted.
+func launchEvent(name string, d time.Duration) logEntry {
+	return logEntry{Level: levelInfo, Msg: fmt.Sprintf("launching %s after resolving for %v", name, d)}
+}
@@ -0,0 +1,51 @@
+package ssmenv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaunchEvent(t *testing.T) {
+	assert.Equal(t, logEntry{Level: levelInfo, Msg: "launching bin/server after resolving for 1.5s"}, launchEvent("bin/server", 1500*time.Millisecond))
+}
+
+func TestRun_LaunchEvent(t *testing.T) {
+	defer func(f func(string, []string, []string) error) { execve = f }(execve)
+	defer func(f clientFactory) { clients = f }(clients)
+
+	os := newFakeEnviron()
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c := new(mockSSM)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("value")},
+		},
+	}, nil)
+	clients = &fakeClientFactory{region: "us-east-1", ssm: c}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+
+	// The event has to be written by the time exec is attempted, since
+	// exec replaces the process.
+	var before string
+	execve = func(path string, args []string, env []string) error {
+		before = stderr.String()
+		return errors.New("executed")
+	}
+
+	code := run([]string{"-launch-event", "-log-format", "json", "sh", "-c", "exit 0"}, os, stdout, stderr)
+	assert.Equal(t, 1, code)
+	assert.Regexp(t, `^\{"level":"info","msg":"launching sh after resolving for [0-9.]+[µnm]?s"\}`+"\n$", before)
+
+	c.AssertExpectations(t)
+}
@@ -0,0 +1,117 @@
+package ssmenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// logFormat is the format of the messages ssm-env writes to stderr.
+type logFormat string
+
+const (
+	// logText writes messages as "ssm-env: " prefixed lines, the default.
+	logText logFormat = "text"
+
+	// logJSON writes a JSON object per line, for log pipelines.
+	logJSON logFormat = "json"
+)
+
+// validLogFormat reports whether f is one of the log formats.
+func validLogFormat(f logFormat) bool {
+	return f == logText || f == logJSON
+}
+
+// Levels of log entries.
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+// logEntry is a message written to stderr.
+type logEntry struct {
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+
+	// Param is the parameter the message is about, if it's about a single
+	// one.
+	Param string `json:"param,omitempty"`
+
+	// Error is the error that caused the message, if it isn't the message
+	// itself.
+	Error string `json:"error,omitempty"`
+}
+
+// logger writes messages to stderr in a format. It's shared by copies of an
+// expander, and safe for concurrent use.
+type logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format logFormat
+
+	// quiet drops warnings, for -quiet. Errors are still written.
+	quiet bool
+}
+
+// stderrLog is used by expanders without a logger.
+var stderrLog = &logger{w: os.Stderr}
+
+// write writes an entry. Errors writing it are ignored, like those of
+// writing to stderr.
+func (l *logger) write(entry logEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.quiet && entry.Level == levelWarn {
+		return
+	}
+
+	if l.format == logJSON {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		l.w.Write(append(b, '\n'))
+		return
+	}
+
+	if entry.Error != "" {
+		fmt.Fprintf(l.w, "ssm-env: %s: %s\n", entry.Msg, entry.Error)
+		return
+	}
+	fmt.Fprintf(l.w, "ssm-env: %s\n", entry.Msg)
+}
+
+// warn logs an error that's tolerated, like any error with -no-fail.
+func (l *logger) warn(err error) {
+	l.write(logEntry{Level: levelWarn, Msg: err.Error()})
+}
+
+// warnf logs a warning.
+func (l *logger) warnf(format string, args ...interface{}) {
+	l.write(logEntry{Level: levelWarn, Msg: fmt.Sprintf(format, args...)})
+}
+
+// warnInvalid logs the parameters that don't exist, when that's tolerated.
+// In JSON, there's an entry per parameter.
+func (l *logger) warnInvalid(err *invalidParametersError) {
+	if l.format != logJSON {
+		l.warn(err)
+		return
+	}
+	for _, name := range err.InvalidParameters {
+		l.write(logEntry{Level: levelWarn, Msg: "invalid parameter", Param: name})
+	}
+}
+
+// warnings returns the logger warnings are written to.
+func (e *expander) warnings() *logger {
+	if e.log == nil {
+		return stderrLog
+	}
+	return e.log
+}
@@ -0,0 +1,158 @@
+package ssmenv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger_Write(t *testing.T) {
+	entries := []logEntry{
+		{Level: levelWarn, Msg: "invalid parameters: [secret]"},
+		{Level: levelDebug, Msg: "kms:Decrypt failed after 1s", Error: "AccessDeniedException"},
+		{Level: levelWarn, Msg: "invalid parameter", Param: "secret"},
+	}
+
+	tests := []struct {
+		format logFormat
+		out    string
+	}{
+		{"", "ssm-env: invalid parameters: [secret]\nssm-env: kms:Decrypt failed after 1s: AccessDeniedException\nssm-env: invalid parameter\n"},
+		{logText, "ssm-env: invalid parameters: [secret]\nssm-env: kms:Decrypt failed after 1s: AccessDeniedException\nssm-env: invalid parameter\n"},
+		{logJSON, `{"level":"warn","msg":"invalid parameters: [secret]"}` + "\n" +
+			`{"level":"debug","msg":"kms:Decrypt failed after 1s","error":"AccessDeniedException"}` + "\n" +
+			`{"level":"warn","msg":"invalid parameter","param":"secret"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		b := new(bytes.Buffer)
+		l := &logger{w: b, format: tt.format}
+		for _, entry := range entries {
+			l.write(entry)
+		}
+		assert.Equal(t, tt.out, b.String(), "%q", tt.format)
+	}
+}
+
+func TestExpandEnviron_LogJSON(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	b := new(bytes.Buffer)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		log:       &logger{w: b, format: logJSON},
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+	os.Setenv("OTHER_SECRET", "ssm://other-secret")
+	os.Setenv("TOKEN", "ssm://token|default")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("other-secret"), aws.String("secret"), aws.String("token")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("other-secret"), aws.String("secret"), aws.String("token")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"level":"warn","msg":"invalid parameter","param":"other-secret"}`+"\n"+
+		`{"level":"warn","msg":"invalid parameter","param":"secret"}`+"\n"+
+		`{"level":"warn","msg":"invalid parameter","param":"token"}`+"\n", b.String())
+
+	c.AssertExpectations(t)
+}
+
+func TestRun_LogFormat(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{[]string{"-print"}, 1, "ssm-env: invalid parameters: [secret]\n"},
+		{[]string{"-print", "-log-format", "json"}, 1, `{"level":"error","msg":"invalid parameters: [secret]"}` + "\n"},
+		{[]string{"-print", "-no-fail", "-log-format", "json"}, 0, `{"level":"warn","msg":"invalid parameter","param":"secret"}` + "\n"},
+		{[]string{"-print", "-log-format", "xml"}, 2, "ssm-env: unknown -log-format \"xml\"\n"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		c := new(mockSSM)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			InvalidParameters: []*string{aws.String("secret")},
+		}, nil).Maybe()
+
+		code, _, stderr := runWith(c, os, tt.args...)
+		assert.Equal(t, tt.code, code, "%v", tt.args)
+		assert.Equal(t, tt.stderr, stderr, "%v", tt.args)
+	}
+}
+
+func TestLogger_Warn(t *testing.T) {
+	b := new(bytes.Buffer)
+	l := &logger{w: b, format: logJSON}
+	l.warn(errors.New("decrypting KMS_SECRET: AccessDeniedException"))
+	l.warnf("using %s for %s", "secret:2", "secret:v2")
+	l.warnInvalid(&invalidParametersError{InvalidParameters: []string{"a", "b"}})
+
+	assert.Equal(t, `{"level":"warn","msg":"decrypting KMS_SECRET: AccessDeniedException"}`+"\n"+
+		`{"level":"warn","msg":"using secret:2 for secret:v2"}`+"\n"+
+		`{"level":"warn","msg":"invalid parameter","param":"a"}`+"\n"+
+		`{"level":"warn","msg":"invalid parameter","param":"b"}`+"\n", b.String())
+}
+
+func TestLogger_Quiet(t *testing.T) {
+	b := new(bytes.Buffer)
+	l := &logger{w: b, quiet: true}
+	l.warn(errors.New("decrypting KMS_SECRET: AccessDeniedException"))
+	l.warnInvalid(&invalidParametersError{InvalidParameters: []string{"a"}})
+	l.write(logEntry{Level: levelError, Msg: "invalid parameters: [b]"})
+
+	assert.Equal(t, "ssm-env: invalid parameters: [b]\n", b.String())
+}
+
+func TestRun_Quiet(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{[]string{"-quiet", "-no-fail", "-print"}, 0, ""},
+		// Errors are still written.
+		{[]string{"-quiet", "-print"}, 1, "ssm-env: invalid parameters: [secret]\n"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		c := new(mockSSM)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			InvalidParameters: []*string{aws.String("secret")},
+		}, nil)
+
+		code, _, stderr := runWith(c, os, tt.args...)
+		assert.Equal(t, tt.code, code, "%v", tt.args)
+		assert.Equal(t, tt.stderr, stderr, "%v", tt.args)
+		c.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,60 @@
+package ssmenv
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Names of the metrics emitted about resolution.
+const (
+	metricResolved = "parameters.resolved"
+	metricFailed   = "parameters.failed"
+	metricCalls    = "aws.calls"
+	metricRetries  = "aws.retries"
+	metricLatency  = "aws.latency"
+)
+
+// metrics receives counters and timings about resolution.
+type metrics interface {
+	Count(name string, value int64)
+	Timing(name string, d time.Duration)
+}
+
+// statsdClient sends metrics to a statsd server over UDP. Sending is best
+// effort, errors are ignored so that metrics never prevent the command from
+// starting.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+}
+
+func newStatsdClient(addr, prefix string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to statsd: %v", err)
+	}
+	return &statsdClient{conn: conn, prefix: prefix}, nil
+}
+
+func (c *statsdClient) Count(name string, value int64) {
+	fmt.Fprintf(c.conn, "%s%s:%d|c", c.prefix, name, value)
+}
+
+func (c *statsdClient) Timing(name string, d time.Duration) {
+	fmt.Fprintf(c.conn, "%s%s:%d|ms", c.prefix, name, d.Milliseconds())
+}
+
+// count and timing emit metrics, when metrics are enabled.
+
+func (e *expander) count(name string, value int64) {
+	if e.metrics != nil {
+		e.metrics.Count(name, value)
+	}
+}
+
+func (e *expander) timing(name string, d time.Duration) {
+	if e.metrics != nil {
+		e.metrics.Timing(name, d)
+	}
+}
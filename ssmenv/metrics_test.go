@@ -0,0 +1,130 @@
+package ssmenv
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsdClient(t *testing.T) {
+	sink, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	c, err := newStatsdClient(sink.LocalAddr().String(), "ssm_env.")
+	assert.NoError(t, err)
+
+	c.Count(metricResolved, 2)
+	c.Timing(metricLatency, 150*time.Millisecond)
+
+	var packets []string
+	buf := make([]byte, 512)
+	for i := 0; i < 2; i++ {
+		sink.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := sink.ReadFrom(buf)
+		assert.NoError(t, err)
+		packets = append(packets, string(buf[:n]))
+	}
+
+	assert.Equal(t, []string{
+		"ssm_env.parameters.resolved:2|c",
+		"ssm_env.aws.latency:150|ms",
+	}, packets)
+}
+
+func TestExpandEnviron_Metrics(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	m := newFakeMetrics()
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: 1,
+		metrics:   m,
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+		},
+	}, nil)
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("secret-b")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]int64{
+		metricCalls:    2,
+		metricResolved: 1,
+		metricFailed:   1,
+	}, m.counts)
+	assert.Equal(t, 2, m.timings[metricLatency])
+
+	c.AssertExpectations(t)
+}
+
+func TestRetryMetricsHandler(t *testing.T) {
+	m := newFakeMetrics()
+
+	var handlers request.Handlers
+	addRetryMetricsHandler(&handlers, m)
+
+	// Retried, the SDK cleared the error.
+	handlers.AfterRetry.Run(&request.Request{})
+	// Not retried.
+	handlers.AfterRetry.Run(&request.Request{Error: errors.New("AccessDenied")})
+
+	assert.Equal(t, map[string]int64{metricRetries: 1}, m.counts)
+}
+
+// fakeMetrics records the metrics it receives.
+type fakeMetrics struct {
+	sync.Mutex
+	counts    map[string]int64
+	timings   map[string]int
+	durations map[string][]time.Duration
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{
+		counts:    make(map[string]int64),
+		timings:   make(map[string]int),
+		durations: make(map[string][]time.Duration),
+	}
+}
+
+func (m *fakeMetrics) Count(name string, value int64) {
+	m.Lock()
+	defer m.Unlock()
+	m.counts[name] += value
+}
+
+func (m *fakeMetrics) Timing(name string, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.timings[name]++
+	m.durations[name] = append(m.durations[name], d)
+}
@@ -0,0 +1,128 @@
+package ssmenv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// namePolicy is what happens to environment variables whose name isn't a
+// valid POSIX name, either because a variable holding a reference has one, or
+// because a path name template returns one.
+type namePolicy string
+
+const (
+	// nameAllow resolves and sets them anyway, the default.
+	nameAllow namePolicy = "allow"
+
+	// nameSkip leaves references in them unresolved, and doesn't set the
+	// variables of parameters under paths.
+	nameSkip namePolicy = "skip"
+
+	// nameSanitize renames them with keyCaseAsIs.envName, replacing invalid
+	// characters with underscores.
+	nameSanitize namePolicy = "sanitize"
+
+	// nameError fails, or with -no-fail, warns and skips them.
+	nameError namePolicy = "error"
+)
+
+// validNamePolicy reports whether p is one of the name policies.
+func validNamePolicy(p namePolicy) bool {
+	return p == nameAllow || p == nameSkip || p == nameSanitize || p == nameError
+}
+
+// validEnvName reports whether name is a valid POSIX environment variable
+// name: letters, digits and underscores, not starting with a digit.
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// considered reports whether the environment variable k is resolved, given
+// -resolve-only-vars, -name-prefix and the variables skipped for their name.
+func (e *expander) considered(k string) bool {
+	if e.only != nil && !e.only[k] {
+		return false
+	}
+	if !strings.HasPrefix(k, e.namePrefix) {
+		return false
+	}
+	return !e.skipped[k]
+}
+
+// checkNames applies the name policy to the environment variables holding a
+// reference, before anything is resolved.
+func (e *expander) checkNames(nofail bool) error {
+	if e.invalidNames == "" || e.invalidNames == nameAllow {
+		return nil
+	}
+
+	vars := envMap(e.os.Environ())
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		if validEnvName(k) || !e.considered(k) || !e.isReference(k, vars[k]) {
+			continue
+		}
+
+		switch e.invalidNames {
+		case nameSkip:
+			e.logf("%s: not a valid name, skipped", k)
+			e.skipped[k] = true
+		case nameSanitize:
+			name := keyCaseAsIs.envName(k)
+			if _, ok := vars[name]; ok {
+				return fmt.Errorf("%s can't be renamed to %s, which is already set", k, name)
+			}
+			e.logf("%s: not a valid name, renamed to %s", k, name)
+			e.os.Unsetenv(k)
+			e.os.Setenv(name, vars[k])
+			vars[name] = vars[k]
+			if e.only != nil {
+				e.only[name] = true
+			}
+		default:
+			err := fmt.Errorf("%q isn't a valid environment variable name", k)
+			e.count(metricFailed, 1)
+			if !nofail {
+				return err
+			}
+			e.warnings().warn(err)
+			e.skipped[k] = true
+		}
+	}
+	return nil
+}
+
+// pathVarName applies the name policy to the name of a variable set from a
+// parameter under a path. An empty name skips the parameter.
+func (e *expander) pathVarName(name string) (string, error) {
+	if name == "" || validEnvName(name) {
+		return name, nil
+	}
+
+	switch e.invalidNames {
+	case nameSkip:
+		e.logf("%s: not a valid name, skipped", name)
+		return "", nil
+	case nameSanitize:
+		return keyCaseAsIs.envName(name), nil
+	case nameError:
+		return "", fmt.Errorf("%q isn't a valid environment variable name", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,213 @@
+package ssmenv
+
+import (
+	"errors"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_InvalidNames(t *testing.T) {
+	tests := []struct {
+		policy namePolicy
+		nofail bool
+		err    error
+		out    []string
+	}{
+		{nameAllow, false, nil, []string{
+			"SHELL=/bin/bash",
+			"SUPER_SECRET=value",
+			"TERM=screen-256color",
+			"my-secret=other-value",
+		}},
+		{nameSkip, false, nil, []string{
+			"SHELL=/bin/bash",
+			"SUPER_SECRET=value",
+			"TERM=screen-256color",
+			"my-secret=ssm://other-secret",
+		}},
+		{nameSanitize, false, nil, []string{
+			"SHELL=/bin/bash",
+			"SUPER_SECRET=value",
+			"TERM=screen-256color",
+			"my_secret=other-value",
+		}},
+		{nameError, false, errors.New(`"my-secret" isn't a valid environment variable name`), []string{
+			"SHELL=/bin/bash",
+			"SUPER_SECRET=ssm://secret",
+			"TERM=screen-256color",
+			"my-secret=ssm://other-secret",
+		}},
+		{nameError, true, nil, []string{
+			"SHELL=/bin/bash",
+			"SUPER_SECRET=value",
+			"TERM=screen-256color",
+			"my-secret=ssm://other-secret",
+		}},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:            template.Must(parseTemplate(DefaultTemplate)),
+			os:           os,
+			ssm:          c,
+			batchSize:    defaultBatchSize,
+			invalidNames: tt.policy,
+		}
+
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+		os.Setenv("my-secret", "ssm://other-secret")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("other-secret"), aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("other-secret"), Value: aws.String("other-value")},
+				{Name: aws.String("secret"), Value: aws.String("value")},
+			},
+		}, nil).Maybe()
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret"), Value: aws.String("value")},
+			},
+		}, nil).Maybe()
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		assert.Equal(t, tt.err, err, "%s", tt.policy)
+		assert.Equal(t, tt.out, os.Environ(), "%s", tt.policy)
+	}
+}
+
+func TestExpandEnviron_InvalidNamesSanitizeConflict(t *testing.T) {
+	os := newFakeEnviron()
+	e := expander{
+		t:            template.Must(parseTemplate(DefaultTemplate)),
+		os:           os,
+		ssm:          new(mockSSM),
+		batchSize:    defaultBatchSize,
+		invalidNames: nameSanitize,
+	}
+
+	os.Setenv("my-secret", "ssm://secret")
+	os.Setenv("my_secret", "set")
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "my-secret can't be renamed to my_secret, which is already set")
+}
+
+func TestExpandEnviron_InvalidPathNames(t *testing.T) {
+	tests := []struct {
+		policy namePolicy
+		err    error
+		out    []string
+	}{
+		{nameAllow, nil, []string{"MYAPP_API-KEY=abc", "MYAPP_TOKEN=def", "SHELL=/bin/bash", "TERM=screen-256color"}},
+		{nameSkip, nil, []string{"MYAPP_TOKEN=def", "SHELL=/bin/bash", "TERM=screen-256color"}},
+		{nameSanitize, nil, []string{"MYAPP_API_KEY=abc", "MYAPP_TOKEN=def", "SHELL=/bin/bash", "TERM=screen-256color"}},
+		{nameError, errors.New(`resolving parameters under /myapp for SSM_PREFIX: naming variable for /myapp/api-key: "MYAPP_API-KEY" isn't a valid environment variable name`), []string{"SHELL=/bin/bash", "SSM_PREFIX=ssm-path:///myapp", "TERM=screen-256color"}},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:                template.Must(parseTemplate(DefaultTemplate)),
+			os:               os,
+			ssm:              c,
+			batchSize:        defaultBatchSize,
+			pathNameTemplate: template.Must(parseTemplate(`MYAPP_{{ toUpper .Name }}`)),
+			invalidNames:     tt.policy,
+		}
+
+		os.Setenv("SSM_PREFIX", "ssm-path:///myapp")
+
+		c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+			Path:           aws.String("/myapp"),
+			Recursive:      aws.Bool(true),
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersByPathOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("/myapp/api-key"), Value: aws.String("abc")},
+				{Name: aws.String("/myapp/token"), Value: aws.String("def")},
+			},
+		}, nil)
+
+		decrypt := false
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		assert.Equal(t, tt.err, err, "%s", tt.policy)
+		assert.Equal(t, tt.out, os.Environ(), "%s", tt.policy)
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestValidEnvName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"SUPER_SECRET", true},
+		{"_private", true},
+		{"A1", true},
+		{"", false},
+		{"1A", false},
+		{"my-secret", false},
+		{"my.secret", false},
+		{"CLÉ", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.valid, validEnvName(tt.name), tt.name)
+	}
+}
+
+func TestExpandEnviron_NamePrefix(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:          template.Must(parseTemplate(DefaultTemplate)),
+		os:         os,
+		ssm:        c,
+		batchSize:  defaultBatchSize,
+		namePrefix: "APP_",
+	}
+
+	os.Setenv("APP_SECRET", "ssm://secret")
+	os.Setenv("OTHER_SECRET", "ssm://other-secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"APP_SECRET=hehe",
+		"OTHER_SECRET=ssm://other-secret",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
@@ -0,0 +1,173 @@
+package ssmenv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// PathPrefix marks an environment variable value as a reference to every
+// SSM parameter under a path, whether or not it ends in a slash.
+const PathPrefix = "ssm-path://"
+
+// wildcardPath returns the path a parameter name ending in /* or / refers to
+// every parameter under, e.g. /myapp for /myapp/*.
+func wildcardPath(name string) (string, bool) {
+	name = strings.TrimSuffix(name, "*")
+	if !strings.HasSuffix(name, "/") {
+		return "", false
+	}
+	if path := strings.TrimRight(name, "/"); path != "" {
+		return path, true
+	}
+	return "/", true
+}
+
+// expandPaths resolves the environment variables that reference every
+// parameter under a path. Each parameter is set as its own environment
+// variable, and the variable holding the reference is unset.
+func (e *expander) expandPaths(ctx context.Context, pathVars []ssmVar, nofail bool) error {
+	for _, v := range pathVars {
+		err := ctx.Err()
+		if err == nil {
+			err = e.setPathVars(ctx, v, nofail)
+		}
+		if err != nil {
+			err = fmt.Errorf("resolving parameters under %s for %s: %v", v.parameter, v.envvar, err)
+			e.count(metricFailed, 1)
+			if !nofail {
+				return err
+			}
+			e.warnings().warn(err)
+		}
+	}
+	return nil
+}
+
+func (e *expander) setPathVars(ctx context.Context, v ssmVar, nofail bool) error {
+	params, err := e.getParametersByPath(ctx, v.parameter, v.decrypt)
+	if err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		return errors.New("no parameters found")
+	}
+
+	vars, err := pathVars(v.parameter, params, e.pathVarNamer())
+	if err != nil {
+		return err
+	}
+
+	e.os.Unsetenv(v.envvar)
+	return e.setVars(vars, v.envvar, precedencePath, nofail)
+}
+
+// pathNamer returns the name of the environment variable for a parameter
+// under a path, given its name relative to the path and its full name. An
+// empty name skips the parameter.
+type pathNamer func(rel, param string) (string, error)
+
+// keyCaseNamer names variables after the relative name of their parameter,
+// with kc.envName, so /myapp/db/password under /myapp is set as DB_PASSWORD
+// by default.
+func keyCaseNamer(kc keyCase) pathNamer {
+	return func(rel, param string) (string, error) {
+		return kc.envName(rel), nil
+	}
+}
+
+// pathVarNamer returns the pathNamer for the variables set from paths: the
+// path name template, if there's one, and keyCaseNamer otherwise, given
+// names relative to stripPrefix for the parameters under it.
+func (e *expander) pathVarNamer() pathNamer {
+	name := e.pathTemplateNamer()
+	if e.pathNameTemplate == nil {
+		name = keyCaseNamer(e.keyCase)
+	}
+	if e.stripPrefix == "" {
+		return name
+	}
+	prefix := strings.TrimSuffix(e.stripPrefix, "/") + "/"
+	return func(rel, param string) (string, error) {
+		if strings.HasPrefix(param, prefix) {
+			rel = strings.TrimPrefix(param, prefix)
+		}
+		return name(rel, param)
+	}
+}
+
+// pathTemplateNamer returns the pathNamer running the path name template.
+func (e *expander) pathTemplateNamer() pathNamer {
+	return func(rel, param string) (string, error) {
+		b := new(bytes.Buffer)
+		err := e.pathNameTemplate.Execute(b, struct{ Name, Parameter string }{rel, param})
+		if err != nil {
+			return "", err
+		}
+		return e.pathVarName(strings.TrimSpace(b.String()))
+	}
+}
+
+// pathVars returns the environment variables for the parameters under path,
+// named by name. Parameters that end up with the same name are an error.
+func pathVars(path string, params map[string]string, name pathNamer) (map[string]string, error) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+
+	vars := make(map[string]string)
+	from := make(map[string]string)
+	for param, value := range params {
+		n, err := name(strings.TrimPrefix(param, prefix), param)
+		if err != nil {
+			return nil, fmt.Errorf("naming variable for %s: %v", param, err)
+		}
+		if n == "" {
+			continue
+		}
+		if other, ok := from[n]; ok {
+			if other > param {
+				other, param = param, other
+			}
+			return nil, fmt.Errorf("%q and %q are both set as %s", other, param, n)
+		}
+		vars[n] = value
+		from[n] = param
+	}
+	return vars, nil
+}
+
+// getParametersByPath returns the values of every parameter under path,
+// keyed by name. Unless flatPaths is set, that includes the parameters
+// nested under other paths.
+func (e *expander) getParametersByPath(ctx context.Context, path string, decrypt bool) (map[string]string, error) {
+	input := &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(!e.flatPaths),
+		WithDecryption: aws.Bool(decrypt),
+	}
+
+	params := make(map[string]string)
+	for {
+		start := e.now()
+		resp, err := e.ssm.GetParametersByPathWithContext(ctx, input)
+		e.count(metricCalls, 1)
+		e.logCall("ssm:GetParametersByPath of "+path, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range resp.Parameters {
+			e.iam.add("ssm:GetParametersByPath", pathARN(path, aws.StringValue(p.Name), aws.StringValue(p.ARN)))
+			params[aws.StringValue(p.Name)] = aws.StringValue(p.Value)
+		}
+
+		if resp.NextToken == nil {
+			return params, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
@@ -0,0 +1,327 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_Path(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("APP", "ssm:///myapp/*")
+	os.Setenv("API_KEY", "ssm://api-key")
+
+	c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+		Path:           aws.String("/myapp"),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersByPathOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/db/password"), Value: aws.String("hunter2")},
+			{Name: aws.String("/myapp/api-key"), Value: aws.String("from-path")},
+		},
+		NextToken: aws.String("next"),
+	}, nil)
+	c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+		Path:           aws.String("/myapp"),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+		NextToken:      aws.String("next"),
+	}).Return(&ssm.GetParametersByPathOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/port"), Value: aws.String("5432")},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("api-key")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("api-key"), Value: aws.String("explicit")},
+		},
+	}, nil)
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"API_KEY=explicit",
+		"DB_PASSWORD=hunter2",
+		"PORT=5432",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_PathPrefix(t *testing.T) {
+	tests := []struct {
+		ref       string
+		flatPaths bool
+	}{
+		{"ssm-path:///myapp/prod/", false},
+		{"ssm-path:///myapp/prod", true},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+			flatPaths: tt.flatPaths,
+		}
+
+		os.Setenv("SSM_PREFIX", tt.ref)
+
+		c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+			Path:           aws.String("/myapp/prod"),
+			Recursive:      aws.Bool(!tt.flatPaths),
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersByPathOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("/myapp/prod/db-password"), Value: aws.String("hunter2")},
+				{Name: aws.String("/myapp/prod/port"), Value: aws.String("5432")},
+			},
+		}, nil)
+
+		decrypt := false
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"DB_PASSWORD=hunter2",
+			"PORT=5432",
+			"SHELL=/bin/bash",
+			"TERM=screen-256color",
+		}, os.Environ(), tt.ref)
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_PathNameTemplate(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:                template.Must(parseTemplate(DefaultTemplate)),
+		os:               os,
+		ssm:              c,
+		batchSize:        defaultBatchSize,
+		pathNameTemplate: template.Must(parseTemplate(`{{ if ne .Name "internal" }}MYAPP_{{ toUpper .Name }}{{ end }}`)),
+	}
+
+	os.Setenv("SSM_PREFIX", "ssm-path:///myapp")
+
+	c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+		Path:           aws.String("/myapp"),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersByPathOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/token"), Value: aws.String("abc")},
+			{Name: aws.String("/myapp/internal"), Value: aws.String("skipped")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"MYAPP_TOKEN=abc",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_PathStripPrefix(t *testing.T) {
+	for _, prefix := range []string{"/myapp/prod/", "/myapp/prod"} {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:           template.Must(parseTemplate(DefaultTemplate)),
+			os:          os,
+			ssm:         c,
+			batchSize:   defaultBatchSize,
+			keyCase:     keyCaseUpper,
+			stripPrefix: prefix,
+		}
+
+		os.Setenv("SSM_PREFIX", "ssm-path:///myapp")
+
+		c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+			Path:           aws.String("/myapp"),
+			Recursive:      aws.Bool(true),
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersByPathOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("/myapp/prod/DB_HOST"), Value: aws.String("db.internal")},
+				{Name: aws.String("/myapp/prod/db/port"), Value: aws.String("5432")},
+				// Parameters that aren't under the prefix are named
+				// relative to the path.
+				{Name: aws.String("/myapp/shared/token"), Value: aws.String("abc")},
+			},
+		}, nil)
+
+		decrypt := false
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"DB_HOST=db.internal",
+			"DB_PORT=5432",
+			"SHARED_TOKEN=abc",
+			"SHELL=/bin/bash",
+			"TERM=screen-256color",
+		}, os.Environ())
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_PathKeyCase(t *testing.T) {
+	tests := []struct {
+		kc   keyCase
+		vars []string
+	}{
+		{keyCaseUpper, []string{"API_KEY=abc", "DB_PASSWORD=hunter2"}},
+		{keyCaseLower, []string{"api_key=abc", "db_password=hunter2"}},
+		{keyCaseAsIs, []string{"api_Key=abc", "db_Password=hunter2"}},
+	}
+
+	for _, tt := range tests {
+		os := fakeEnviron{}
+		c := new(mockSSM)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+			keyCase:   tt.kc,
+		}
+
+		os.Setenv("APP", "ssm:///myapp/*")
+		os.Setenv("BUNDLE", "ssm-json:///bundle")
+
+		c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+			Path:           aws.String("/myapp"),
+			Recursive:      aws.Bool(true),
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersByPathOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("/myapp/db/Password"), Value: aws.String("hunter2")},
+			},
+		}, nil)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("/bundle")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("/bundle"), Value: aws.String(`{"api-Key": "abc"}`)},
+			},
+		}, nil)
+
+		decrypt := false
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		assert.NoError(t, err)
+
+		assert.Equal(t, tt.vars, os.Environ())
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_PathEmpty(t *testing.T) {
+	tests := []struct {
+		nofail bool
+		err    string
+	}{
+		{false, "resolving parameters under /myapp for APP: no parameters found"},
+		{true, ""},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+		}
+
+		os.Setenv("APP", "ssm:///myapp/")
+
+		c.On("GetParametersByPath", &ssm.GetParametersByPathInput{
+			Path:           aws.String("/myapp"),
+			Recursive:      aws.Bool(true),
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersByPathOutput{}, nil)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, "ssm:///myapp/", os["APP"])
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestWildcardPath(t *testing.T) {
+	tests := []struct {
+		in   string
+		path string
+		ok   bool
+	}{
+		{"/myapp/*", "/myapp", true},
+		{"/myapp/", "/myapp", true},
+		{"/myapp//*", "/myapp", true},
+		{"/*", "/", true},
+		{"/myapp", "", false},
+		{"/myapp*", "", false},
+		{"/myapp/secret:1", "", false},
+	}
+
+	for _, tt := range tests {
+		path, ok := wildcardPath(tt.in)
+		assert.Equal(t, tt.ok, ok, tt.in)
+		assert.Equal(t, tt.path, path, tt.in)
+	}
+}
+
+func TestPathVars(t *testing.T) {
+	vars, err := pathVars("/", map[string]string{"/a/b": "1", "/c": "2"}, keyCaseNamer(keyCaseUpper))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"A_B": "1", "C": "2"}, vars)
+
+	_, err = pathVars("/myapp", map[string]string{"/myapp/db-password": "1", "/myapp/db_password": "2"}, keyCaseNamer(keyCaseUpper))
+	assert.EqualError(t, err, `"/myapp/db-password" and "/myapp/db_password" are both set as DB_PASSWORD`)
+}
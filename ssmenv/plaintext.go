@@ -0,0 +1,56 @@
+package ssmenv
+
+import (
+	"context"
+	"fmt"
+)
+
+// plaintextPhase returns the phase that sets every environment variable
+// holding a reference that one of refs resolves to the value of its plaintext
+// companion, if it has one, so that references can be skipped during
+// development without AWS access. The companion of a variable is the one of
+// the same name with plaintextSuffix appended, e.g. DB_PASSWORD_PLAINTEXT
+// for DB_PASSWORD.
+func (e *expander) plaintextPhase(refs []phase) phase {
+	isReference := func(k, v string) bool {
+		for _, p := range refs {
+			if p.matches(k, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return phase{
+		expand: func(ctx context.Context, decrypt bool, nofail bool) error {
+			envvars := e.os.Environ()
+			vars := envMap(envvars)
+			for _, envvar := range envvars {
+				k, v := splitVar(envvar)
+
+				if !e.considered(k) {
+					continue
+				}
+
+				plaintext, ok := vars[k+e.plaintextSuffix]
+				if !ok {
+					continue
+				}
+
+				v, err := e.preTransform(v)
+				if err != nil {
+					return fmt.Errorf("pre-transforming %s: %v", k, err)
+				}
+				if isReference(k, v) {
+					e.targets[k] = target{k, precedenceExplicit}
+					e.setResolved(k, plaintext)
+				}
+			}
+			return nil
+		},
+		matches: func(k, v string) bool {
+			_, ok := envMap(e.os.Environ())[k+e.plaintextSuffix]
+			return ok && isReference(k, v)
+		},
+	}
+}
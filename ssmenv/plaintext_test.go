@@ -0,0 +1,89 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_PlaintextCompanion(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:               template.Must(parseTemplate(DefaultTemplate)),
+		os:              os,
+		ssm:             c,
+		kms:             k,
+		batchSize:       defaultBatchSize,
+		plaintextSuffix: "_PLAINTEXT",
+	}
+
+	os.Setenv("DB_PASSWORD", "ssm:///myapp/db-password")
+	os.Setenv("DB_PASSWORD_PLAINTEXT", "hunter2")
+	os.Setenv("API_KEY", "!kms Y2lwaGVydGV4dA==")
+	os.Setenv("API_KEY_PLAINTEXT", "")
+	os.Setenv("RAILS_ENV_PLAINTEXT", "development")
+
+	// No calls are made, since every reference has a companion.
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"API_KEY=",
+		"API_KEY_PLAINTEXT=",
+		"DB_PASSWORD=hunter2",
+		"DB_PASSWORD_PLAINTEXT=hunter2",
+		"RAILS_ENV_PLAINTEXT=development",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_PlaintextCompanionAbsent(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:               template.Must(parseTemplate(DefaultTemplate)),
+		os:              os,
+		ssm:             c,
+		batchSize:       defaultBatchSize,
+		plaintextSuffix: "_PLAINTEXT",
+	}
+
+	os.Setenv("DB_PASSWORD", "ssm:///myapp/db-password")
+	os.Setenv("API_KEY", "ssm:///myapp/api-key")
+	os.Setenv("API_KEY_PLAINTEXT", "dev-key")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/db-password")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/db-password"), Value: aws.String("from-ssm")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"API_KEY=dev-key",
+		"API_KEY_PLAINTEXT=dev-key",
+		"DB_PASSWORD=from-ssm",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
@@ -0,0 +1,77 @@
+package ssmenv
+
+// RequiredPrefix marks an environment variable value as a reference to an SSM
+// parameter that must exist, even with -no-fail.
+const RequiredPrefix = "ssm+required://"
+
+// OptionalPrefix marks an environment variable value as a reference to an SSM
+// parameter that may be missing, even without -no-fail, in which case the
+// reference is left in place.
+const OptionalPrefix = "ssm+optional://"
+
+// missingPolicy is how a reference to a parameter that doesn't exist is
+// treated.
+type missingPolicy int
+
+const (
+	// missingDefault follows -no-fail.
+	missingDefault missingPolicy = iota
+	missingRequired
+	missingOptional
+)
+
+// tolerated reports whether a missing parameter is left unresolved, instead
+// of being an error.
+func (p missingPolicy) tolerated(nofail bool) bool {
+	switch p {
+	case missingRequired:
+		return false
+	case missingOptional:
+		return true
+	default:
+		return nofail
+	}
+}
+
+// merge returns the policy of a parameter referenced with both p and q. It's
+// required if any reference is, and optional only if every reference is.
+func (p missingPolicy) merge(q missingPolicy) missingPolicy {
+	if p == missingRequired || q == missingRequired {
+		return missingRequired
+	}
+	if p == missingOptional && q == missingOptional {
+		return missingOptional
+	}
+	return missingDefault
+}
+
+// setPolicy records the policy a parameter is referenced with, merging it
+// with that of earlier references to it.
+func (e *expander) setPolicy(name string, p missingPolicy) {
+	if q, ok := e.policies[name]; ok {
+		p = p.merge(q)
+	}
+	e.policies[name] = p
+}
+
+// intolerable returns the names of the missing parameters that are an error,
+// given nofail.
+func (e *expander) intolerable(missing []string, nofail bool) []string {
+	var names []string
+	for _, name := range missing {
+		p := e.policies[name]
+		if p == missingDefault {
+			p = e.onMissing
+		}
+		if !p.tolerated(nofail) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// hasPolicyPrefix reports whether v is a reference with a required or
+// optional modifier.
+func hasPolicyPrefix(v string) bool {
+	return hasPrefixFold(v, RequiredPrefix) || hasPrefixFold(v, OptionalPrefix)
+}
@@ -0,0 +1,201 @@
+package ssmenv
+
+import (
+	"errors"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_MissingPolicy(t *testing.T) {
+	tests := []struct {
+		nofail bool
+		err    error
+	}{
+		// A missing optional parameter is tolerated without -no-fail.
+		{false, &invalidParametersError{InvalidParameters: []string{"required-secret"}}},
+		// A missing required parameter fails even with -no-fail.
+		{true, &invalidParametersError{InvalidParameters: []string{"required-secret"}}},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+		}
+
+		os.Setenv("OPTIONAL_SECRET", "ssm+optional://optional-secret")
+		os.Setenv("REQUIRED_SECRET", "ssm+required://required-secret")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("optional-secret"), aws.String("required-secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			InvalidParameters: []*string{aws.String("optional-secret"), aws.String("required-secret")},
+		}, nil)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		assert.Equal(t, tt.err, err)
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_MissingOptional(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("OPTIONAL_SECRET", "ssm+optional://optional-secret")
+	os.Setenv("REQUIRED_SECRET", "ssm+required://required-secret")
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("optional-secret"), aws.String("required-secret"), aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("required-secret"), Value: aws.String("required-value")},
+			{Name: aws.String("secret"), Value: aws.String("value")},
+		},
+		InvalidParameters: []*string{aws.String("optional-secret")},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"OPTIONAL_SECRET=ssm+optional://optional-secret",
+		"REQUIRED_SECRET=required-value",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=value",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestMissingPolicy_Merge(t *testing.T) {
+	tests := []struct {
+		p, q missingPolicy
+		out  missingPolicy
+	}{
+		{missingDefault, missingDefault, missingDefault},
+		{missingOptional, missingOptional, missingOptional},
+		{missingOptional, missingDefault, missingDefault},
+		{missingOptional, missingRequired, missingRequired},
+		{missingDefault, missingRequired, missingRequired},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, tt.p.merge(tt.q))
+		assert.Equal(t, tt.out, tt.q.merge(tt.p))
+	}
+}
+
+func TestExpandEnviron_FailOnMissing(t *testing.T) {
+	missing := &ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("value")},
+		},
+		InvalidParameters: []*string{aws.String("missing-secret")},
+	}
+	throttled := errors.New("ThrottlingException: Rate exceeded")
+
+	tests := []struct {
+		onMissing missingPolicy
+		nofail    bool
+		awsErr    error
+		err       error
+	}{
+		// By default, missing parameters follow -no-fail.
+		{missingDefault, false, nil, &invalidParametersError{InvalidParameters: []string{"missing-secret"}}},
+		{missingDefault, true, nil, nil},
+
+		// -fail-on-missing=false tolerates missing parameters, but not
+		// other errors.
+		{missingOptional, false, nil, nil},
+		{missingOptional, false, throttled, throttled},
+		{missingOptional, true, throttled, nil},
+
+		// -fail-on-missing fails on missing parameters, but tolerates other
+		// errors with -no-fail.
+		{missingRequired, true, nil, &invalidParametersError{InvalidParameters: []string{"missing-secret"}}},
+		{missingRequired, true, throttled, nil},
+		{missingRequired, false, throttled, throttled},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+			onMissing: tt.onMissing,
+		}
+
+		os.Setenv("MISSING_SECRET", "ssm://missing-secret")
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		var resp *ssm.GetParametersOutput
+		if tt.awsErr == nil {
+			resp = missing
+		}
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("missing-secret"), aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(resp, tt.awsErr)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		assert.Equal(t, tt.err, err, "%v %v %v", tt.onMissing, tt.nofail, tt.awsErr)
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_FailOnMissingExplicitPolicy(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		onMissing: missingRequired,
+	}
+
+	// ssm+optional:// still wins over -fail-on-missing.
+	os.Setenv("OPTIONAL_SECRET", "ssm+optional://optional-secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("optional-secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("optional-secret")},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	c.AssertExpectations(t)
+}
@@ -0,0 +1,86 @@
+package ssmenv
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// identity is the user and group to run the command as. A negative id is
+// left unchanged.
+type identity struct {
+	uid, gid int
+}
+
+// lookupIdentity resolves -user and -group, which can be names or numeric
+// ids. When only a user is given, the command runs with that user's
+// primary group.
+func lookupIdentity(userName, groupName string) (identity, error) {
+	id := identity{uid: -1, gid: -1}
+
+	if userName != "" {
+		var (
+			u   *user.User
+			err error
+		)
+		if uid, convErr := strconv.Atoi(userName); convErr == nil {
+			id.uid = uid
+			u, err = user.LookupId(userName)
+		} else {
+			u, err = user.Lookup(userName)
+			if err != nil {
+				return id, err
+			}
+			id.uid, _ = strconv.Atoi(u.Uid)
+		}
+
+		if err == nil {
+			id.gid, _ = strconv.Atoi(u.Gid)
+		} else if groupName == "" {
+			// Keeping our own group would keep the privileges we're
+			// trying to drop.
+			return id, fmt.Errorf("can't determine the group of user %s, set -group too: %v", userName, err)
+		}
+	}
+
+	if groupName != "" {
+		if gid, err := strconv.Atoi(groupName); err == nil {
+			id.gid = gid
+		} else {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return id, err
+			}
+			id.gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+
+	return id, nil
+}
+
+// privilegeSyscalls are the system calls used to drop privileges.
+type privilegeSyscalls interface {
+	Setgroups(gids []int) error
+	Setgid(gid int) error
+	Setuid(uid int) error
+}
+
+// dropPrivileges switches to the given identity. Supplementary groups and the
+// group have to be changed first, since changing the user gives up the
+// privileges needed to do so.
+func dropPrivileges(sys privilegeSyscalls, id identity) error {
+	if id.gid >= 0 {
+		if err := sys.Setgroups([]int{id.gid}); err != nil {
+			return fmt.Errorf("setting supplementary groups: %v", err)
+		}
+		if err := sys.Setgid(id.gid); err != nil {
+			return fmt.Errorf("setting group id: %v", err)
+		}
+	}
+	if id.uid >= 0 {
+		if err := sys.Setuid(id.uid); err != nil {
+			return fmt.Errorf("setting user id: %v", err)
+		}
+	}
+	return nil
+}
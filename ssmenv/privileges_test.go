@@ -0,0 +1,87 @@
+package ssmenv
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropPrivileges(t *testing.T) {
+	sys := new(fakePrivileges)
+
+	err := dropPrivileges(sys, identity{uid: 1000, gid: 100})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"setgroups [100]",
+		"setgid 100",
+		"setuid 1000",
+	}, sys.calls)
+}
+
+func TestDropPrivileges_GroupOnly(t *testing.T) {
+	sys := new(fakePrivileges)
+
+	err := dropPrivileges(sys, identity{uid: -1, gid: 100})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"setgroups [100]",
+		"setgid 100",
+	}, sys.calls)
+}
+
+func TestDropPrivileges_Error(t *testing.T) {
+	sys := &fakePrivileges{err: errors.New("operation not permitted")}
+
+	err := dropPrivileges(sys, identity{uid: 1000, gid: 100})
+	assert.EqualError(t, err, "setting supplementary groups: operation not permitted")
+
+	// The user isn't changed if the group couldn't be.
+	assert.Equal(t, []string{"setgroups [100]"}, sys.calls)
+}
+
+func TestLookupIdentity(t *testing.T) {
+	id, err := lookupIdentity("0", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, identity{uid: 0, gid: 0}, id)
+
+	id, err = lookupIdentity("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, identity{uid: -1, gid: -1}, id)
+
+	id, err = lookupIdentity("", "100")
+	assert.NoError(t, err)
+	assert.Equal(t, identity{uid: -1, gid: 100}, id)
+
+	// An unknown numeric user is fine, as long as the group is given.
+	id, err = lookupIdentity("54321", "54321")
+	assert.NoError(t, err)
+	assert.Equal(t, identity{uid: 54321, gid: 54321}, id)
+
+	_, err = lookupIdentity("54321", "")
+	assert.Error(t, err)
+}
+
+// fakePrivileges records the privilege dropping system calls.
+type fakePrivileges struct {
+	calls []string
+	err   error
+}
+
+func (p *fakePrivileges) Setgroups(gids []int) error {
+	p.calls = append(p.calls, fmt.Sprintf("setgroups %v", gids))
+	return p.err
+}
+
+func (p *fakePrivileges) Setgid(gid int) error {
+	p.calls = append(p.calls, fmt.Sprintf("setgid %d", gid))
+	return p.err
+}
+
+func (p *fakePrivileges) Setuid(uid int) error {
+	p.calls = append(p.calls, fmt.Sprintf("setuid %d", uid))
+	return p.err
+}
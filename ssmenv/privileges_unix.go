@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package ssmenv
+
+import "syscall"
+
+// osPrivileges drops the privileges of the process.
+type osPrivileges struct{}
+
+func (osPrivileges) Setgroups(gids []int) error { return syscall.Setgroups(gids) }
+func (osPrivileges) Setgid(gid int) error       { return syscall.Setgid(gid) }
+func (osPrivileges) Setuid(uid int) error       { return syscall.Setuid(uid) }
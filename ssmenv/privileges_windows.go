@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package ssmenv
+
+import "errors"
+
+var errPrivilegesNotSupported = errors.New("-user and -group are not supported on this platform")
+
+// osPrivileges drops the privileges of the process.
+type osPrivileges struct{}
+
+func (osPrivileges) Setgroups(gids []int) error { return errPrivilegesNotSupported }
+func (osPrivileges) Setgid(gid int) error       { return errPrivilegesNotSupported }
+func (osPrivileges) Setuid(uid int) error       { return errPrivilegesNotSupported }
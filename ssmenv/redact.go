@@ -0,0 +1,35 @@
+package ssmenv
+
+import (
+	"fmt"
+	"path"
+)
+
+// nameRedactor masks the names of environment variables matching any of its
+// glob patterns in reports, for names that are sensitive themselves, e.g.
+// because they contain a customer identifier. Variables are resolved the same
+// whether their names are masked or not.
+type nameRedactor []string
+
+// parseNameRedactor returns the nameRedactor for a comma separated list of
+// glob patterns.
+func parseNameRedactor(s string) (nameRedactor, error) {
+	patterns := splitList(s)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nameRedactor(patterns), nil
+}
+
+// redact returns name, or a hash of it if it matches any of the patterns,
+// so that masked names can still be told apart.
+func (r nameRedactor) redact(name string) string {
+	for _, pattern := range r {
+		if ok, _ := path.Match(pattern, name); ok {
+			return "redacted(" + valueHash(name) + ")"
+		}
+	}
+	return name
+}
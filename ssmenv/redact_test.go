@@ -0,0 +1,84 @@
+package ssmenv
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameRedactor(t *testing.T) {
+	r, err := parseNameRedactor("CUSTOMER_*, *_ACME")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "redacted("+valueHash("CUSTOMER_1234_KEY")+")", r.redact("CUSTOMER_1234_KEY"))
+	assert.Equal(t, "redacted("+valueHash("DB_ACME")+")", r.redact("DB_ACME"))
+	assert.Equal(t, "DB_PASSWORD", r.redact("DB_PASSWORD"))
+	assert.Equal(t, "DB_PASSWORD", nameRedactor(nil).redact("DB_PASSWORD"))
+
+	_, err = parseNameRedactor("CUSTOMER_[")
+	assert.EqualError(t, err, `invalid pattern "CUSTOMER_[": syntax error in pattern`)
+}
+
+func TestPrintDifferences_RedactedNames(t *testing.T) {
+	prod := new(mockSSM)
+	staging := new(mockSSM)
+	a := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		ssm:       prod,
+		batchSize: defaultBatchSize,
+	}
+	b := a
+	b.ssm = staging
+	b.rename = prefixRename("/prod/", "/staging/")
+
+	vars := map[string]string{
+		"CUSTOMER_1234_KEY": "ssm:///prod/customer-1234-key",
+		"DB_PASSWORD":       "ssm:///prod/db-password",
+	}
+
+	prod.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/prod/customer-1234-key"), aws.String("/prod/db-password")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/prod/customer-1234-key"), Value: aws.String("prod-key")},
+			{Name: aws.String("/prod/db-password"), Value: aws.String("prod-password")},
+		},
+	}, nil)
+	staging.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/staging/customer-1234-key"), aws.String("/staging/db-password")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/staging/customer-1234-key"), Value: aws.String("staging-key")},
+			{Name: aws.String("/staging/db-password"), Value: aws.String("staging-password")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	diffs, err := compare(a, b, vars, decrypt, nofail)
+	assert.NoError(t, err)
+
+	// The variable is resolved as usual, and only masked when printed.
+	assert.Equal(t, []difference{
+		{"CUSTOMER_1234_KEY", valueHash("prod-key"), valueHash("staging-key")},
+		{"DB_PASSWORD", valueHash("prod-password"), valueHash("staging-password")},
+	}, diffs)
+
+	r, err := parseNameRedactor("CUSTOMER_*")
+	assert.NoError(t, err)
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, printDifferences(out, diffs, r))
+	assert.Equal(t, "redacted("+valueHash("CUSTOMER_1234_KEY")+"): "+valueHash("prod-key")+" != "+valueHash("staging-key")+"\n"+
+		"DB_PASSWORD: "+valueHash("prod-password")+" != "+valueHash("staging-password")+"\n", out.String())
+	assert.NotContains(t, out.String(), "CUSTOMER")
+
+	prod.AssertExpectations(t)
+	staging.AssertExpectations(t)
+}
@@ -0,0 +1,62 @@
+package ssmenv
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// retryValueDelay is how long to wait before fetching parameters whose
+// value matches retryValue again. It doubles after every attempt.
+const retryValueDelay = 500 * time.Millisecond
+
+// getSettledParameters is getParameters, fetching parameters whose value
+// matches retryValue again, until it doesn't, for up to retryAttempts
+// attempts in total. Parameters that still match are an error, or with
+// nofail, left unresolved. Waiting between attempts stops once ctx is done,
+// with its error.
+func (e *expander) getSettledParameters(ctx context.Context, names []string, fallbacks map[string]bool, decrypt bool, nofail bool) (map[string]string, error) {
+	values, err := e.getParameters(ctx, names, fallbacks, decrypt, nofail)
+	if err != nil || e.retryValue == nil {
+		return values, err
+	}
+
+	delay := retryValueDelay
+	for attempt := 1; ; attempt++ {
+		var pending []string
+		for _, name := range names {
+			if v, ok := values[name]; ok && e.retryValue.MatchString(v) {
+				pending = append(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			return values, nil
+		}
+
+		if attempt >= e.retryAttempts {
+			err := fmt.Errorf("parameters still match %s after %d attempts: %v", e.retryValue, attempt, pending)
+			e.count(metricFailed, int64(len(pending)))
+			if !nofail {
+				return values, err
+			}
+			e.warnings().warn(err)
+			for _, name := range pending {
+				delete(values, name)
+			}
+			return values, nil
+		}
+
+		if err := e.sleepContext(ctx, delay); err != nil {
+			return values, err
+		}
+		delay *= 2
+
+		retried, err := e.getParameters(ctx, pending, fallbacks, decrypt, nofail)
+		if err != nil {
+			return values, err
+		}
+		for name, v := range retried {
+			values[name] = v
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package ssmenv
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_RetryValue(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	clk := newFakeClock()
+	e := expander{
+		t:             template.Must(parseTemplate(DefaultTemplate)),
+		os:            os,
+		ssm:           c,
+		batchSize:     defaultBatchSize,
+		clock:         clk,
+		retryValue:    regexp.MustCompile(`^PENDING$`),
+		retryAttempts: 3,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+	os.Setenv("OTHER_SECRET", "ssm://other")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("other"), aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("other"), Value: aws.String("haha")},
+			{Name: aws.String("secret"), Value: aws.String("PENDING")},
+		},
+	}, nil).Once()
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil).Once()
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"OTHER_SECRET=haha",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+	assert.Equal(t, []time.Duration{500 * time.Millisecond}, clk.sleeps)
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_RetryValueExhausted(t *testing.T) {
+	tests := []struct {
+		nofail bool
+		err    string
+	}{
+		{false, "parameters still match ^PENDING$ after 3 attempts: [secret]"},
+		{true, ""},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		clk := newFakeClock()
+		e := expander{
+			t:             template.Must(parseTemplate(DefaultTemplate)),
+			os:            os,
+			ssm:           c,
+			batchSize:     defaultBatchSize,
+			clock:         clk,
+			retryValue:    regexp.MustCompile(`^PENDING$`),
+			retryAttempts: 3,
+		}
+
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret"), Value: aws.String("PENDING")},
+			},
+		}, nil).Times(3)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, "ssm://secret", os["SUPER_SECRET"])
+		assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, clk.sleeps)
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_RetryValueTimeout(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:             template.Must(parseTemplate(DefaultTemplate)),
+		os:            os,
+		ssm:           c,
+		batchSize:     defaultBatchSize,
+		retryValue:    regexp.MustCompile(`^PENDING$`),
+		retryAttempts: 5,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("PENDING")},
+		},
+	}, nil).Once()
+
+	// The deadline passes long before the first retry is due.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	decrypt := false
+	nofail := false
+	err := e.expandEnvironWithContext(ctx, decrypt, nofail)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < retryValueDelay, "waited %v", time.Since(start))
+	assert.Equal(t, "ssm://secret", os["SUPER_SECRET"])
+
+	c.AssertExpectations(t)
+}
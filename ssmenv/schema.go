@@ -0,0 +1,220 @@
+package ssmenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// schemaSeparator separates the name of a parameter from the path of the JSON
+// schema its value is validated against, as in
+// ssm:///myapp/config@schema=/etc/myapp/config.schema.json. Parameter names
+// can't contain "@".
+const schemaSeparator = "@schema="
+
+// splitSchema splits a reference into the name of the parameter and the path
+// of its schema, if it has one.
+func splitSchema(s string) (name, schema string) {
+	if i := strings.Index(s, schemaSeparator); i >= 0 {
+		return s[:i], s[i+len(schemaSeparator):]
+	}
+	return s, ""
+}
+
+// jsonSchema is a JSON schema. Only the keywords below are supported, and any
+// others are ignored.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes is the value of the type keyword, either a single type or a
+// list of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+// loadSchema reads the JSON schema in the file at path.
+func loadSchema(path string) (*jsonSchema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(jsonSchema)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parsing schema %s: %v", path, err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("parsing schema %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// compile compiles the patterns of s, and of the schemas nested in it.
+func (s *jsonSchema) compile() (err error) {
+	if s.Pattern != "" {
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// validate parses the JSON document v, and checks that it matches s.
+func (s *jsonSchema) validate(v string) error {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(v), &doc); err != nil {
+		return fmt.Errorf("parsing JSON: %v", err)
+	}
+	return s.check(doc, "")
+}
+
+// check checks that the decoded JSON value v, at the JSON pointer path,
+// matches s.
+func (s *jsonSchema) check(v interface{}, path string) error {
+	at := path
+	if at == "" {
+		at = "/"
+	}
+
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		return fmt.Errorf("%s: expected %s, got %s", at, strings.Join(s.Type, " or "), jsonType(v))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) && jsonType(e) == jsonType(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v isn't one of %v", at, v, s.Enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d characters", at, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: longer than %d characters", at, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: doesn't match %s", at, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: %v is less than %v", at, v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: %v is more than %v", at, v, *s.Maximum)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected property %q", at, k)
+				}
+				continue
+			}
+			if err := p.check(v[k], path+"/"+k); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.check(item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// match reports whether the decoded JSON value v is of one of the types.
+func (t schemaTypes) match(v interface{}) bool {
+	for _, typ := range t {
+		got := jsonType(v)
+		if typ == got {
+			return true
+		}
+		if f, ok := v.(float64); ok && typ == "integer" && f == math.Trunc(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON schema type of the decoded JSON value v.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,141 @@
+package ssmenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["host", "port"],
+	"additionalProperties": false,
+	"properties": {
+		"host": {"type": "string", "minLength": 1},
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"mode": {"enum": ["primary", "replica"]},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}}
+	}
+}`
+
+func TestExpandEnviron_Schema(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "db.schema.json")
+	assert.NoError(t, os.WriteFile(schema, []byte(testSchema), 0644))
+
+	tests := []struct {
+		value  string
+		nofail bool
+		err    string
+		env    string
+	}{
+		{`{"host": "db.internal", "port": 5432, "mode": "primary", "tags": ["main"]}`, false, "",
+			`{"host": "db.internal", "port": 5432, "mode": "primary", "tags": ["main"]}`},
+		{`{"host": "db.internal", "port": "5432"}`, false,
+			"validating DB_CONFIG against its schema: /port: expected integer, got string", ""},
+		// With nofail, the reference is left in place.
+		{`{"host": "db.internal"}`, true, "", "ssm:///myapp/db@schema=" + schema},
+	}
+
+	for _, tt := range tests {
+		env := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        env,
+			ssm:       c,
+			batchSize: defaultBatchSize,
+		}
+
+		env.Setenv("DB_CONFIG", "ssm:///myapp/db@schema="+schema)
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("/myapp/db")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("/myapp/db"), Value: aws.String(tt.value)},
+			},
+		}, nil)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.env, env["DB_CONFIG"])
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_SchemaMissing(t *testing.T) {
+	env := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        env,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	schema := filepath.Join(t.TempDir(), "missing.json")
+	env.Setenv("DB_CONFIG", "ssm:///myapp/db@schema="+schema)
+
+	// The schema is loaded before any call is made.
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "loading schema for DB_CONFIG: open "+schema)
+
+	c.AssertExpectations(t)
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "db.schema.json")
+	assert.NoError(t, os.WriteFile(schema, []byte(testSchema), 0644))
+	s, err := loadSchema(schema)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{`{"host": "db", "port": 1}`, ""},
+		{`{"host": "db", "port": 1.5}`, "/port: expected integer, got number"},
+		{`{"host": "db", "port": 70000}`, "/port: 70000 is more than 65535"},
+		{`{"host": "", "port": 1}`, "/host: shorter than 1 characters"},
+		{`{"host": "db"}`, `/: missing required property "port"`},
+		{`{"host": "db", "port": 1, "user": "app"}`, `/: unexpected property "user"`},
+		{`{"host": "db", "port": 1, "mode": "standby"}`, "/mode: standby isn't one of [primary replica]"},
+		{`{"host": "db", "port": 1, "tags": ["ok", "Not-OK"]}`, "/tags/1: doesn't match ^[a-z]+$"},
+		{`[]`, "/: expected object, got array"},
+		{`{`, "parsing JSON: unexpected end of JSON input"},
+	}
+
+	for _, tt := range tests {
+		err := s.validate(tt.value)
+		if tt.err == "" {
+			assert.NoError(t, err, tt.value)
+		} else {
+			assert.EqualError(t, err, tt.err, tt.value)
+		}
+	}
+}
+
+func TestSplitSchema(t *testing.T) {
+	name, schema := splitSchema("/myapp/db@schema=/etc/db.json")
+	assert.Equal(t, "/myapp/db", name)
+	assert.Equal(t, "/etc/db.json", schema)
+
+	name, schema = splitSchema("/myapp/db:1")
+	assert.Equal(t, "/myapp/db:1", name)
+	assert.Equal(t, "", schema)
+}
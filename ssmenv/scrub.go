@@ -0,0 +1,16 @@
+package ssmenv
+
+// zeroBytes overwrites b with zeros.
+//
+// With -zero-plaintext, the buffers decrypted plaintext is returned in by
+// the AWS SDK are zeroed once they've been copied into the environment, so
+// they don't linger in ssm-env's memory, and core dumps of it. This is best
+// effort: Go strings are immutable, so the copies held as strings, like the
+// values of SSM parameters and of environment variables themselves, can't be
+// zeroed, and are only reclaimed by the garbage collector. Once the command
+// is executed, the process image, and with it ssm-env's memory, is replaced.
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,91 @@
+package ssmenv
+
+import (
+	"encoding/base64"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_ZeroPlaintext(t *testing.T) {
+	tests := []struct {
+		zero bool
+		kms  []byte
+		sm   []byte
+	}{
+		{false, []byte("value-kms"), []byte("value-sm")},
+		{true, make([]byte, len("value-kms")), make([]byte, len("value-sm"))},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		k := new(mockKMS)
+		sm := new(mockSecretsManager)
+		e := expander{
+			t:             template.Must(parseTemplate(DefaultTemplate)),
+			os:            os,
+			ssm:           c,
+			kms:           k,
+			sm:            sm,
+			batchSize:     defaultBatchSize,
+			zeroPlaintext: tt.zero,
+		}
+
+		os.Setenv("KMS_SECRET", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+		os.Setenv("SM_SECRET", "ssm-or-sm:///secret")
+
+		kmsPlaintext := []byte("value-kms")
+		smPlaintext := []byte("value-sm")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("/secret")},
+			WithDecryption: aws.Bool(true),
+		}).Return(&ssm.GetParametersOutput{
+			InvalidParameters: []*string{aws.String("/secret")},
+		}, nil)
+		sm.On("GetSecretValue", &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String("secret"),
+		}).Return(&secretsmanager.GetSecretValueOutput{
+			SecretBinary: smPlaintext,
+		}, nil)
+		k.On("Decrypt", &kms.DecryptInput{
+			CiphertextBlob: []byte("ciphertext"),
+		}).Return(&kms.DecryptOutput{
+			Plaintext: kmsPlaintext,
+		}, nil)
+
+		decrypt := true
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		assert.NoError(t, err)
+
+		// The environment has its own copy of the values.
+		assert.Equal(t, []string{
+			"KMS_SECRET=value-kms",
+			"SHELL=/bin/bash",
+			"SM_SECRET=value-sm",
+			"TERM=screen-256color",
+		}, os.Environ())
+
+		assert.Equal(t, tt.kms, kmsPlaintext)
+		assert.Equal(t, tt.sm, smPlaintext)
+
+		c.AssertExpectations(t)
+		k.AssertExpectations(t)
+		sm.AssertExpectations(t)
+	}
+}
+
+func TestZeroBytes(t *testing.T) {
+	b := []byte("secret")
+	zeroBytes(b[:3])
+	assert.Equal(t, []byte{0, 0, 0, 'r', 'e', 't'}, b)
+
+	zeroBytes(nil)
+}
@@ -0,0 +1,98 @@
+package ssmenv
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// FallbackPrefix marks an environment variable value as a reference that
+// is looked up in SSM Parameter Store first, and in Secrets Manager if the
+// parameter doesn't exist.
+const FallbackPrefix = "ssm-or-sm://"
+
+// SecretsManagerClient is the part of the Secrets Manager API ssm-env uses,
+// implemented by *secretsmanager.SecretsManager.
+type SecretsManagerClient interface {
+	GetSecretValueWithContext(aws.Context, *secretsmanager.GetSecretValueInput, ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// lazySecretsManagerClient wraps the AWS SDK Secrets Manager client such that
+// the AWS session and client are not initialized until GetSecretValue is
+// called for the first time.
+type lazySecretsManagerClient struct {
+	sessions *sessionProvider
+
+	mu   sync.Mutex
+	sm   SecretsManagerClient
+	sess *session.Session
+}
+
+func (c *lazySecretsManagerClient) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	client, err := c.client(nil)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetSecretValueWithContext(ctx, input, opts...)
+	if isExpiredToken(err) {
+		// Retried once with fresh credentials, like lazySSMClient.
+		if client, err = c.client(client); err != nil {
+			return nil, err
+		}
+		out, err = client.GetSecretValueWithContext(ctx, input, opts...)
+	}
+	return out, err
+}
+
+// client returns the Secrets Manager client, initializing it (and the AWS
+// session) if it hasn't been already, or if it's the stale client.
+func (c *lazySecretsManagerClient) client(stale SecretsManagerClient) (SecretsManagerClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sm == nil || c.sm == stale {
+		sess, err := c.sessions.session(c.sess)
+		if err != nil {
+			return nil, err
+		}
+		c.sm = c.sessions.config.clients().newSecretsManager(sess)
+		c.sess = sess
+	}
+	return c.sm, nil
+}
+
+// getSecret fetches a secret from Secrets Manager. SSM parameter names are
+// absolute paths, while secret names conventionally are not, so a leading
+// slash is removed to build the secret id.
+//
+// A secret that doesn't exist is not an error: found is false, and err is
+// nil. Any other failure (access denied, throttling, ...) is returned as an
+// error.
+func (e *expander) getSecret(ctx context.Context, name string) (value string, found bool, err error) {
+	start := e.now()
+	resp, err := e.sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(strings.TrimPrefix(name, "/")),
+	})
+	e.logCall("secretsmanager:GetSecretValue of "+name, start, err)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	e.iam.add("secretsmanager:GetSecretValue", aws.StringValue(resp.ARN))
+
+	if resp.SecretString != nil {
+		return *resp.SecretString, true, nil
+	}
+	value = string(resp.SecretBinary)
+	if e.zeroPlaintext {
+		zeroBytes(resp.SecretBinary)
+	}
+	return value, true, nil
+}
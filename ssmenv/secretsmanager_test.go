@@ -0,0 +1,173 @@
+package ssmenv
+
+import (
+	"errors"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpandEnviron_FallbackSSMHit(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	sm := new(mockSecretsManager)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		sm:        sm,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm-or-sm:///secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/secret"), Value: aws.String("from-ssm")},
+		},
+	}, nil)
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=from-ssm",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	sm.AssertExpectations(t)
+}
+
+func TestExpandEnviron_FallbackSecretsManagerHit(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	sm := new(mockSecretsManager)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		sm:        sm,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm-or-sm:///secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("/secret")},
+	}, nil)
+
+	sm.On("GetSecretValue", &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String("secret"),
+	}).Return(&secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String("from-secrets-manager"),
+	}, nil)
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=from-secrets-manager",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	sm.AssertExpectations(t)
+}
+
+func TestExpandEnviron_FallbackNotFound(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	sm := new(mockSecretsManager)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		sm:        sm,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm-or-sm:///secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("/secret")},
+	}, nil)
+
+	sm.On("GetSecretValue", &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String("secret"),
+	}).Return((*secretsmanager.GetSecretValueOutput)(nil), awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil))
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.Equal(t, &invalidParametersError{InvalidParameters: []string{"/secret"}}, err)
+
+	c.AssertExpectations(t)
+	sm.AssertExpectations(t)
+}
+
+func TestExpandEnviron_FallbackSecretsManagerError(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	sm := new(mockSecretsManager)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		sm:        sm,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm-or-sm:///secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("/secret")},
+	}, nil)
+
+	errAccessDenied := errors.New("access denied")
+	sm.On("GetSecretValue", &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String("secret"),
+	}).Return((*secretsmanager.GetSecretValueOutput)(nil), errAccessDenied)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.Equal(t, errAccessDenied, err)
+
+	c.AssertExpectations(t)
+	sm.AssertExpectations(t)
+}
+
+type mockSecretsManager struct {
+	mock.Mock
+}
+
+func (m *mockSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	args := m.MethodCalled("GetSecretValue", input)
+	return args.Get(0).(*secretsmanager.GetSecretValueOutput), args.Error(1)
+}
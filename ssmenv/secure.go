@@ -0,0 +1,7 @@
+package ssmenv
+
+// SecurePrefix marks an environment variable value as a reference to an SSM
+// parameter that's always decrypted, like a template returning "decrypt":
+// true, so that SecureString parameters can be mixed with String ones
+// without -with-decryption.
+const SecurePrefix = "ssm+secure://"
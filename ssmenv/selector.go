@@ -0,0 +1,57 @@
+package ssmenv
+
+// matchSelectors matches parameters AWS returned under a name that wasn't
+// requested to the requests they answer. The name of a returned parameter is
+// rebuilt from its name and selector, so when AWS normalizes a selector, e.g.
+// a label in another case, it doesn't match the name it was requested with.
+// A returned parameter is matched by its name without the selector, if it's
+// the only one left for a parameter requested only once. values and fetched
+// are updated in place.
+func matchSelectors(l *logger, names []string, invalid []*string, values map[string]string, fetched []string) {
+	requested := make(map[string]bool)
+	for _, name := range names {
+		requested[name] = true
+	}
+	for _, p := range invalid {
+		if p != nil {
+			requested[*p] = false
+		}
+	}
+
+	// The requests without a response, and the responses without a
+	// request, by the name of their parameter.
+	missing := make(map[string][]string)
+	for _, name := range names {
+		if _, ok := values[name]; !ok && requested[name] {
+			missing[baseName(name)] = append(missing[baseName(name)], name)
+		}
+	}
+	unrequested := make(map[string][]string)
+	for _, name := range fetched {
+		if !requested[name] {
+			unrequested[baseName(name)] = append(unrequested[baseName(name)], name)
+		}
+	}
+
+	for i, name := range fetched {
+		if requested[name] {
+			continue
+		}
+		base := baseName(name)
+		if len(missing[base]) != 1 || len(unrequested[base]) != 1 {
+			continue
+		}
+
+		want := missing[base][0]
+		l.warnf("using %s for %s, which AWS returned with a different selector", name, want)
+		values[want] = values[name]
+		delete(values, name)
+		fetched[i] = want
+	}
+
+	for base, names := range missing {
+		if n := len(unrequested[base]); n > 0 && (len(names) > 1 || n > 1) {
+			l.warnf("can't tell which of %v AWS returned %v for", names, unrequested[base])
+		}
+	}
+}
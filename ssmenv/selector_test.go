@@ -0,0 +1,103 @@
+package ssmenv
+
+import (
+	"sort"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron_NormalizedSelector(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret:Prod")
+	os.Setenv("OTHER_SECRET", "ssm://other:1")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("other:1"), aws.String("secret:Prod")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("other"), Selector: aws.String(":1"), Value: aws.String("other-value")},
+			{Name: aws.String("secret"), Selector: aws.String(":prod"), Value: aws.String("value")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"OTHER_SECRET=other-value",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=value",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestMatchSelectors(t *testing.T) {
+	tests := []struct {
+		names   []string
+		invalid []*string
+		values  map[string]string
+
+		outValues  map[string]string
+		outFetched []string
+	}{
+		// Matched by the name without the selector.
+		{
+			names:      []string{"secret:Prod"},
+			values:     map[string]string{"secret:prod": "value"},
+			outValues:  map[string]string{"secret:Prod": "value"},
+			outFetched: []string{"secret:Prod"},
+		},
+		// Exact matches are left alone.
+		{
+			names:      []string{"secret:prod", "other"},
+			values:     map[string]string{"secret:prod": "value", "other": "other-value"},
+			outValues:  map[string]string{"secret:prod": "value", "other": "other-value"},
+			outFetched: []string{"other", "secret:prod"},
+		},
+		// More than one request for the parameter is ambiguous.
+		{
+			names:      []string{"secret:Prod", "secret:Staging"},
+			values:     map[string]string{"secret:prod": "prod", "secret:staging": "staging"},
+			outValues:  map[string]string{"secret:prod": "prod", "secret:staging": "staging"},
+			outFetched: []string{"secret:prod", "secret:staging"},
+		},
+		// Invalid parameters aren't matched.
+		{
+			names:      []string{"secret:Prod"},
+			invalid:    []*string{aws.String("secret:Prod")},
+			values:     map[string]string{"secret:prod": "value"},
+			outValues:  map[string]string{"secret:prod": "value"},
+			outFetched: []string{"secret:prod"},
+		},
+	}
+
+	for _, tt := range tests {
+		var fetched []string
+		for name := range tt.values {
+			fetched = append(fetched, name)
+		}
+		sort.Strings(fetched)
+
+		matchSelectors(stderrLog, tt.names, tt.invalid, tt.values, fetched)
+		sort.Strings(fetched)
+		assert.Equal(t, tt.outValues, tt.values)
+		assert.Equal(t, tt.outFetched, fetched)
+	}
+}
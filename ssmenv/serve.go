@@ -0,0 +1,81 @@
+package ssmenv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultServeFor is how long the resolved environment is served for with
+// -serve, unless -serve-for is given.
+const defaultServeFor = time.Minute
+
+// envHandler serves the named variables of env as a JSON object, in response
+// to a GET request for /.
+func envHandler(env environ, names []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		vars := envMap(env.Environ())
+		resolved := make(map[string]string)
+		for _, name := range names {
+			if v, ok := vars[name]; ok {
+				resolved[name] = v
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(resolved)
+	})
+}
+
+// listenUnix listens on a unix socket at path, that only the current user
+// can connect to. A stale socket left at path is replaced, but anything else
+// is an error.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := listenUnixMasked(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serve serves h on l until ctx is done, then closes l, which removes the
+// socket.
+func serve(ctx context.Context, l net.Listener, h http.Handler) error {
+	srv := &http.Server{Handler: h}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	err := srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
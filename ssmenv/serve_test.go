@@ -0,0 +1,73 @@
+package ssmenv
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssm-env.sock")
+
+	env := newFakeEnviron()
+	env.Setenv("SUPER_SECRET", "hehe")
+
+	l, err := listenUnix(path)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, l, envHandler(env, []string{"SUPER_SECRET", "UNSET"}))
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", path)
+		},
+	}}
+
+	resp, err := client.Get("http://ssm-env/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var vars map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&vars))
+	resp.Body.Close()
+	assert.Equal(t, map[string]string{"SUPER_SECRET": "hehe"}, vars)
+
+	resp, err = client.Post("http://ssm-env/", "application/json", strings.NewReader("{}"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// Once the window is over, the socket is gone.
+	cancel()
+	assert.NoError(t, <-served)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestListenUnix_NotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(path, []byte("keep"), 0644))
+
+	_, err := listenUnix(path)
+	assert.Error(t, err)
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "keep", string(b))
+}
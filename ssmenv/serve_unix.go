@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package ssmenv
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnixMasked listens on a unix socket at path, with a umask that
+// keeps the socket from being created with permissions for anyone else.
+func listenUnixMasked(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
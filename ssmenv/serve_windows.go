@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package ssmenv
+
+import "net"
+
+// listenUnixMasked listens on a unix socket at path.
+func listenUnixMasked(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,326 @@
+package ssmenv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// awsConfig holds the settings used when creating the AWS session for the
+// lazily initialized clients.
+type awsConfig struct {
+	// userAgentSuffix is appended to the User-Agent of every AWS request,
+	// after the ssm-env product token.
+	userAgentSuffix string
+
+	// metrics, if set, is told about retried AWS requests.
+	metrics metrics
+
+	// disableEndpointDiscovery turns off endpoint discovery, so that no
+	// calls are made other than the ones resolving parameters.
+	disableEndpointDiscovery bool
+
+	// region, if set, is the region of the session, instead of the one
+	// configured in the environment or of the instance we're running on.
+	region string
+
+	// profile, if set, is the shared config profile of the session,
+	// instead of the one named by AWS_PROFILE.
+	profile string
+
+	// endpointURL, if set, is the endpoint every client sends requests to,
+	// e.g. http://localhost:4566 for LocalStack.
+	endpointURL string
+
+	// factory creates the session and clients. The AWS SDK is used when
+	// it's nil.
+	factory clientFactory
+}
+
+// clients returns the factory creating the session and clients.
+func (c awsConfig) clients() clientFactory {
+	if c.factory == nil {
+		return sdkClientFactory{}
+	}
+	return c.factory
+}
+
+// clientFactory creates the AWS session and clients, so that the way they're
+// initialized can be tested without AWS.
+type clientFactory interface {
+	newSession(opts session.Options) (*session.Session, error)
+
+	// instanceRegion returns the region of the EC2 instance we're running
+	// on.
+	instanceRegion(sess *session.Session) (string, error)
+
+	// taskRegion returns the region of the ECS task we're running in.
+	taskRegion() (string, error)
+
+	newSSM(sess *session.Session) SSMClient
+	newSecretsManager(sess *session.Session) SecretsManagerClient
+	newKMS(sess *session.Session) KMSClient
+}
+
+// sdkClientFactory creates AWS SDK sessions and clients.
+type sdkClientFactory struct{}
+
+func (sdkClientFactory) newSession(opts session.Options) (*session.Session, error) {
+	return session.NewSessionWithOptions(opts)
+}
+
+func (sdkClientFactory) instanceRegion(sess *session.Session) (string, error) {
+	identity, err := ec2metadata.New(sess).GetInstanceIdentityDocument()
+	return identity.Region, err
+}
+
+func (sdkClientFactory) taskRegion() (string, error) {
+	uri := os.Getenv(ecsMetadataEnv)
+	if uri == "" {
+		return "", errors.New("not running in an ECS task")
+	}
+	return ecsTaskRegion(&http.Client{Timeout: ecsMetadataTimeout}, uri)
+}
+
+func (sdkClientFactory) newSSM(sess *session.Session) SSMClient {
+	return ssm.New(sess)
+}
+
+func (sdkClientFactory) newSecretsManager(sess *session.Session) SecretsManagerClient {
+	return secretsmanager.New(sess)
+}
+
+func (sdkClientFactory) newKMS(sess *session.Session) KMSClient {
+	return kms.New(sess)
+}
+
+func awsSession(config awsConfig) (*session.Session, error) {
+	f := config.clients()
+	sess, err := f.newSession(sessionOptions(config, os.Getenv))
+	if err != nil {
+		return nil, err
+	}
+	addUserAgentHandlers(&sess.Handlers, config.userAgentSuffix)
+	if config.metrics != nil {
+		addRetryMetricsHandler(&sess.Handlers, config.metrics)
+	}
+	// Clients will throw errors if a region isn't configured, so if one hasn't
+	// been set already try to look up the region we're running in.
+	if len(aws.StringValue(sess.Config.Region)) == 0 {
+		region, err := lookupRegion(f, sess)
+		if err == nil {
+			sess.Config.Region = aws.String(region)
+		}
+		// Ignore any errors, the client will emit a missing region error
+		// in the context of any parameter get calls anyway.
+	}
+	return sess, nil
+}
+
+// sessionProvider creates the AWS session shared by the lazily initialized
+// clients, so that the region is looked up at most once, however many of
+// them are used.
+type sessionProvider struct {
+	config awsConfig
+
+	mu   sync.Mutex
+	sess *session.Session
+}
+
+// session returns the AWS session, creating it if it hasn't been already, or
+// if it's the stale session, to load fresh credentials. A session created
+// again keeps the region of the stale one, so it isn't looked up again.
+func (p *sessionProvider) session(stale *session.Session) (*session.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sess == nil || p.sess == stale {
+		config := p.config
+		if p.sess != nil && config.region == "" {
+			config.region = aws.StringValue(p.sess.Config.Region)
+		}
+		sess, err := awsSession(config)
+		if err != nil {
+			return nil, err
+		}
+		p.sess = sess
+	}
+	return p.sess, nil
+}
+
+// lookupRegion returns the region we're running in. In an ECS task, it's
+// looked up with the task metadata endpoint, since there's no EC2 Instance
+// Metadata Endpoint on Fargate, and otherwise, or if that fails, with the
+// EC2 Instance Metadata Endpoint.
+func lookupRegion(f clientFactory, sess *session.Session) (string, error) {
+	if region, err := f.taskRegion(); err == nil {
+		return region, nil
+	}
+	return f.instanceRegion(sess)
+}
+
+// ecsMetadataEnv is the environment variable the ECS agent sets to the
+// version 4 task metadata endpoint of the container.
+const ecsMetadataEnv = "ECS_CONTAINER_METADATA_URI_V4"
+
+// ecsMetadataTimeout is how long to wait for the task metadata endpoint.
+const ecsMetadataTimeout = 2 * time.Second
+
+// ecsTaskRegion returns the region of the ECS task whose container has the
+// metadata endpoint uri, from the ARN of the task.
+func ecsTaskRegion(client *http.Client, uri string) (string, error) {
+	resp, err := client.Get(strings.TrimSuffix(uri, "/") + "/task")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("task metadata endpoint returned %s", resp.Status)
+	}
+
+	var task struct {
+		TaskARN string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return "", fmt.Errorf("decoding task metadata: %v", err)
+	}
+
+	// arn:partition:ecs:region:account:task/...
+	parts := strings.SplitN(task.TaskARN, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[3] == "" {
+		return "", fmt.Errorf("no region in task ARN %q", task.TaskARN)
+	}
+	return parts[3], nil
+}
+
+// isExpiredToken reports whether err is AWS rejecting a request because the
+// credentials it was signed with have expired.
+func isExpiredToken(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "ExpiredToken", "ExpiredTokenException":
+		return true
+	}
+	return false
+}
+
+// sessionOptions returns the options the session is created with.
+//
+// The profile set with -profile takes precedence over AWS_PROFILE, and the
+// shared config file is loaded for it, so that profiles assuming a role or
+// using SSO work. It also takes precedence over the credentials in the
+// environment, which are otherwise used as is by sdkConfig.
+func sessionOptions(config awsConfig, getenv func(string) string) session.Options {
+	opts := session.Options{
+		Config:  *sdkConfig(config, getenv),
+		Profile: config.profile,
+	}
+	if config.profile != "" {
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	return opts
+}
+
+// sdkConfig returns the AWS SDK configuration for the session.
+func sdkConfig(config awsConfig, getenv func(string) string) *aws.Config {
+	cfg := &aws.Config{
+		CredentialsChainVerboseErrors: aws.Bool(true),
+	}
+	if config.profile == "" {
+		if creds := envCredentials(getenv); creds != nil {
+			cfg.Credentials = creds
+		}
+	}
+	if config.disableEndpointDiscovery {
+		cfg.EnableEndpointDiscovery = aws.Bool(false)
+	}
+	if config.region != "" {
+		cfg.Region = aws.String(config.region)
+	}
+	if config.endpointURL != "" {
+		cfg.Endpoint = aws.String(config.endpointURL)
+	}
+	return cfg
+}
+
+// checkEndpointURL returns an error if rawurl isn't an absolute http or
+// https URL, which the SDK would only fail on once a request is made.
+func checkEndpointURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q isn't an http or https URL", rawurl)
+	}
+	return nil
+}
+
+// envCredentials returns static credentials built from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables, or nil if the key pair isn't set.
+//
+// The SDK's default credential chain reads these variables too, but a shared
+// config profile selected on the session takes precedence over them. CI
+// systems that hand out temporary credentials through the environment expect
+// exactly these to be used, session token included.
+func envCredentials(getenv func(string) string) *credentials.Credentials {
+	id, secret := getenv("AWS_ACCESS_KEY_ID"), getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return nil
+	}
+	return credentials.NewStaticCredentials(id, secret, getenv("AWS_SESSION_TOKEN"))
+}
+
+// addUserAgentHandlers registers handlers that add ssm-env (and its version)
+// to the User-Agent of AWS requests, so that ssm-env's calls can be told
+// apart from other tools in CloudTrail. A non-empty suffix is appended
+// after it.
+func addUserAgentHandlers(handlers *request.Handlers, suffix string) {
+	v := Version
+	if v == "" {
+		v = "unknown"
+	}
+	handlers.Build.PushBackNamed(request.NamedHandler{
+		Name: "ssmenv.UserAgentHandler",
+		Fn:   request.MakeAddToUserAgentHandler("ssm-env", v),
+	})
+	if suffix != "" {
+		handlers.Build.PushBackNamed(request.NamedHandler{
+			Name: "ssmenv.UserAgentSuffixHandler",
+			Fn:   request.MakeAddToUserAgentFreeFormHandler(suffix),
+		})
+	}
+}
+
+// addRetryMetricsHandler counts retried requests. AfterRetry handlers only run
+// for failed requests, and the SDK clears the error of the ones it's going to
+// retry.
+func addRetryMetricsHandler(handlers *request.Handlers, m metrics) {
+	handlers.AfterRetry.PushBackNamed(request.NamedHandler{
+		Name: "ssmenv.RetryMetricsHandler",
+		Fn: func(r *request.Request) {
+			if r.Error == nil {
+				m.Count(metricRetries, 1)
+			}
+		},
+	})
+}
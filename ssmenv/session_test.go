@@ -0,0 +1,384 @@
+package ssmenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddUserAgentHandlers(t *testing.T) {
+	defer func(v string) { Version = v }(Version)
+	Version = "v1.2.3"
+
+	tests := []struct {
+		suffix    string
+		userAgent string
+	}{
+		{"", "aws-sdk-go ssm-env/v1.2.3"},
+		{"team/payments", "aws-sdk-go ssm-env/v1.2.3 team/payments"},
+	}
+
+	for _, tt := range tests {
+		var handlers request.Handlers
+		addUserAgentHandlers(&handlers, tt.suffix)
+
+		r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+		r.HTTPRequest.Header.Set("User-Agent", "aws-sdk-go")
+		handlers.Build.Run(r)
+
+		assert.Equal(t, tt.userAgent, r.HTTPRequest.Header.Get("User-Agent"))
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID":     "ASIAEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_SESSION_TOKEN":     "token",
+	}
+	creds := envCredentials(func(k string) string { return env[k] })
+
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: creds,
+		Region:      aws.String("us-east-1"),
+	})
+	assert.NoError(t, err)
+
+	v, err := sess.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "ASIAEXAMPLE", v.AccessKeyID)
+	assert.Equal(t, "secret", v.SecretAccessKey)
+	assert.Equal(t, "token", v.SessionToken)
+}
+
+func TestEnvCredentials_Missing(t *testing.T) {
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID": "ASIAEXAMPLE",
+		"AWS_SESSION_TOKEN": "token",
+	}
+	assert.Nil(t, envCredentials(func(k string) string { return env[k] }))
+}
+
+func TestSDKConfig_EndpointDiscovery(t *testing.T) {
+	getenv := func(string) string { return "" }
+
+	cfg := sdkConfig(awsConfig{}, getenv)
+	assert.Nil(t, cfg.EnableEndpointDiscovery)
+
+	cfg = sdkConfig(awsConfig{disableEndpointDiscovery: true}, getenv)
+	assert.Equal(t, aws.Bool(false), cfg.EnableEndpointDiscovery)
+}
+
+func TestSDKConfig_Region(t *testing.T) {
+	getenv := func(string) string { return "" }
+
+	cfg := sdkConfig(awsConfig{}, getenv)
+	assert.Nil(t, cfg.Region)
+
+	cfg = sdkConfig(awsConfig{region: "us-west-2"}, getenv)
+	assert.Equal(t, "us-west-2", aws.StringValue(cfg.Region))
+}
+
+func TestSessionOptions_Profile(t *testing.T) {
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "SECRET",
+	}
+	getenv := func(k string) string { return env[k] }
+
+	// Without -profile, AWS_PROFILE is left to the SDK, and the credentials
+	// in the environment are used.
+	opts := sessionOptions(awsConfig{}, getenv)
+	assert.Equal(t, "", opts.Profile)
+	assert.Equal(t, session.SharedConfigStateFromEnv, opts.SharedConfigState)
+	assert.NotNil(t, opts.Config.Credentials)
+	assert.Equal(t, aws.Bool(true), opts.Config.CredentialsChainVerboseErrors)
+
+	// -profile wins over both.
+	opts = sessionOptions(awsConfig{profile: "dev"}, getenv)
+	assert.Equal(t, "dev", opts.Profile)
+	assert.Equal(t, session.SharedConfigEnable, opts.SharedConfigState)
+	assert.Nil(t, opts.Config.Credentials)
+	assert.Equal(t, aws.Bool(true), opts.Config.CredentialsChainVerboseErrors)
+}
+
+func TestAWSSession_Profile(t *testing.T) {
+	f := &fakeClientFactory{}
+
+	_, err := awsSession(awsConfig{profile: "dev", factory: f})
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", f.profile)
+}
+
+func TestSDKConfig_EndpointURL(t *testing.T) {
+	getenv := func(string) string { return "" }
+
+	cfg := sdkConfig(awsConfig{}, getenv)
+	assert.Nil(t, cfg.Endpoint)
+
+	cfg = sdkConfig(awsConfig{endpointURL: "http://localhost:4566"}, getenv)
+	assert.Equal(t, "http://localhost:4566", aws.StringValue(cfg.Endpoint))
+}
+
+func TestCheckEndpointURL(t *testing.T) {
+	assert.NoError(t, checkEndpointURL("http://localhost:4566"))
+	assert.NoError(t, checkEndpointURL("https://ssm.internal.example.com"))
+	assert.EqualError(t, checkEndpointURL("localhost:4566"), `"localhost:4566" isn't an http or https URL`)
+	assert.EqualError(t, checkEndpointURL("http://"), `"http://" isn't an http or https URL`)
+}
+
+func TestAWSSession_InstanceRegion(t *testing.T) {
+	f := &fakeClientFactory{region: "eu-west-1"}
+
+	sess, err := awsSession(awsConfig{factory: f})
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", aws.StringValue(sess.Config.Region))
+	assert.Equal(t, 1, f.instanceRegionCalls)
+
+	// A configured region is used as is.
+	f = &fakeClientFactory{configuredRegion: "us-east-1", region: "eu-west-1"}
+
+	sess, err = awsSession(awsConfig{factory: f})
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", aws.StringValue(sess.Config.Region))
+	assert.Equal(t, 0, f.instanceRegionCalls)
+}
+
+func TestAWSSession_TaskRegion(t *testing.T) {
+	f := &fakeClientFactory{ecsRegion: "eu-central-1", region: "eu-west-1"}
+
+	// The task metadata endpoint is used before the instance metadata
+	// endpoint, which doesn't exist on Fargate.
+	sess, err := awsSession(awsConfig{factory: f})
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-central-1", aws.StringValue(sess.Config.Region))
+	assert.Equal(t, 0, f.instanceRegionCalls)
+}
+
+func TestECSTaskRegion(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v4/task":
+			fmt.Fprint(w, `{"Cluster": "default", "TaskARN": "arn:aws:ecs:us-west-2:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c"}`)
+		case "/v4-bad/task":
+			fmt.Fprint(w, `{"TaskARN": "158d1c8083dd49d6b527399fd6414f5c"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	region, err := ecsTaskRegion(s.Client(), s.URL+"/v4")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-west-2", region)
+
+	_, err = ecsTaskRegion(s.Client(), s.URL+"/v4-bad")
+	assert.EqualError(t, err, `no region in task ARN "158d1c8083dd49d6b527399fd6414f5c"`)
+
+	_, err = ecsTaskRegion(s.Client(), s.URL+"/missing")
+	assert.EqualError(t, err, "task metadata endpoint returned 404 Not Found")
+}
+
+func TestAWSSession_RegionFlag(t *testing.T) {
+	f := &fakeClientFactory{configuredRegion: "us-east-1", region: "eu-west-1"}
+
+	// -region takes precedence over AWS_REGION, and metadata is never
+	// queried.
+	sess, err := awsSession(awsConfig{region: "ap-southeast-2", factory: f})
+	assert.NoError(t, err)
+	assert.Equal(t, "ap-southeast-2", aws.StringValue(sess.Config.Region))
+	assert.Equal(t, 0, f.instanceRegionCalls)
+}
+
+func TestAWSSession_RetryMetrics(t *testing.T) {
+	f := &fakeClientFactory{}
+	m := newFakeMetrics()
+
+	sess, err := awsSession(awsConfig{factory: f, metrics: m})
+	assert.NoError(t, err)
+
+	sess.Handlers.AfterRetry.Run(&request.Request{})
+	assert.Equal(t, map[string]int64{metricRetries: 1}, m.counts)
+}
+
+func TestLazySSMClient_Factory(t *testing.T) {
+	c := new(mockSSM)
+	f := &fakeClientFactory{region: "eu-west-1", ssm: c}
+	l := &lazySSMClient{sessions: &sessionProvider{config: awsConfig{factory: f}}}
+
+	input := &ssm.GetParametersInput{Names: []*string{aws.String("secret")}}
+	c.On("GetParameters", input).Return(&ssm.GetParametersOutput{}, nil).Twice()
+
+	for i := 0; i < 2; i++ {
+		_, err := l.GetParametersWithContext(context.Background(), input)
+		assert.NoError(t, err)
+	}
+
+	// The session is only created once.
+	assert.Equal(t, 1, f.sessions)
+	c.AssertExpectations(t)
+}
+
+func TestLazySSMClient_ExpiredToken(t *testing.T) {
+	c := new(mockSSM)
+	f := &fakeClientFactory{region: "eu-west-1", ssm: c}
+	l := &lazySSMClient{sessions: &sessionProvider{config: awsConfig{factory: f}}}
+
+	input := &ssm.GetParametersInput{Names: []*string{aws.String("secret")}}
+	c.On("GetParameters", input).Return((*ssm.GetParametersOutput)(nil), awserr.New("ExpiredToken", "The security token included in the request is expired", nil)).Once()
+	c.On("GetParameters", input).Return(&ssm.GetParametersOutput{}, nil).Once()
+
+	_, err := l.GetParametersWithContext(context.Background(), input)
+	assert.NoError(t, err)
+
+	// The session is created again, to refresh the credentials, before
+	// the single retry.
+	assert.Equal(t, 2, f.sessions)
+	c.AssertExpectations(t)
+}
+
+func TestLazySSMClient_ExpiredTokenRetriedOnce(t *testing.T) {
+	c := new(mockSSM)
+	f := &fakeClientFactory{region: "eu-west-1", ssm: c}
+	l := &lazySSMClient{sessions: &sessionProvider{config: awsConfig{factory: f}}}
+
+	input := &ssm.GetParametersInput{Names: []*string{aws.String("secret")}}
+	c.On("GetParameters", input).Return((*ssm.GetParametersOutput)(nil), awserr.New("ExpiredTokenException", "expired", nil)).Twice()
+
+	_, err := l.GetParametersWithContext(context.Background(), input)
+	assert.EqualError(t, err, "ExpiredTokenException: expired")
+	assert.Equal(t, 2, f.sessions)
+
+	// Other errors aren't retried.
+	c.On("GetParameters", input).Return((*ssm.GetParametersOutput)(nil), awserr.New("AccessDeniedException", "denied", nil)).Once()
+	_, err = l.GetParametersWithContext(context.Background(), input)
+	assert.EqualError(t, err, "AccessDeniedException: denied")
+	assert.Equal(t, 2, f.sessions)
+
+	c.AssertExpectations(t)
+}
+
+func TestLazyKMSClient_ExpiredToken(t *testing.T) {
+	k := new(mockKMS)
+	f := &fakeClientFactory{region: "eu-west-1", kms: k}
+	l := &lazyKMSClient{sessions: &sessionProvider{config: awsConfig{factory: f}}}
+
+	input := &kms.DecryptInput{CiphertextBlob: []byte("ciphertext")}
+	k.On("Decrypt", input).Return((*kms.DecryptOutput)(nil), awserr.New("ExpiredTokenException", "expired", nil)).Once()
+	k.On("Decrypt", input).Return(&kms.DecryptOutput{Plaintext: []byte("hehe")}, nil).Once()
+
+	out, err := l.DecryptWithContext(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, "hehe", string(out.Plaintext))
+	assert.Equal(t, 2, f.sessions)
+
+	k.AssertExpectations(t)
+}
+
+func TestSessionProvider_Shared(t *testing.T) {
+	c := new(mockSSM)
+	k := new(mockKMS)
+	f := &fakeClientFactory{region: "eu-west-1", ssm: c, kms: k}
+	sessions := &sessionProvider{config: awsConfig{factory: f}}
+	s := &lazySSMClient{sessions: sessions}
+	l := &lazyKMSClient{sessions: sessions}
+
+	getInput := &ssm.GetParametersInput{Names: []*string{aws.String("secret")}}
+	c.On("GetParameters", getInput).Return(&ssm.GetParametersOutput{}, nil).Once()
+	decryptInput := &kms.DecryptInput{CiphertextBlob: []byte("ciphertext")}
+	k.On("Decrypt", decryptInput).Return((*kms.DecryptOutput)(nil), awserr.New("ExpiredTokenException", "expired", nil)).Once()
+	k.On("Decrypt", decryptInput).Return(&kms.DecryptOutput{Plaintext: []byte("hehe")}, nil).Once()
+
+	_, err := s.GetParametersWithContext(context.Background(), getInput)
+	assert.NoError(t, err)
+	_, err = l.DecryptWithContext(context.Background(), decryptInput)
+	assert.NoError(t, err)
+
+	// SSM and KMS share a session, and the one created again for fresh
+	// credentials keeps its region, so the metadata endpoint is queried
+	// once.
+	assert.Equal(t, 2, f.sessions)
+	assert.Equal(t, 1, f.instanceRegionCalls)
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+// fakeClientFactory creates sessions without loading any AWS configuration,
+// and hands out the clients it's given.
+type fakeClientFactory struct {
+	// configuredRegion, if set, is the region sessions are configured
+	// with, like it would be by AWS_REGION, unless the configuration has
+	// one.
+	configuredRegion string
+
+	// region is the region of the "instance" we're running on.
+	region string
+
+	// ecsRegion, if set, is the region of the "task" we're running in.
+	ecsRegion string
+
+	ssm SSMClient
+	sm  SecretsManagerClient
+	kms KMSClient
+
+	// profile is the profile of the last session created.
+	profile string
+
+	sessions            int
+	instanceRegionCalls int
+}
+
+func (f *fakeClientFactory) newSession(opts session.Options) (*session.Session, error) {
+	f.sessions++
+	f.profile = opts.Profile
+	cfg := opts.Config
+	sess := &session.Session{Config: &cfg}
+	if f.configuredRegion != "" && cfg.Region == nil {
+		sess.Config.Region = aws.String(f.configuredRegion)
+	}
+	return sess, nil
+}
+
+func (f *fakeClientFactory) instanceRegion(sess *session.Session) (string, error) {
+	f.instanceRegionCalls++
+	return f.region, nil
+}
+
+func (f *fakeClientFactory) taskRegion() (string, error) {
+	if f.ecsRegion == "" {
+		return "", errors.New("not running in an ECS task")
+	}
+	return f.ecsRegion, nil
+}
+
+func (f *fakeClientFactory) newSSM(sess *session.Session) SSMClient {
+	return f.ssm
+}
+
+func (f *fakeClientFactory) newSecretsManager(sess *session.Session) SecretsManagerClient {
+	return f.sm
+}
+
+func (f *fakeClientFactory) newKMS(sess *session.Session) KMSClient {
+	return f.kms
+}
+
+func TestRun_InvalidEndpointURL(t *testing.T) {
+	c := new(mockSSM)
+	code, stdout, stderr := runWith(c, newFakeEnviron(), "-endpoint-url", "localhost:4566", "-print-all")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "", stdout)
+	assert.Equal(t, "ssm-env: invalid -endpoint-url: \"localhost:4566\" isn't an http or https URL\n", stderr)
+	c.AssertExpectations(t)
+}
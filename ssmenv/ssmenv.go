@@ -0,0 +1,2025 @@
+// Package ssmenv resolves the references to SSM parameters, Secrets Manager
+// secrets and KMS ciphertext in environment variables. It implements the
+// ssm-env command, and can be embedded in other programs with Expander.
+package ssmenv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	// DefaultTemplate is the default template used to determine what the SSM
+	// parameter name is for an environment variable. The ssm:// scheme is
+	// matched case-insensitively, the parameter name is not.
+	DefaultTemplate = `{{ if hasPrefixFold .Value "ssm://" }}{{ trimPrefixFold .Value "ssm://" }}{{ end }}`
+
+	// defaultBatchSize is the default number of parameters to fetch at once.
+	// The SSM API limits this to a maximum of 10 at the time of writing.
+	defaultBatchSize = 10
+
+	// defaultSSMConcurrency and defaultKMSConcurrency are the default
+	// numbers of concurrent SSM and KMS requests.
+	defaultSSMConcurrency = 4
+	defaultKMSConcurrency = 1
+)
+
+// TemplateFuncs are helper functions provided to the template.
+var TemplateFuncs = template.FuncMap{
+	"contains":   strings.Contains,
+	"hasPrefix":  strings.HasPrefix,
+	"hasSuffix":  strings.HasSuffix,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"trimSpace":  strings.TrimSpace,
+	"trimLeft":   strings.TrimLeft,
+	"trimRight":  strings.TrimRight,
+	"trim":       strings.Trim,
+	"title":      strings.Title,
+	"toTitle":    strings.ToTitle,
+	"toLower":    strings.ToLower,
+	"toUpper":    strings.ToUpper,
+
+	// Case-insensitive variants of hasPrefix and trimPrefix.
+	"hasPrefixFold":  hasPrefixFold,
+	"trimPrefixFold": trimPrefixFold,
+}
+
+// Version is the version of ssm-env printed by -V, and added to the
+// User-Agent of AWS requests. The ssm-env command sets it to its own.
+var Version string
+
+// Run runs ssm-env with the command line arguments args, without the program
+// name, in the environment of the process, and returns the exit code. Unless
+// it's run as a child process, the command replaces the process, and Run only
+// returns if that fails.
+func Run(args []string, stdout, stderr io.Writer) int {
+	return run(args, osEnviron(0), stdout, stderr)
+}
+
+// clients creates the AWS session and clients used by run.
+var clients clientFactory = sdkClientFactory{}
+
+// execve replaces the process with a command, and is replaced in tests.
+var execve = syscall.Exec
+
+// run runs ssm-env with the command line arguments args, without the program
+// name, in the environment env, and returns the exit code. Unless it's run as
+// a child process, the command replaces the process, and run only returns if
+// that fails.
+func run(args []string, env environ, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ssm-env", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		template      = fs.String("template", DefaultTemplate, "The template used to determine what the SSM parameter name is for an environment variable. When this template returns an empty string, the env variable is not an SSM parameter")
+		decrypt       = fs.Bool("with-decryption", false, "Will attempt to decrypt the parameter, and set the env var as plaintext")
+		nofail        = fs.Bool("no-fail", false, "Don't fail if error retrieving parameter")
+		failMissing   = fs.Bool("fail-on-missing", false, "Whether a parameter that doesn't exist is an error, whatever -no-fail is set to. With -fail-on-missing=false, missing parameters are left unresolved, and other errors still fail without -no-fail. Defaults to following -no-fail")
+		resolveOnly   = fs.String("resolve-only-vars", "", "Comma separated list of environment variables to resolve and print as KEY=VALUE, instead of executing a command. Other variables are not resolved")
+		namePrefix    = fs.String("name-prefix", "", "Only resolve the environment variables whose name starts with this prefix, like APP_. Other variables are left untouched")
+		format        = fs.String("format", "", "Print the resolved environment variables (or the ones given to -resolve-only-vars) to stdout in this format, instead of executing a command. One of env, dotenv, shell, json, ndjson or docker-env, or exec to execute the command, the default")
+		printResolved = fs.Bool("print", false, "Print the resolved environment variables to stdout as KEY=VALUE lines, instead of executing a command. Values spanning multiple lines are double quoted, with escapes")
+		printAll      = fs.Bool("print-all", false, "Like -print, but print the whole environment, not only the variables that were resolved")
+		invalidNames  = fs.String("invalid-names", "allow", "What to do with environment variables holding a reference, or set from a path with -path-name-template, whose name isn't a valid POSIX name: allow sets them anyway, skip leaves them alone, sanitize replaces invalid characters with underscores and error fails")
+		unresolved    = fs.String("print-unresolved", "keep", "What -print, -print-all, -format and -resolve-only-vars print for variables whose reference couldn't be resolved with -no-fail: keep prints the reference, omit leaves the variable out, placeholder prints <unresolved> and fail fails without printing anything")
+		useKeychain   = fs.Bool("keychain", false, "Resolve SSM parameters, and Secrets Manager fallbacks, from the keychain of the OS instead of AWS, for local development. Parameters are looked up under the ssm-env service, by name")
+		uaSuffix      = fs.String("user-agent-suffix", "", "Additional text appended to the User-Agent of AWS requests")
+		noDiscovery   = fs.Bool("disable-endpoint-discovery", false, "Disable endpoint discovery in the AWS SDK, so that no other calls are made than the ones resolving parameters")
+		debugTmpl     = fs.Bool("debug-template", false, "Print the template output for every environment variable to stderr, and exit without resolving anything")
+		denyAdvanced  = fs.Bool("deny-advanced-tier", false, "Fail if a referenced parameter is in the Advanced tier. Tiers are looked up with an extra DescribeParameters call per batch")
+		normalize     = fs.Bool("normalize-paths", false, "Normalize the slashes in parameter names, collapsing repeated slashes and making sure names start with a single slash")
+		preTransform  = fs.String("pre-transform", "", "Comma separated list of transforms applied to the value of every environment variable before it's matched against the template, e.g. trimSpace,lowerScheme")
+		filter        = fs.String("filter", "", "A template run for every resolved environment variable, with its .Name and resolved .Value. When it returns an empty string, or a false value like \"false\" or \"0\", the variable is unset")
+		failConflict  = fs.Bool("fail-on-conflict", false, "Fail when an environment variable is targeted by more than one reference, such as a ssm-json:// parameter and a variable of its own, instead of warning")
+		retryValue    = fs.String("retry-value", "", "A regular expression matching placeholder values, such as ^PENDING$. Parameters with a matching value are fetched again, with a backoff, expecting them to be updated")
+		retryAttempts = fs.Int("retry-value-attempts", 5, "Maximum number of times a parameter is fetched, when its value matches -retry-value")
+		failOnEmpty   = fs.Bool("fail-on-empty", false, "Fail if a parameter exists but has an empty value, instead of setting the variable to an empty string")
+		skipEmpty     = fs.Bool("skip-empty", false, "Leave the variable of a parameter with an empty value as it is, or set it to the default value of the reference if it has one, instead of setting it to an empty string")
+		namePattern   = fs.String("name-pattern", "", "A regular expression the name of every referenced parameter must match, such as ^/myapp/[a-z0-9/_-]+$, checked before any call is made")
+		allowedAccts  = fs.String("allowed-accounts", "", "Comma separated list of account IDs that parameters referenced by ARN can be read from. References to other accounts are rejected before any call is made")
+		failDuplicate = fs.Bool("fail-on-duplicate", false, "Fail if AWS returns the same parameter more than once in a response, instead of using the last one")
+		sentinel      = fs.String("missing-sentinel", "", "With -no-fail, set variables whose parameter doesn't exist to this value, instead of leaving the reference in place")
+		ssmConc       = fs.Int("ssm-concurrency", defaultSSMConcurrency, "Maximum number of concurrent SSM requests")
+		kmsConc       = fs.Int("kms-concurrency", defaultKMSConcurrency, "Maximum number of concurrent KMS Decrypt requests")
+		concurrency   = fs.Int("concurrency", 0, "Maximum number of concurrent SSM requests and of concurrent KMS Decrypt requests, setting both -ssm-concurrency and -kms-concurrency, unless they're given too")
+		maxDecrypts   = fs.Int("max-kms-decrypts", 0, "Maximum number of KMS Decrypt calls to make, one per distinct KMS value. More is an error, or with -no-fail, the values over the limit are left undecrypted. 0 means no limit")
+		plainSuffix   = fs.String("prefer-plaintext-suffix", "", "For development, set variables holding a reference to the value of the variable of the same name with this suffix, e.g. _PLAINTEXT, if it's set, instead of resolving the reference")
+		kmsFirst      = fs.Bool("kms-first", false, "Decrypt KMS values before resolving SSM parameters, instead of after, so that KMS ciphertext can hold an SSM reference")
+		interpolate   = fs.Bool("interpolate", false, "Also resolve the ssm:// references embedded in values that aren't a reference themselves, e.g. postgres://ssm:///db/user:ssm:///db/pass@host/db, replacing every one with the value of its parameter")
+		vaultRefs     = fs.Bool("vault", false, "Also resolve vault://PATH#KEY references to a key of a secret in Vault, read from the address in VAULT_ADDR with the token in VAULT_TOKEN. Without it, vault:// values are left alone")
+		pathKeyCase   = fs.String("path-key-case", "upper", "Case of the variables set from ssm-json:// parameters and paths: upper, lower or asis")
+		recursive     = fs.Bool("recursive", true, "Resolve every parameter nested under a path referenced with ssm-path:// or a trailing /*. With -recursive=false, only the parameters directly under it are resolved")
+		pathNameTmpl  = fs.String("path-name-template", "", "A template run for every parameter under a path, with its .Name relative to the path and its full .Parameter name, returning the name of the variable it's set as, instead of using -path-key-case. An empty name skips the parameter")
+		stripPrefix   = fs.String("strip-prefix", "", "Name the variables set from parameters under a path relative to this prefix, e.g. /myapp/prod/, instead of the referenced path, when the parameters are under it")
+		flattenJSON   = fs.Bool("flatten-json", false, "Flatten nested objects in ssm-json:// parameters, joining keys with an underscore, instead of failing")
+		checksums     = fs.Bool("verify-checksums", false, "Compare resolved values with the hex encoded SHA-256 checksum in the sibling parameter named after them with a .sha256 suffix, if there is one, and fail when they don't match")
+		kmsContext    = fs.String("kms-encryption-context", "", "Comma separated list of key=value pairs of the encryption context KMS values were encrypted with. Decrypting fails if it doesn't match")
+		kmsPrefix     = fs.String("kms-prefix", KMSPrefix, "Prefix of environment variable values holding base64 encoded KMS ciphertext, e.g. kms://. The !kms:hex prefix is only recognized with the default prefix. An empty prefix disables KMS decryption")
+		zeroPlain     = fs.Bool("zero-plaintext", false, "Zero the buffers KMS and Secrets Manager return decrypted plaintext in once it's been copied. Best effort: copies held as Go strings, including every resolved value, can't be zeroed")
+		strictBase64  = fs.Bool("strict-base64", false, "Require KMS ciphertext to be exact, well-formed base64, instead of adding back missing padding")
+		logFmt        = fs.String("log-format", "text", "Format of the warnings, errors and -verbose messages written to stderr: text, or json for a JSON object per line with level, msg, and when relevant param and error fields")
+		quiet         = fs.Bool("quiet", false, "Don't write warnings to stderr, like the ones about the parameters left unresolved with -no-fail. Errors are still written")
+		verbose       = fs.Bool("verbose", false, "Log every environment variable considered, the parameters they reference, the batches they're fetched in and the time every AWS call takes to stderr. Values are never logged")
+		cacheFile     = fs.String("cache-file", "", "File to cache the values of parameters in for -cache-ttl, so that they aren't fetched again by the next runs. Needs -cache-key-file or -cache-plaintext")
+		cacheTTL      = fs.Duration("cache-ttl", time.Hour, "How long the values of parameters are used from -cache-file before being fetched again")
+		cacheKeyFile  = fs.String("cache-key-file", "", "File holding the hex encoded 32 byte key -cache-file is encrypted with, using AES-256-GCM")
+		cachePlain    = fs.Bool("cache-plaintext", false, "Allow -cache-file to hold the values of parameters, decrypted SecureString parameters included, in plaintext")
+		reportIAM     = fs.Bool("report-iam", false, "Print a minimal IAM policy document allowing the AWS calls made to resolve the environment, on the resources they were made on, to stderr once resolution is done")
+		jitter        = fs.Duration("startup-jitter", 0, "Wait for a random duration of up to this long, e.g. 5s, before the first AWS call, to spread the calls of many containers starting at once")
+		timeout       = fs.Duration("timeout", 0, "Maximum time to spend resolving, e.g. 30s. Requests in flight are cancelled when it passes, and with -no-fail whatever was resolved so far is kept. 0 means no limit")
+		statsdAddr    = fs.String("statsd-addr", "", "Address (host:port) of a statsd server to send resolution metrics to")
+		printStats    = fs.Bool("stats", false, "Print the number of variables resolved, the number of AWS calls made and the time resolution took to stderr once it's done, whether it succeeded or not")
+		promTextfile  = fs.String("prometheus-textfile", "", "File to write resolution metrics to, in the Prometheus text format read by node_exporter's textfile collector. Written atomically once resolution is done, whether it succeeded or not")
+		supervise     = fs.Bool("supervise", false, "Run COMMAND as a child process instead of replacing ssm-env with it, forwarding signals to it and exiting with its exit code, e.g. to run as PID 1 in a container")
+		launchEvt     = fs.Bool("launch-event", false, "Log the command and the time resolution took to stderr right before the command is started, for tracing startup")
+		serveSocket   = fs.String("serve", "", "Unix socket to serve the resolved environment variables on, as a JSON object, for -serve-for. Only the user ssm-env starts as can connect. COMMAND runs as a child process instead of replacing ssm-env, and is optional")
+		serveFor      = fs.Duration("serve-for", defaultServeFor, "How long to serve the resolved environment variables for with -serve. Serving stops early when COMMAND exits")
+		comparePrefix = fs.String("compare-prefix", "", "Resolve the environment a second time with the parameter name prefix FROM replaced by TO, given as FROM=TO, print the variables whose values differ, as hashes, and exit with 1 if any do. COMMAND is optional")
+		compareRegion = fs.String("compare-region", "", "Like -compare-prefix, but resolve the environment a second time in this region. Can be combined with -compare-prefix")
+		region        = fs.String("region", "", "AWS region to resolve parameters in. Takes precedence over AWS_REGION and the shared config, and the region of the instance isn't looked up")
+		profile       = fs.String("profile", "", "Shared config profile to use, like AWS_PROFILE, which it takes precedence over. The credentials in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are ignored when it's set")
+		endpointURL   = fs.String("endpoint-url", "", "URL of the endpoint to send SSM, KMS and Secrets Manager requests to, e.g. http://localhost:4566 for LocalStack, instead of the AWS endpoint of the region")
+		redactNames   = fs.String("redact-names", "", "Comma separated list of glob patterns of environment variable names to mask in the output of -compare-prefix and -compare-region, e.g. CUSTOMER_*. The variables are still resolved")
+		envFile       = fs.String("env-file", "", "File of KEY=VALUE lines, in the format of docker's --env-file, to add to the environment before resolving it. Variables already set in the environment take precedence, unless -env-file-override is set")
+		envFileWins   = fs.Bool("env-file-override", false, "Let the variables in -env-file take precedence over the ones already set in the environment")
+		fallbackCmd   = fs.String("fallback-command", "", "Command to execute instead of COMMAND when no reference was resolved, e.g. because none are set in this environment, with its arguments separated by spaces")
+		runAsUser     = fs.String("user", "", "User name or id to run COMMAND as. Parameters are resolved before switching user")
+		runAsGroup    = fs.String("group", "", "Group name or id to run COMMAND as. Defaults to the primary group of -user")
+		envdir        = fs.String("envdir", "", "Directory to write each resolved variable into, in the layout read by daemontools' envdir. When set, COMMAND is optional")
+		envExample    = fs.String("env-example", "", "File to write a KEY= line to for every environment variable holding a reference, without values, like a .env.example. Nothing is resolved, and COMMAND is optional")
+		dryRun        = fs.Bool("dry-run", false, "Check that every reference is well-formed, with valid parameter names, without calling AWS or executing anything, and exit with 1 if any isn't. COMMAND is optional")
+		estimateCalls = fs.Bool("estimate", false, "Print the number of AWS API calls, and of Vault reads with -vault, resolving the environment would make, without making any, and exit. COMMAND is optional")
+		credsDir      = fs.String("credentials-dir", "", "Directory to write each resolved variable into, as a read-only file named after the variable. Use with systemd's LoadCredential. When set, COMMAND is optional")
+		print_version = fs.Bool("V", false, "Print the version and exit")
+	)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	args = fs.Args()
+
+	if !validLogFormat(logFormat(*logFmt)) {
+		fmt.Fprintf(stderr, "ssm-env: unknown -log-format %q\n", *logFmt)
+		return 2
+	}
+	log := &logger{w: stderr, format: logFormat(*logFmt), quiet: *quiet}
+
+	onMissing := missingDefault
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "fail-on-missing" {
+			return
+		}
+		if *failMissing {
+			onMissing = missingRequired
+		} else {
+			onMissing = missingOptional
+		}
+	})
+
+	applyConcurrency(fs, *concurrency, ssmConc, kmsConc)
+
+	if *format == FormatExec {
+		*format = ""
+	}
+
+	if *print_version {
+		fmt.Fprintf(stdout, "%s\n", Version)
+
+		return 0
+	}
+
+	if len(args) <= 0 && *resolveOnly == "" && *format == "" && !*printResolved && !*printAll && *credsDir == "" && *envdir == "" && *envExample == "" && *serveSocket == "" && *comparePrefix == "" && *compareRegion == "" && !*debugTmpl && !*estimateCalls && !*dryRun {
+		fs.Usage()
+		return 1
+	}
+
+	if *format != "" && !validFormat(*format) {
+		return fail(log, fmt.Errorf("unknown format %q", *format))
+	}
+
+	if !validNamePolicy(namePolicy(*invalidNames)) {
+		return fail(log, fmt.Errorf("unknown -invalid-names %q", *invalidNames))
+	}
+
+	if !validUnresolvedMode(unresolvedMode(*unresolved)) {
+		return fail(log, fmt.Errorf("unknown -print-unresolved %q", *unresolved))
+	}
+
+	if *endpointURL != "" {
+		if err := checkEndpointURL(*endpointURL); err != nil {
+			return fail(log, fmt.Errorf("invalid -endpoint-url: %v", err))
+		}
+	}
+
+	if *envFile != "" {
+		if err := loadEnvFile(env, *envFile, *envFileWins); err != nil {
+			return fail(log, err)
+		}
+	}
+
+	config := awsConfig{
+		userAgentSuffix:          *uaSuffix,
+		disableEndpointDiscovery: *noDiscovery,
+		region:                   *region,
+		profile:                  *profile,
+		endpointURL:              *endpointURL,
+		factory:                  clients,
+	}
+
+	var ms multiMetrics
+	if *statsdAddr != "" {
+		c, err := newStatsdClient(*statsdAddr, "ssm_env.")
+		if err != nil {
+			return fail(log, err)
+		}
+		ms = append(ms, c)
+	}
+
+	var textfile *textfileMetrics
+	if *promTextfile != "" {
+		textfile = newTextfileMetrics()
+		ms = append(ms, textfile)
+	}
+
+	var st *stats
+	if *printStats {
+		st = new(stats)
+		ms = append(ms, st)
+	}
+
+	var m metrics
+	if len(ms) > 0 {
+		m = ms
+		config.metrics = ms
+	}
+
+	t, err := parseTemplate(*template)
+	if err != nil {
+		return fail(log, err)
+	}
+	// The clients share a session, so the region is looked up once.
+	sessions := &sessionProvider{config: config}
+	e := &expander{
+		batchSize: defaultBatchSize,
+		t:         t,
+		ssm:       &lazySSMClient{sessions: sessions},
+		sm:        &lazySecretsManagerClient{sessions: sessions},
+		kms:       &lazyKMSClient{sessions: sessions},
+		os:        env,
+		log:       log,
+		metrics:   m,
+		clock:     realClock{},
+
+		ssmConcurrency:   *ssmConc,
+		kmsConcurrency:   *kmsConc,
+		maxKMSDecrypts:   *maxDecrypts,
+		denyAdvancedTier: *denyAdvanced,
+		missingSentinel:  *sentinel,
+		strictBase64:     *strictBase64,
+		kmsPrefix:        *kmsPrefix,
+		zeroPlaintext:    *zeroPlain,
+		invalidNames:     namePolicy(*invalidNames),
+		onMissing:        onMissing,
+		disableKMS:       *kmsPrefix == "",
+		flattenJSON:      *flattenJSON,
+		keyCase:          keyCase(*pathKeyCase),
+		stripPrefix:      *stripPrefix,
+		flatPaths:        !*recursive,
+		verifyChecksum:   *checksums,
+		normalizePaths:   *normalize,
+		failOnConflict:   *failConflict,
+		kmsFirst:         *kmsFirst,
+		interpolate:      *interpolate,
+		plaintextSuffix:  *plainSuffix,
+		failOnEmpty:      *failOnEmpty,
+		skipEmpty:        *skipEmpty,
+		failOnDuplicate:  *failDuplicate,
+		retryAttempts:    *retryAttempts,
+		startupJitter:    *jitter,
+	}
+	if *vaultRefs {
+		e.vault = &lazyVaultClient{}
+	}
+
+	if *verbose {
+		e.verbose = log
+	}
+
+	if *reportIAM {
+		e.iam = newIAMReport()
+	}
+
+	if *cacheFile != "" {
+		var key []byte
+		if *cacheKeyFile != "" {
+			if key, err = readCacheKey(*cacheKeyFile); err != nil {
+				return fail(log, err)
+			}
+		} else if !*cachePlain {
+			return fail(log, errors.New("-cache-file needs -cache-key-file to encrypt the cache, or -cache-plaintext to store values in plaintext"))
+		}
+		if e.cache, err = loadCache(*cacheFile, *cacheTTL, key, cacheScope(config)); err != nil {
+			return fail(log, err)
+		}
+	}
+
+	if *useKeychain {
+		kc, err := newOSKeychain()
+		if err != nil {
+			return fail(log, err)
+		}
+		c := &keychainClient{keychain: kc}
+		e.ssm, e.sm = c, c
+	}
+
+	e.kmsEncryptionContext, err = parseEncryptionContext(*kmsContext)
+	if err != nil {
+		return fail(log, err)
+	}
+
+	if !validKeyCase(e.keyCase) {
+		return fail(log, fmt.Errorf("unknown key case %q", e.keyCase))
+	}
+
+	if *preTransform != "" {
+		e.preTransforms = splitList(*preTransform)
+		for _, name := range e.preTransforms {
+			if _, ok := transforms[name]; !ok {
+				return fail(log, fmt.Errorf("unknown transform %q", name))
+			}
+		}
+	}
+
+	if *namePattern != "" {
+		e.namePattern, err = regexp.Compile(*namePattern)
+		if err != nil {
+			return fail(log, err)
+		}
+	}
+
+	if *allowedAccts != "" {
+		e.allowedAccounts = make(map[string]bool)
+		for _, account := range splitList(*allowedAccts) {
+			e.allowedAccounts[account] = true
+		}
+	}
+
+	if *retryValue != "" {
+		e.retryValue, err = regexp.Compile(*retryValue)
+		if err != nil {
+			return fail(log, err)
+		}
+	}
+
+	if *pathNameTmpl != "" {
+		e.pathNameTemplate, err = parseTemplate(*pathNameTmpl)
+		if err != nil {
+			return fail(log, err)
+		}
+	}
+
+	if *filter != "" {
+		e.filter, err = parseTemplate(*filter)
+		if err != nil {
+			return fail(log, err)
+		}
+	}
+
+	if *debugTmpl {
+		if err := e.debugTemplate(stderr); err != nil {
+			return fail(log, err)
+		}
+		return 0
+	}
+
+	if *envExample != "" {
+		b, err := e.envExample()
+		if err != nil {
+			return fail(log, err)
+		}
+		if err := writeFileAtomic(*envExample, b, 0644); err != nil {
+			return fail(log, err)
+		}
+		return 0
+	}
+
+	e.namePrefix = *namePrefix
+
+	var only []string
+	if *resolveOnly != "" {
+		only = splitList(*resolveOnly)
+		e.only = make(map[string]bool)
+		for _, name := range only {
+			e.only[name] = true
+		}
+	}
+
+	if *estimateCalls {
+		est, err := e.estimate(*decrypt)
+		if err != nil {
+			return fail(log, err)
+		}
+		if err := est.print(stdout); err != nil {
+			return fail(log, err)
+		}
+		return 0
+	}
+
+	if *dryRun {
+		problems := e.dryRun()
+		for _, err := range problems {
+			log.write(logEntry{Level: levelError, Msg: err.Error()})
+		}
+		if len(problems) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	if *comparePrefix != "" || *compareRegion != "" {
+		other := *e
+		// The cache is scoped to the values of e, and the values compared
+		// against them are fetched, not cached.
+		other.cache = nil
+		if *compareRegion != "" {
+			c := config
+			c.region = *compareRegion
+			sessions := &sessionProvider{config: c}
+			other.ssm = &lazySSMClient{sessions: sessions}
+			other.sm = &lazySecretsManagerClient{sessions: sessions}
+			other.kms = &lazyKMSClient{sessions: sessions}
+		}
+		if *comparePrefix != "" {
+			parts := strings.SplitN(*comparePrefix, "=", 2)
+			if len(parts) != 2 {
+				return fail(log, fmt.Errorf("-compare-prefix must be FROM=TO, got %q", *comparePrefix))
+			}
+			other.rename = prefixRename(parts[0], parts[1])
+		}
+
+		redactor, err := parseNameRedactor(*redactNames)
+		if err != nil {
+			return fail(log, err)
+		}
+
+		diffs, err := compare(*e, other, envMap(env.Environ()), *decrypt, *nofail)
+		if err != nil {
+			return fail(log, err)
+		}
+		if err := printDifferences(stdout, diffs, redactor); err != nil {
+			return fail(log, err)
+		}
+		if len(diffs) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	var path, fallbackPath string
+	fallbackArgs := strings.Fields(*fallbackCmd)
+	if len(args) > 0 && only == nil && *format == "" && !*printResolved && !*printAll {
+		path, err = exec.LookPath(args[0])
+		if err != nil {
+			return fail(log, err)
+		}
+		if len(fallbackArgs) > 0 {
+			fallbackPath, err = exec.LookPath(fallbackArgs[0])
+			if err != nil {
+				return fail(log, err)
+			}
+		}
+	}
+
+	// Look up the identity up front, so a typo fails before anything is
+	// resolved.
+	id, err := lookupIdentity(*runAsUser, *runAsGroup)
+	if err != nil {
+		return fail(log, err)
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	start := e.now()
+	err = e.expandEnvironWithContext(ctx, *decrypt, *nofail)
+	resolution := e.since(start)
+	if st != nil {
+		log.write(logEntry{Level: levelInfo, Msg: st.summary(resolution)})
+	}
+	if err := e.cache.write(e.now()); err != nil {
+		// The cache is only an optimization.
+		log.write(logEntry{Level: levelWarn, Msg: "writing cache", Error: err.Error()})
+	}
+	if textfile != nil {
+		// Like statsd, metrics are best effort, and never keep the command
+		// from starting.
+		if err := textfile.write(*promTextfile, e.now(), err == nil); err != nil {
+			log.write(logEntry{Level: levelWarn, Msg: "writing metrics", Error: err.Error()})
+		}
+	}
+	if e.iam != nil {
+		// Even when resolution failed, the calls that succeeded are worth
+		// knowing about.
+		if err := e.iam.write(stderr); err != nil {
+			log.write(logEntry{Level: levelWarn, Msg: "writing IAM policy", Error: err.Error()})
+		}
+	}
+	if err != nil {
+		return fail(log, err)
+	}
+
+	if *credsDir != "" {
+		if err := writeCredentials(*credsDir, env, e.resolvedVars()); err != nil {
+			return fail(log, err)
+		}
+	}
+
+	if *envdir != "" {
+		if err := writeEnvdir(*envdir, env, e.resolvedVars()); err != nil {
+			return fail(log, err)
+		}
+	}
+
+	if only != nil || *format != "" || *printResolved || *printAll {
+		names := only
+		if names == nil && *printAll {
+			names = envNames(env)
+		}
+		if names == nil {
+			// The unresolved variables are printed too, for -print-unresolved
+			// to act on.
+			names = append(e.resolvedVars(), e.unresolvedVars()...)
+			sort.Strings(names)
+		}
+		names, err := unresolvedMode(*unresolved).apply(env, names, e.unresolvedVars())
+		if err != nil {
+			return fail(log, err)
+		}
+		f := *format
+		if f == "" {
+			f = FormatEnv
+		}
+		if err := printVars(stdout, env, names, f); err != nil {
+			return fail(log, err)
+		}
+		return 0
+	}
+
+	if fallbackPath != "" && len(e.resolvedVars()) == 0 {
+		e.logf("no references resolved, executing %s instead", fallbackArgs[0])
+		path, args = fallbackPath, fallbackArgs
+	}
+
+	if *serveSocket != "" {
+		l, err := listenUnix(*serveSocket)
+		if err != nil {
+			return fail(log, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *serveFor)
+		defer cancel()
+		served := make(chan error, 1)
+		go func() {
+			served <- serve(ctx, l, envHandler(env, e.resolvedVars()))
+		}()
+
+		// ssm-env has to keep running to serve the environment, so the
+		// command can't replace it, and runs as a child instead.
+		code := 0
+		if path != "" {
+			if err := dropPrivileges(osPrivileges{}, id); err != nil {
+				return fail(log, err)
+			}
+			if *launchEvt {
+				log.write(launchEvent(args[0], resolution))
+			}
+			code, err = runChild(childCommand(path, args, env, stdout, stderr))
+			if err != nil {
+				return fail(log, err)
+			}
+			cancel()
+		}
+		if err := <-served; err != nil {
+			return fail(log, err)
+		}
+		return code
+	}
+
+	if path == "" {
+		return 0
+	}
+	if err := dropPrivileges(osPrivileges{}, id); err != nil {
+		return fail(log, err)
+	}
+	if *launchEvt {
+		log.write(launchEvent(args[0], resolution))
+	}
+	if *supervise {
+		code, err := runChild(childCommand(path, args, env, stdout, stderr))
+		if err != nil {
+			return fail(log, err)
+		}
+		return code
+	}
+	// Exec only returns if it fails, since the command replaces ssm-env.
+	return fail(log, execve(path, args[0:], env.Environ()))
+}
+
+// lazySSMClient wraps the AWS SDK SSM client such that the AWS session and
+// SSM client are not actually initialized until it's used for the first
+// time.
+type lazySSMClient struct {
+	sessions *sessionProvider
+
+	mu   sync.Mutex
+	ssm  SSMClient
+	sess *session.Session
+}
+
+func (c *lazySSMClient) GetParametersWithContext(ctx aws.Context, input *ssm.GetParametersInput, opts ...request.Option) (*ssm.GetParametersOutput, error) {
+	var out *ssm.GetParametersOutput
+	err := c.do(func(client SSMClient) (err error) {
+		out, err = client.GetParametersWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (c *lazySSMClient) DescribeParametersWithContext(ctx aws.Context, input *ssm.DescribeParametersInput, opts ...request.Option) (*ssm.DescribeParametersOutput, error) {
+	var out *ssm.DescribeParametersOutput
+	err := c.do(func(client SSMClient) (err error) {
+		out, err = client.DescribeParametersWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+func (c *lazySSMClient) GetParametersByPathWithContext(ctx aws.Context, input *ssm.GetParametersByPathInput, opts ...request.Option) (*ssm.GetParametersByPathOutput, error) {
+	var out *ssm.GetParametersByPathOutput
+	err := c.do(func(client SSMClient) (err error) {
+		out, err = client.GetParametersByPathWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// do calls fn with the SSM client. If the credentials of its session have
+// expired, which can happen between resolutions in a long running process,
+// the session is created again, loading fresh credentials, and fn is
+// retried once.
+func (c *lazySSMClient) do(fn func(SSMClient) error) error {
+	client, err := c.client(nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(client); !isExpiredToken(err) {
+		return err
+	}
+	if client, err = c.client(client); err != nil {
+		return err
+	}
+	return fn(client)
+}
+
+// client returns the SSM client, initializing it (and the AWS session) if it
+// hasn't been already, or if it's the stale client.
+func (c *lazySSMClient) client(stale SSMClient) (SSMClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ssm == nil || c.ssm == stale {
+		sess, err := c.sessions.session(c.sess)
+		if err != nil {
+			return nil, err
+		}
+		c.ssm = c.sessions.config.clients().newSSM(sess)
+		c.sess = sess
+	}
+	return c.ssm, nil
+}
+
+// templates caches parsed templates by their text, for programs building
+// many expanders with the same template. Parsed templates are never modified,
+// and are safe to execute concurrently, so they can be shared.
+var templates = struct {
+	sync.Mutex
+	m map[string]*template.Template
+}{m: make(map[string]*template.Template)}
+
+// compileTemplate parses a template. It's only called once per template text,
+// and replaced in tests to count calls.
+var compileTemplate = func(templateText string) (*template.Template, error) {
+	return template.New("template").Funcs(TemplateFuncs).Parse(templateText)
+}
+
+// parseTemplate returns the parsed template for templateText, parsing it if
+// it wasn't already. Templates that fail to parse aren't cached.
+func parseTemplate(templateText string) (*template.Template, error) {
+	templates.Lock()
+	defer templates.Unlock()
+
+	if t, ok := templates.m[templateText]; ok {
+		return t, nil
+	}
+
+	t, err := compileTemplate(templateText)
+	if err != nil {
+		return nil, err
+	}
+	templates.m[templateText] = t
+	return t, nil
+}
+
+// SSMClient is the part of the SSM API ssm-env uses, implemented by
+// *ssm.SSM.
+type SSMClient interface {
+	GetParametersWithContext(aws.Context, *ssm.GetParametersInput, ...request.Option) (*ssm.GetParametersOutput, error)
+	DescribeParametersWithContext(aws.Context, *ssm.DescribeParametersInput, ...request.Option) (*ssm.DescribeParametersOutput, error)
+	GetParametersByPathWithContext(aws.Context, *ssm.GetParametersByPathInput, ...request.Option) (*ssm.GetParametersByPathOutput, error)
+}
+
+type environ interface {
+	Environ() []string
+	Setenv(key, vale string)
+	Unsetenv(key string)
+}
+
+type osEnviron int
+
+func (e osEnviron) Environ() []string {
+	return os.Environ()
+}
+
+func (e osEnviron) Setenv(key, val string) {
+	os.Setenv(key, val)
+}
+
+func (e osEnviron) Unsetenv(key string) {
+	os.Unsetenv(key)
+}
+
+type ssmVar struct {
+	envvar    string
+	parameter string
+	decrypt   bool
+	transform string
+
+	// json is set for ssm-json:// references, whose value is a JSON
+	// object that's split into multiple environment variables.
+	json bool
+
+	// policy is whether the parameter missing is an error, for
+	// ssm+required:// and ssm+optional:// references.
+	policy missingPolicy
+
+	// schema, if set, is the JSON schema the value is validated against.
+	schema *jsonSchema
+
+	// def, if set, is the value used when the parameter doesn't exist.
+	def *string
+}
+
+type expander struct {
+	t         *template.Template
+	ssm       SSMClient
+	sm        SecretsManagerClient
+	kms       KMSClient
+	vault     VaultClient
+	os        environ
+	batchSize int
+
+	// batcher, if set, groups parameters into batches, instead of them
+	// being fetched in the order of their names.
+	batcher batcher
+
+	// ssmConcurrency and kmsConcurrency are the maximum number of requests
+	// made to each service at the same time. Values below 1 mean requests
+	// are made one at a time.
+	ssmConcurrency int
+	kmsConcurrency int
+
+	// maxKMSDecrypts, if set, is the maximum number of distinct KMS values
+	// decrypted in a run.
+	maxKMSDecrypts int
+
+	// metrics, if set, receives counters and timings about resolution.
+	metrics metrics
+
+	// clock, if set, is used instead of the system clock.
+	clock clock
+
+	// startupJitter, if set, is the maximum random delay before the first
+	// AWS call. random returns a random number in [0, n), and defaults to
+	// rand.Int63n.
+	startupJitter time.Duration
+	random        func(n int64) int64
+
+	// log is where warnings are written, stderr by default. verbose, if
+	// set, is where diagnostic messages are written.
+	log     *logger
+	verbose *logger
+
+	// iam, if set, records the IAM actions exercised, for -report-iam.
+	iam *iamReport
+
+	// templateEnv is the environment templates get as .Env, as it was
+	// before anything was resolved.
+	templateEnv map[string]string
+
+	// cache, if set, holds the values of parameters fetched by earlier
+	// runs, which aren't fetched again.
+	cache *paramCache
+
+	// denyAdvancedTier refuses to resolve parameters in the Advanced tier.
+	denyAdvancedTier bool
+
+	// missingSentinel, if set, is the value given to variables whose
+	// parameter doesn't exist, when not failing on missing parameters.
+	missingSentinel string
+
+	// strictBase64 disables the padding fixup of base64 KMS ciphertext.
+	strictBase64 bool
+
+	// kmsPrefix, if set, replaces KMSPrefix as the prefix of KMS values.
+	// disableKMS leaves KMS values alone instead.
+	kmsPrefix  string
+	disableKMS bool
+
+	// zeroPlaintext zeroes the buffers holding decrypted plaintext once
+	// they've been copied, with zeroBytes.
+	zeroPlaintext bool
+
+	// kmsEncryptionContext, if set, is the encryption context KMS values
+	// were encrypted with. Decryption fails if it doesn't match.
+	kmsEncryptionContext map[string]*string
+
+	// normalizePaths normalizes the slashes in parameter names with
+	// normalizePath.
+	normalizePaths bool
+
+	// filter, if set, is run for every resolved variable, and the ones it
+	// returns a false value for are unset.
+	filter *template.Template
+
+	// kmsFirst decrypts KMS values before resolving SSM parameters,
+	// instead of after.
+	kmsFirst bool
+
+	// interpolate resolves the references embedded in values, on top of
+	// the values that are a reference.
+	interpolate bool
+
+	// plaintextSuffix, if set, is appended to the name of an environment
+	// variable holding a reference to get the name of its plaintext
+	// companion, which is used instead of resolving the reference.
+	plaintextSuffix string
+
+	// phase is the index of the phase being run, in phases.
+	phase int
+
+	// preTransforms name the transforms applied to the value of every
+	// environment variable before it's matched against the template.
+	preTransforms []string
+
+	// failOnDuplicate treats a parameter returned more than once in the
+	// same response as an error, instead of using the last one.
+	failOnDuplicate bool
+
+	// failOnEmpty treats parameters that exist, but have an empty value,
+	// like parameters that don't exist. Otherwise, their variables are set
+	// to an empty string.
+	failOnEmpty bool
+
+	// skipEmpty leaves the variables of parameters with an empty value as
+	// they are, or sets them to their default value if they have one,
+	// instead of setting them to an empty string.
+	skipEmpty bool
+
+	// verifyChecksum compares resolved values with the checksums in their
+	// sibling parameters.
+	verifyChecksum bool
+
+	// keyCase is what the case of the keys of ssm-json:// parameters, and
+	// of the names of parameters under a path, is changed to.
+	keyCase keyCase
+
+	// flattenJSON flattens nested objects in ssm-json:// parameters,
+	// instead of failing.
+	flattenJSON bool
+
+	// flatPaths only resolves the parameters directly under a path,
+	// instead of every parameter nested under it.
+	flatPaths bool
+
+	// pathNameTemplate, if set, names the variables set from paths, instead
+	// of keyCase.
+	pathNameTemplate *template.Template
+
+	// stripPrefix, if set, is the prefix the variables set from parameters
+	// under it are named relative to, instead of the referenced path.
+	stripPrefix string
+
+	// rename, if set, changes the name of every referenced parameter
+	// before it's fetched.
+	rename func(string) string
+
+	// namePattern, if set, is matched against the name of every referenced
+	// parameter before it's fetched.
+	namePattern *regexp.Regexp
+
+	// allowedAccounts, when non-nil, are the only accounts parameters
+	// referenced by ARN can be read from.
+	allowedAccounts map[string]bool
+
+	// only, when non-nil, restricts expansion to the named environment
+	// variables. All other variables are left untouched.
+	only map[string]bool
+
+	// namePrefix, if set, restricts expansion to the environment variables
+	// whose name starts with it.
+	namePrefix string
+
+	// stream, if set, is called with every variable as it's resolved.
+	stream func(k, v string)
+
+	// retryValue, if set, matches placeholder values of parameters that
+	// are fetched again, up to retryAttempts times in total, expecting
+	// them to be updated.
+	retryValue    *regexp.Regexp
+	retryAttempts int
+
+	// failOnConflict makes environment variables targeted by more than one
+	// reference an error, instead of a warning.
+	failOnConflict bool
+
+	// targets records the reference each environment variable is set by,
+	// during expandEnviron.
+	targets map[string]target
+
+	// resolved records the environment variables that were set by the
+	// last call to expandEnviron.
+	resolved map[string]bool
+
+	// policies records how each parameter missing is treated, during
+	// expandEnviron.
+	policies map[string]missingPolicy
+
+	// onMissing is the policy of references that don't set one with
+	// ssm+required:// or ssm+optional://, for -fail-on-missing.
+	onMissing missingPolicy
+
+	// defaulted records the parameters referenced with a default value,
+	// during expandEnviron. They aren't given missingSentinel.
+	defaulted map[string]bool
+
+	// invalidNames is what happens to variables with an invalid name, and
+	// skipped records the ones left alone because of it, during
+	// expandEnviron.
+	invalidNames namePolicy
+	skipped      map[string]bool
+}
+
+func (e *expander) parameter(k, v string) (*parameterSpec, error) {
+	p, err := e.execTemplate(k, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if p != "" {
+		return parseParameterSpec(p)
+	}
+
+	return nil, nil
+}
+
+// reference is what an environment variable value references, as parsed by
+// parseReference.
+type reference struct {
+	// spec is the parameter referenced, or nil if the value isn't a
+	// reference to one.
+	spec *parameterSpec
+
+	// fallback is set for ssm-or-sm:// references, json for ssm-json://
+	// references and path for ssm-path:// references.
+	fallback bool
+	json     bool
+	path     bool
+
+	// policy is whether the parameter missing is an error, for
+	// ssm+required:// and ssm+optional:// references.
+	policy missingPolicy
+}
+
+// parseReference parses the value v of the environment variable k, with
+// one of the reference prefixes or with the template. The default value and
+// schema, if any, are split from the name of the parameter.
+func (e *expander) parseReference(k, v string) (reference, error) {
+	var ref reference
+	if hasPrefixFold(v, FallbackPrefix) {
+		ref.spec, ref.fallback = &parameterSpec{Name: trimPrefixFold(v, FallbackPrefix)}, true
+	} else if hasPrefixFold(v, JSONPrefix) {
+		ref.spec, ref.json = &parameterSpec{Name: trimPrefixFold(v, JSONPrefix)}, true
+	} else if hasPrefixFold(v, PathPrefix) {
+		ref.spec, ref.path = &parameterSpec{Name: trimPrefixFold(v, PathPrefix)}, true
+	} else if hasPrefixFold(v, RequiredPrefix) {
+		ref.spec, ref.policy = &parameterSpec{Name: trimPrefixFold(v, RequiredPrefix)}, missingRequired
+	} else if hasPrefixFold(v, OptionalPrefix) {
+		ref.spec, ref.policy = &parameterSpec{Name: trimPrefixFold(v, OptionalPrefix)}, missingOptional
+	} else if hasPrefixFold(v, SecurePrefix) {
+		ref.spec = &parameterSpec{Name: trimPrefixFold(v, SecurePrefix), Decrypt: aws.Bool(true)}
+	} else {
+		spec, err := e.parameter(k, v)
+		if err != nil {
+			return ref, err
+		}
+		ref.spec = spec
+	}
+
+	if ref.spec != nil && ref.spec.Default == nil {
+		ref.spec.Name, ref.spec.Default = splitDefault(ref.spec.Name)
+	}
+	if ref.spec != nil && ref.spec.Schema == "" {
+		ref.spec.Name, ref.spec.Schema = splitSchema(ref.spec.Name)
+	}
+	return ref, nil
+}
+
+// parameterSpec is what the template decided for an environment variable.
+//
+// Templates usually return just the name of the parameter, but can also
+// return a JSON object to control how it's resolved:
+//
+//	{"name": "/app/secret", "decrypt": true, "transform": "trimSpace"}
+//
+// decrypt overrides -with-decryption for this parameter, and transform names
+// one of the transforms applied to the resolved value.
+type parameterSpec struct {
+	Name      string `json:"name"`
+	Decrypt   *bool  `json:"decrypt"`
+	Transform string `json:"transform"`
+
+	// Schema is the path of a JSON schema the value is validated against.
+	Schema string `json:"schema"`
+
+	// Default, if set, is the value used when the parameter doesn't exist.
+	Default *string `json:"default"`
+}
+
+// parseParameterSpec parses the output of the template. Parameter names
+// can't start with "{", so anything that does is parsed as JSON. A JSON
+// object without a name means the variable isn't an SSM parameter.
+func parseParameterSpec(s string) (*parameterSpec, error) {
+	if !strings.HasPrefix(strings.TrimSpace(s), "{") {
+		name, schema := splitSchema(s)
+		return &parameterSpec{Name: name, Schema: schema}, nil
+	}
+
+	spec := new(parameterSpec)
+	if err := json.Unmarshal([]byte(s), spec); err != nil {
+		return nil, fmt.Errorf("parsing template output %q: %v", s, err)
+	}
+
+	if spec.Name == "" {
+		return nil, nil
+	}
+
+	if _, ok := transforms[spec.Transform]; spec.Transform != "" && !ok {
+		return nil, fmt.Errorf("unknown transform %q", spec.Transform)
+	}
+
+	return spec, nil
+}
+
+// transforms can be applied to resolved values, by naming them in the
+// transform field of the template output.
+var transforms = map[string]func(string) (string, error){
+	"trimSpace": func(v string) (string, error) { return strings.TrimSpace(v), nil },
+	"toLower":   func(v string) (string, error) { return strings.ToLower(v), nil },
+	"toUpper":   func(v string) (string, error) { return strings.ToUpper(v), nil },
+	"base64Decode": func(v string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(v)
+		return string(b), err
+	},
+
+	// lowerScheme lower cases the scheme of a reference, like SSM:// in
+	// SSM://secret, leaving the rest alone.
+	"lowerScheme": func(v string) (string, error) {
+		if i := strings.Index(v, "://"); i >= 0 {
+			return strings.ToLower(v[:i]) + v[i:], nil
+		}
+		return v, nil
+	},
+}
+
+// preTransform applies the pre-transforms to the value of an environment
+// variable, before it's matched against the template.
+func (e *expander) preTransform(v string) (string, error) {
+	for _, name := range e.preTransforms {
+		var err error
+		if v, err = transforms[name](v); err != nil {
+			return "", fmt.Errorf("applying %s: %v", name, err)
+		}
+	}
+	return v, nil
+}
+
+// templateData is what the template is run with, for every environment
+// variable.
+type templateData struct {
+	Name, Value string
+
+	// Env is the whole environment, as it was before anything was
+	// resolved.
+	Env map[string]string
+}
+
+// execTemplate returns the raw output of the template for an environment
+// variable.
+func (e *expander) execTemplate(k, v string) (string, error) {
+	if e.templateEnv == nil {
+		e.templateEnv = envMap(e.os.Environ())
+	}
+	b := new(bytes.Buffer)
+	if err := e.t.Execute(b, templateData{k, v, e.templateEnv}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// debugTemplate writes the name of every environment variable, along with
+// the template output for it, to w. An empty output means the variable isn't
+// an SSM parameter. Nothing is resolved.
+func (e *expander) debugTemplate(w io.Writer) error {
+	for _, envvar := range e.os.Environ() {
+		k, v := splitVar(envvar)
+
+		v, err := e.preTransform(v)
+		if err != nil {
+			return fmt.Errorf("pre-transforming %s: %v", k, err)
+		}
+
+		p, err := e.execTemplate(k, v)
+		if err != nil {
+			return fmt.Errorf("determining name of parameter for %s: %v", k, err)
+		}
+
+		fmt.Fprintf(w, "ssm-env: %s: %q\n", k, p)
+	}
+	return nil
+}
+
+func (e *expander) expandEnviron(decrypt bool, nofail bool) error {
+	return e.expandEnvironWithContext(context.Background(), decrypt, nofail)
+}
+
+// expandEnvironWithContext is expandEnviron, with no new requests being made
+// once ctx is done. With nofail, whatever was resolved by then is kept, and
+// the rest is left unresolved.
+func (e *expander) expandEnvironWithContext(ctx context.Context, decrypt bool, nofail bool) error {
+	e.resolved = make(map[string]bool)
+	e.templateEnv = envMap(e.os.Environ())
+	e.targets = make(map[string]target)
+	e.policies = make(map[string]missingPolicy)
+	e.defaulted = make(map[string]bool)
+	e.skipped = make(map[string]bool)
+
+	if err := e.checkNames(nofail); err != nil {
+		return err
+	}
+
+	e.waitStartupJitter()
+
+	for i, p := range e.phases() {
+		e.phase = i
+		if err := p.expand(ctx, decrypt, nofail); err != nil {
+			return err
+		}
+	}
+
+	if e.filter != nil {
+		return e.filterResolved()
+	}
+	return nil
+}
+
+// phase resolves the environment variables holding one kind of reference.
+type phase struct {
+	expand func(ctx context.Context, decrypt bool, nofail bool) error
+
+	// matches reports whether a value is a reference the phase resolves.
+	matches func(k, v string) bool
+}
+
+// phases returns the phases of resolution, in the order they run in. By
+// default, KMS values are decrypted after SSM parameters are resolved, so a
+// parameter can hold KMS ciphertext. With kmsFirst, it's the other way
+// around, so KMS ciphertext can hold an SSM reference. With plaintextSuffix,
+// references with a plaintext companion are replaced by it before either.
+func (e *expander) phases() []phase {
+	ssmPhase := phase{
+		expand:  e.expandSSM,
+		matches: e.isSSMReference,
+	}
+	kmsPhase := phase{
+		expand: func(ctx context.Context, decrypt bool, nofail bool) error {
+			return e.expandKMS(ctx, nofail)
+		},
+		matches: func(k, v string) bool { return e.isKMSValue(v) },
+	}
+
+	ssmPhases := []phase{ssmPhase}
+	if e.interpolate {
+		ssmPhases = append(ssmPhases, e.interpolationPhase())
+	}
+	if e.vault != nil {
+		ssmPhases = append(ssmPhases, e.vaultPhase())
+	}
+
+	phases := append(ssmPhases, kmsPhase)
+	if e.kmsFirst {
+		phases = append([]phase{kmsPhase}, ssmPhases...)
+	}
+	if e.plaintextSuffix != "" {
+		phases = append([]phase{e.plaintextPhase(phases)}, phases...)
+	}
+	return phases
+}
+
+// resolvedLater reports whether a value is a reference that a phase after the
+// current one resolves.
+func (e *expander) resolvedLater(k, v string) bool {
+	for _, p := range e.phases()[e.phase+1:] {
+		if p.matches(k, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSSMReference reports whether a value references an SSM parameter.
+func (e *expander) isSSMReference(k, v string) bool {
+	if hasPrefixFold(v, FallbackPrefix) || hasPrefixFold(v, JSONPrefix) || hasPrefixFold(v, PathPrefix) || hasPolicyPrefix(v) || hasPrefixFold(v, SecurePrefix) {
+		return true
+	}
+	spec, err := e.parameter(k, v)
+	return err == nil && spec != nil
+}
+
+// expandSSM resolves the environment variables that reference SSM
+// parameters.
+func (e *expander) expandSSM(ctx context.Context, decrypt bool, nofail bool) error {
+	// Environment variables that point to some SSM parameters.
+	var ssmVars []ssmVar
+
+	// Environment variables that point to every parameter under a path.
+	var pathVars []ssmVar
+
+	// Parameters that should be looked up in Secrets Manager if they
+	// don't exist in SSM.
+	fallbacks := make(map[string]bool)
+
+	envvars := e.os.Environ()
+	vars := envMap(envvars)
+
+	// Unique parameter names, grouped by whether they should be decrypted,
+	// since that's set once per GetParameters call.
+	uniqNames := make(map[bool]map[string]bool)
+
+	// JSON schemas, by path, loaded once however many references use them.
+	schemas := make(map[string]*jsonSchema)
+
+	for _, envvar := range envvars {
+		k, v := splitVar(envvar)
+
+		if !e.considered(k) {
+			continue
+		}
+
+		// Values resolved by an earlier phase aren't what the user wrote, so
+		// they're used as is.
+		var err error
+		if !e.resolved[k] {
+			if v, err = e.preTransform(v); err != nil {
+				return fmt.Errorf("pre-transforming %s: %v", k, err)
+			}
+		}
+
+		ref, err := e.parseReference(k, v)
+		if err != nil {
+			// TODO: Should this _also_ not error if nofail is passed?
+			return fmt.Errorf("determining name of parameter: %v", err)
+		}
+		spec, fallback, isJSON, isPath, policy := ref.spec, ref.fallback, ref.json, ref.path, ref.policy
+
+		// Values resolved by a later phase, like KMS ciphertext or vault://
+		// references, are explicit references too, which ssm-path:// and
+		// ssm-json:// references don't override.
+		if e.resolvedLater(k, v) {
+			e.targets[k] = target{k, precedenceExplicit}
+		}
+
+		if spec == nil && !e.isKMSValue(v) {
+			e.logf("%s: not a reference", k)
+		}
+
+		if spec != nil {
+			p, err := expandVars(spec.Name, vars)
+			if err != nil {
+				return fmt.Errorf("determining name of parameter for %s: %v", k, err)
+			}
+			if path, ok := wildcardPath(p); ok {
+				p, isPath = path, true
+			}
+			if e.normalizePaths {
+				p = normalizePath(p)
+			}
+			if e.rename != nil {
+				p = e.rename(p)
+			}
+			if !e.allowedAccount(p) {
+				err := fmt.Errorf("%s references %s, which isn't in an allowed account", k, p)
+				e.count(metricFailed, 1)
+				if !nofail {
+					return err
+				}
+				e.warnings().warn(err)
+				continue
+			}
+			if e.namePattern != nil && !e.namePattern.MatchString(p) {
+				err := fmt.Errorf("%s references %s, which doesn't match %s", k, p, e.namePattern)
+				e.count(metricFailed, 1)
+				if !nofail {
+					return err
+				}
+				e.warnings().warn(err)
+				continue
+			}
+
+			d := decrypt
+			if spec.Decrypt != nil {
+				d = *spec.Decrypt
+			}
+
+			if isPath {
+				e.logf("%s: parameters under %s", k, p)
+			} else {
+				e.logf("%s: parameter %s", k, p)
+			}
+
+			if isPath {
+				if spec.Default != nil {
+					return fmt.Errorf("%s references the path %s, which can't have a default value", k, p)
+				}
+				pathVars = append(pathVars, ssmVar{envvar: k, parameter: p, decrypt: d})
+				continue
+			}
+			if !isJSON {
+				e.targets[k] = target{k, precedenceExplicit}
+			}
+
+			if fallback {
+				fallbacks[p] = true
+			}
+			if uniqNames[d] == nil {
+				uniqNames[d] = make(map[string]bool)
+			}
+			uniqNames[d][p] = true
+			var schema *jsonSchema
+			if spec.Schema != "" {
+				if schemas[spec.Schema] == nil {
+					if schemas[spec.Schema], err = loadSchema(spec.Schema); err != nil {
+						return fmt.Errorf("loading schema for %s: %v", k, err)
+					}
+				}
+				schema = schemas[spec.Schema]
+			}
+
+			// A default value makes the parameter missing expected, unless
+			// it's explicitly required.
+			if spec.Default != nil {
+				if policy == missingDefault {
+					policy = missingOptional
+				}
+				e.defaulted[p] = true
+			}
+
+			e.setPolicy(p, policy)
+			ssmVars = append(ssmVars, ssmVar{k, p, d, spec.Transform, isJSON, policy, schema, spec.Default})
+		}
+	}
+
+	// Which variables a reference sets when more than one targets them is
+	// settled by claim, so the order they're resolved in doesn't matter.
+	if err := e.expandPaths(ctx, pathVars, nofail); err != nil {
+		return err
+	}
+
+	if len(uniqNames) == 0 {
+		// Nothing to do, no SSM parameters.
+		return nil
+	}
+
+	// The values of the parameters, grouped like their names.
+	values := map[bool]map[string]string{false: {}, true: {}}
+	for _, d := range []bool{false, true} {
+		if len(uniqNames[d]) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(uniqNames[d]))
+		for k := range uniqNames[d] {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		var err error
+		if values[d], err = e.fetchParameters(ctx, names, fallbacks, d, nofail); err != nil {
+			return err
+		}
+	}
+
+	// Every variable is set once, after all the batches are fetched.
+	for _, v := range ssmVars {
+		val, ok := values[v.decrypt][v.parameter]
+		if ok && val == "" && e.skipEmpty {
+			// The variable keeps its value, unless the reference has a
+			// default.
+			e.logf("%s: %s is empty, skipped", v.envvar, v.parameter)
+			ok = false
+		}
+		if !ok && v.def != nil {
+			e.logf("%s: default value of %s", v.envvar, v.parameter)
+			val, ok = *v.def, true
+		}
+		if !ok {
+			continue
+		}
+
+		if v.transform != "" {
+			var err error
+			val, err = transforms[v.transform](val)
+			if err != nil {
+				err = fmt.Errorf("applying %s to %s: %v", v.transform, v.envvar, err)
+				if !nofail {
+					return err
+				}
+				e.warnings().warn(err)
+				continue
+			}
+		}
+
+		if v.schema != nil {
+			if err := v.schema.validate(val); err != nil {
+				err = fmt.Errorf("validating %s against its schema: %v", v.envvar, err)
+				e.count(metricFailed, 1)
+				if !nofail {
+					return err
+				}
+				e.warnings().warn(err)
+				continue
+			}
+		}
+
+		if v.json {
+			if err := e.setJSONVars(v.envvar, val, nofail); err != nil {
+				err = fmt.Errorf("splitting %s into variables: %v", v.envvar, err)
+				if !nofail {
+					return err
+				}
+				e.warnings().warn(err)
+			}
+			continue
+		}
+
+		e.setResolved(v.envvar, val)
+	}
+
+	return nil
+}
+
+// fetchParameters returns the values of the parameters named names, which
+// are fetched in batches, concurrently. The errors of every batch are
+// reported together, and nothing is returned if any of them fail. With
+// nofail, batches that fail are left out, with a warning, unless they fail
+// because required parameters are missing. Parameters in the cache aren't
+// fetched, and the ones fetched are added to it.
+func (e *expander) fetchParameters(ctx context.Context, names []string, fallbacks map[string]bool, decrypt bool, nofail bool) (map[string]string, error) {
+	values := make(map[string]string)
+	if names = e.cachedParameters(names, decrypt, values); len(names) == 0 {
+		return values, nil
+	}
+
+	// Batches are fetched concurrently, but the environment is only
+	// modified from the calling goroutine.
+	b := e.batches(names)
+	for i, batch := range b {
+		e.logf("batch %d of %d, with decryption %t: %v", i+1, len(b), decrypt, batch)
+	}
+	results := make([]batchResult, len(b))
+	forEach(len(b), e.ssmConcurrency, func(i int) {
+		if err := ctx.Err(); err != nil {
+			results[i].err = err
+			return
+		}
+		results[i].values, results[i].err = e.getSettledParameters(ctx, b[i], fallbacks, decrypt, nofail)
+	})
+
+	var errs batchErrors
+	for i, r := range results {
+		if r.err == nil {
+			continue
+		}
+		// With nofail, getParameters only returns errors for required
+		// parameters that are missing. Otherwise, this batch wasn't
+		// fetched before ctx was done.
+		var invalid *invalidParametersError
+		if !nofail || errors.As(r.err, &invalid) {
+			errs = errs.add(r.err)
+			continue
+		}
+		e.warnings().write(logEntry{Level: levelWarn, Msg: fmt.Sprintf("not resolving %v", b[i]), Error: r.err.Error()})
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		e.cacheParameters(r.values, decrypt)
+		for name, val := range r.values {
+			values[name] = val
+		}
+	}
+	return values, nil
+}
+
+// batchResult holds the outcome of fetching a batch of parameters.
+type batchResult struct {
+	values map[string]string
+	err    error
+}
+
+// batchErrors are the errors of fetching more than one batch of parameters.
+type batchErrors []error
+
+// add returns errs with err added, unless it's already there, as the
+// context error of every batch not fetched in time is.
+func (errs batchErrors) add(err error) batchErrors {
+	for _, e := range errs {
+		if e == err {
+			return errs
+		}
+	}
+	return append(errs, err)
+}
+
+// err returns nil if there are no errors, the error itself if there's one,
+// and errs otherwise.
+func (errs batchErrors) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
+	}
+}
+
+func (errs batchErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// applyConcurrency sets the limits of -ssm-concurrency and -kms-concurrency
+// to concurrency if -concurrency was given, except for the ones given
+// themselves.
+func applyConcurrency(fs *flag.FlagSet, concurrency int, ssmConc, kmsConc *int) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["concurrency"] {
+		return
+	}
+	if !set["ssm-concurrency"] {
+		*ssmConc = concurrency
+	}
+	if !set["kms-concurrency"] {
+		*kmsConc = concurrency
+	}
+}
+
+// forEach calls fn with every i in [0, n), from at most concurrency
+// goroutines at a time, and waits for all of them to return. A concurrency
+// below 1 calls fn sequentially.
+func forEach(n, concurrency int, fn func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// batcher groups the names of the parameters to resolve into the batches
+// they're fetched in, e.g. by prefix or priority.
+type batcher interface {
+	Batch(names []string) [][]string
+}
+
+// batcherFunc adapts a function to a batcher.
+type batcherFunc func(names []string) [][]string
+
+func (f batcherFunc) Batch(names []string) [][]string {
+	return f(names)
+}
+
+// batches splits names into the batches they're fetched in. By default,
+// these are consecutive batches of at most batchSize names. The batches of
+// a batcher are split further if they're larger than batchSize.
+func (e *expander) batches(names []string) [][]string {
+	if e.batcher == nil {
+		return batches(names, e.batchSize)
+	}
+
+	var b [][]string
+	for _, group := range e.batcher.Batch(names) {
+		b = append(b, batches(group, e.batchSize)...)
+	}
+	return b
+}
+
+// batches splits names into consecutive batches of at most size names each.
+func batches(names []string, size int) [][]string {
+	var b [][]string
+	for i := 0; i < len(names); i += size {
+		j := i + size
+		if j > len(names) {
+			j = len(names)
+		}
+		b = append(b, names[i:j])
+	}
+	return b
+}
+
+// resolvedVars returns the names of the environment variables set by the
+// last call to expandEnviron, in sorted order.
+func (e *expander) resolvedVars() []string {
+	names := make([]string, 0, len(e.resolved))
+	for name := range e.resolved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setResolved sets the environment variable k to the value it was resolved
+// to.
+func (e *expander) setResolved(k, v string) {
+	e.os.Setenv(k, v)
+	e.resolved[k] = true
+	e.count(metricResolved, 1)
+	if e.stream != nil {
+		e.stream(k, v)
+	}
+}
+
+func (e *expander) getParameters(ctx context.Context, names []string, fallbacks map[string]bool, decrypt bool, nofail bool) (map[string]string, error) {
+	values := make(map[string]string)
+
+	if e.denyAdvancedTier {
+		advanced, err := e.advancedParameters(ctx, names)
+		if err == nil && len(advanced) > 0 {
+			err = fmt.Errorf("parameters in the Advanced tier: %v", advanced)
+		}
+		if err != nil {
+			if !nofail {
+				return values, err
+			}
+			e.warnings().warn(err)
+			names = without(names, advanced)
+		}
+		if len(names) == 0 {
+			return values, nil
+		}
+	}
+
+	input := &ssm.GetParametersInput{
+		WithDecryption: aws.Bool(decrypt),
+	}
+
+	for _, n := range names {
+		input.Names = append(input.Names, aws.String(n))
+	}
+
+	start := e.now()
+	resp, err := e.ssm.GetParametersWithContext(ctx, input)
+	e.count(metricCalls, 1)
+	e.timing(metricLatency, e.since(start))
+	e.logCall(fmt.Sprintf("ssm:GetParameters of %v", names), start, err)
+	if err != nil {
+		e.count(metricFailed, int64(len(names)))
+		if !nofail {
+			return values, err
+		}
+		// This includes failing to create the AWS session at all, in which
+		// case the references are left unresolved.
+		e.warnings().warn(err)
+		return values, nil
+	}
+
+	if len(fallbacks) > 0 {
+		// Parameters that don't exist in SSM are retried against Secrets
+		// Manager. Only the ones missing from both remain invalid.
+		var missing []*string
+		for _, p := range resp.InvalidParameters {
+			if p == nil || !fallbacks[*p] {
+				missing = append(missing, p)
+				continue
+			}
+
+			value, found, err := e.getSecret(ctx, *p)
+			if err != nil {
+				if !nofail {
+					return values, err
+				}
+				e.warnings().warn(err)
+			}
+			if found {
+				values[*p] = value
+			} else {
+				missing = append(missing, p)
+			}
+		}
+		resp.InvalidParameters = missing
+	}
+
+	if len(resp.InvalidParameters) > 0 {
+		e.count(metricFailed, int64(len(resp.InvalidParameters)))
+		invalid := newInvalidParametersError(resp)
+		if required := e.intolerable(invalid.InvalidParameters, nofail); len(required) > 0 {
+			return values, &invalidParametersError{InvalidParameters: required}
+		}
+		e.warnings().warnInvalid(invalid)
+
+		if e.missingSentinel != "" {
+			for _, p := range resp.InvalidParameters {
+				if p != nil && !e.defaulted[*p] {
+					values[*p] = e.missingSentinel
+				}
+			}
+		}
+	}
+
+	// AWS shouldn't return a parameter more than once, but if it does, the
+	// last entry wins, unless duplicates are an error.
+	var fetched, duplicates []string
+	seen := make(map[string]int)
+	for _, p := range resp.Parameters {
+		e.iam.add("ssm:GetParameters", aws.StringValue(p.ARN))
+		var name string
+		if p.Selector != nil {
+			name = *p.Name + *p.Selector
+		} else {
+			name = *p.Name
+		}
+		values[name] = *p.Value
+		seen[name]++
+		switch seen[name] {
+		case 1:
+			fetched = append(fetched, name)
+		case 2:
+			duplicates = append(duplicates, name)
+		}
+	}
+
+	matchSelectors(e.warnings(), names, resp.InvalidParameters, values, fetched)
+
+	if e.failOnDuplicate && len(duplicates) > 0 {
+		err := fmt.Errorf("parameters returned more than once: %v", duplicates)
+		e.count(metricFailed, int64(len(duplicates)))
+		if !nofail {
+			return values, err
+		}
+		e.warnings().warn(err)
+		for _, name := range duplicates {
+			delete(values, name)
+		}
+		fetched = without(fetched, duplicates)
+	}
+
+	if e.failOnEmpty {
+		var empty []string
+		for _, name := range fetched {
+			if values[name] == "" {
+				empty = append(empty, name)
+			}
+		}
+		if len(empty) > 0 {
+			err := fmt.Errorf("parameters with empty values: %v", empty)
+			e.count(metricFailed, int64(len(empty)))
+			if !nofail {
+				return values, err
+			}
+			e.warnings().warn(err)
+			for _, name := range empty {
+				delete(values, name)
+			}
+			fetched = without(fetched, empty)
+		}
+	}
+
+	if e.verifyChecksum && len(fetched) > 0 {
+		if err := e.verifyChecksums(ctx, values, fetched, decrypt, nofail); err != nil {
+			return values, err
+		}
+	}
+
+	return values, nil
+}
+
+type invalidParametersError struct {
+	InvalidParameters []string
+}
+
+func newInvalidParametersError(resp *ssm.GetParametersOutput) *invalidParametersError {
+	e := new(invalidParametersError)
+	for _, p := range resp.InvalidParameters {
+		if p == nil {
+			continue
+		}
+
+		e.InvalidParameters = append(e.InvalidParameters, *p)
+	}
+	return e
+}
+
+func (e *invalidParametersError) Error() string {
+	return fmt.Sprintf("invalid parameters: %v", e.InvalidParameters)
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// trimPrefixFold returns s without the leading prefix, ignoring case. The rest
+// of s is returned unchanged.
+func trimPrefixFold(s, prefix string) string {
+	if hasPrefixFold(s, prefix) {
+		return s[len(prefix):]
+	}
+	return s
+}
+
+// printVars writes the named environment variables to w in format, in the
+// order given. Variables that aren't set are skipped.
+func printVars(w io.Writer, env environ, names []string, format string) error {
+	write, done, err := newVarWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	vars := envMap(env.Environ())
+
+	for _, name := range names {
+		if v, ok := vars[name]; ok {
+			if err := write(name, v); err != nil {
+				return err
+			}
+		}
+	}
+	return done()
+}
+
+// expandVars replaces ${VAR} and $VAR in a parameter name with the value of
+// the environment variable VAR, so parameter names can be built from other
+// variables. $$ is replaced with a literal $. Referencing a variable that
+// isn't set is an error.
+func expandVars(s string, vars map[string]string) (string, error) {
+	var missing []string
+	expanded := os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		v, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s references unset environment variables: %v", s, missing)
+	}
+	return expanded, nil
+}
+
+// normalizePath returns a parameter name with repeated slashes collapsed and
+// a single leading slash, so ssm://path, ssm:///path and ssm:////path all
+// refer to /path. ARNs are returned unchanged.
+func normalizePath(name string) string {
+	if isParameterARN(name) {
+		return name
+	}
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return name
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// envMap converts a list of KEY=VALUE environment variables into a map.
+func envMap(envvars []string) map[string]string {
+	vars := make(map[string]string)
+	for _, envvar := range envvars {
+		k, v := splitVar(envvar)
+		vars[k] = v
+	}
+	return vars
+}
+
+// envNames returns the names of every variable in env, sorted.
+func envNames(env environ) []string {
+	vars := envMap(env.Environ())
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitList splits a comma separated list, ignoring surrounding whitespace
+// and empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// splitVar splits an environment variable into its key and value. Only the
+// first "=" separates them, the value is preserved exactly.
+func splitVar(v string) (key, val string) {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) < 2 {
+		// A malformed entry without an "=" can't be a reference, so it's
+		// treated as a variable with an empty value.
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// fail logs err, and returns the exit code for it.
+func fail(l *logger, err error) int {
+	l.write(logEntry{Level: levelError, Msg: err.Error()})
+	return 1
+}
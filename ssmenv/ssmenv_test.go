@@ -0,0 +1,1899 @@
+package ssmenv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpandEnviron_NoSSMParameters(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_SimpleSSMParameter(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_VersionedSSMParameter(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret:1")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret:1")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("versioned"), Selector: aws.String(":1")},
+		},
+	}, nil)
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=versioned",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_CustomTemplate(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(`{{ if eq .Name "SUPER_SECRET" }}secret{{end}}`)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := true
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_DuplicateSSMParameter(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET_A=hehe",
+		"SUPER_SECRET_B=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_InvalidParameters(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("secret")},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.Equal(t, &invalidParametersError{InvalidParameters: []string{"secret"}}, err)
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_InvalidParametersNoFail(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("secret")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+
+  assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=ssm://secret",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_MissingSentinel(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:               template.Must(parseTemplate(DefaultTemplate)),
+		os:              os,
+		ssm:             c,
+		batchSize:       defaultBatchSize,
+		missingSentinel: "__MISSING__",
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+
+	c.On("GetParameters", mock.Anything).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+		},
+		InvalidParameters: []*string{aws.String("secret-b")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET_A=val-a",
+		"SUPER_SECRET_B=__MISSING__",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_MissingSentinelFail(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:               template.Must(parseTemplate(DefaultTemplate)),
+		os:              os,
+		ssm:             c,
+		batchSize:       defaultBatchSize,
+		missingSentinel: "__MISSING__",
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", mock.Anything).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("secret")},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.Equal(t, &invalidParametersError{InvalidParameters: []string{"secret"}}, err)
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_SessionErrorNoFail(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	// The lazy client returns session creation errors from GetParameters.
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return((*ssm.GetParametersOutput)(nil), errors.New("NoCredentialProviders: no valid providers in chain"))
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=ssm://secret",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_SessionError(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	errSession := errors.New("NoCredentialProviders: no valid providers in chain")
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return((*ssm.GetParametersOutput)(nil), errSession)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.Equal(t, errSession, err)
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_BatchParameters(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: 1,
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+		},
+	}, nil)
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-b"), Value: aws.String("val-b")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET_A=val-a",
+		"SUPER_SECRET_B=val-b",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_CaseInsensitiveScheme(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET_A", "SSM://secret-a")
+	os.Setenv("SUPER_SECRET_B", "Ssm://Secret-B")
+
+	c.On("GetParameters", mock.MatchedBy(func(input *ssm.GetParametersInput) bool {
+		names := aws.StringValueSlice(input.Names)
+		sort.Strings(names)
+		return assert.ObjectsAreEqual([]string{"Secret-B", "secret-a"}, names)
+	})).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+			{Name: aws.String("Secret-B"), Value: aws.String("val-b")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET_A=val-a",
+		"SUPER_SECRET_B=val-b",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_PreTransform(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:             template.Must(parseTemplate(`{{ if hasPrefix .Value "ssm://" }}{{ trimPrefix .Value "ssm://" }}{{ end }}`)),
+		os:            os,
+		ssm:           c,
+		kms:           k,
+		batchSize:     defaultBatchSize,
+		preTransforms: []string{"trimSpace", "lowerScheme"},
+	}
+
+	os.Setenv("SUPER_SECRET_A", "  SSM://Secret-A\n")
+	os.Setenv("SUPER_SECRET_B", "\tssm://secret-b")
+	os.Setenv("SUPER_SECRET_C", " !kms Y2lwaGVydGV4dA== ")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("Secret-A"), aws.String("secret-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("Secret-A"), Value: aws.String(" val-a ")},
+			{Name: aws.String("secret-b"), Value: aws.String("val-b")},
+		},
+	}, nil)
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("val-c"),
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	// Resolved values aren't transformed.
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET_A= val-a ",
+		"SUPER_SECRET_B=val-b",
+		"SUPER_SECRET_C=val-c",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestTrimPrefixFold(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"ssm://a/B", "a/B"},
+		{"SSM://a/B", "a/B"},
+		{"sSm://a/B", "a/B"},
+		{"ssm:/a/B", "ssm:/a/B"},
+		{"ssm", "ssm"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, trimPrefixFold(tt.in, "ssm://"))
+	}
+}
+
+func TestExpandEnviron_PreservesEquals(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("FOO", "a=b=c")
+	os.Setenv("TOKEN", "ssm://token")
+	os.Setenv("DSN", "ssm://dsn")
+
+	c.On("GetParameters", mock.MatchedBy(func(input *ssm.GetParametersInput) bool {
+		names := aws.StringValueSlice(input.Names)
+		sort.Strings(names)
+		return assert.ObjectsAreEqual([]string{"dsn", "token"}, names)
+	})).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("token"), Value: aws.String("eyJhbGciOiJIUzI1NiJ9.e30==")},
+			{Name: aws.String("dsn"), Value: aws.String("host=db user=app password=p@ss=")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"DSN=host=db user=app password=p@ss=",
+		"FOO=a=b=c",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+		"TOKEN=eyJhbGciOiJIUzI1NiJ9.e30==",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestSplitVar(t *testing.T) {
+	tests := []struct {
+		in       string
+		key, val string
+	}{
+		{"FOO=bar", "FOO", "bar"},
+		{"FOO=a=b=c", "FOO", "a=b=c"},
+		{"TOKEN=eyJ0eXAi==", "TOKEN", "eyJ0eXAi=="},
+		{"EMPTY=", "EMPTY", ""},
+		{"=", "", ""},
+		{"=bar", "", "bar"},
+		{"MALFORMED", "MALFORMED", ""},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		key, val := splitVar(tt.in)
+		assert.Equal(t, tt.key, key, tt.in)
+		assert.Equal(t, tt.val, val, tt.in)
+	}
+}
+
+// malformedEnviron is a fakeEnviron that also returns entries without an "=",
+// which Environ can return when the environment is set up by something other
+// than setenv.
+type malformedEnviron struct {
+	fakeEnviron
+	malformed []string
+}
+
+func (e malformedEnviron) Environ() []string {
+	return append(e.fakeEnviron.Environ(), e.malformed...)
+}
+
+func TestExpandEnviron_MalformedEntries(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        malformedEnviron{os, []string{"MALFORMED", ""}},
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+	assert.Equal(t, "hehe", os["SUPER_SECRET"])
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_ParameterNameFromEnv(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("APP_ENV", "prod")
+	os.Setenv("SUPER_SECRET", "ssm:///${APP_ENV}/secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/prod/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/prod/secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"APP_ENV=prod",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_ParameterNameFromMissingEnv(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm:///${APP_ENV}/secret")
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "determining name of parameter for SUPER_SECRET: /${APP_ENV}/secret references unset environment variables: [APP_ENV]")
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"APP_ENV": "prod",
+		"EMPTY":   "",
+	}
+
+	tests := []struct {
+		in, out string
+	}{
+		{"/myapp/secret", "/myapp/secret"},
+		{"/${APP_ENV}/secret", "/prod/secret"},
+		{"/$APP_ENV/secret", "/prod/secret"},
+		{"/prod${EMPTY}/secret", "/prod/secret"},
+		{"/$$APP_ENV/secret", "/$APP_ENV/secret"},
+	}
+
+	for _, tt := range tests {
+		out, err := expandVars(tt.in, vars)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.out, out)
+	}
+}
+
+func TestExpandEnviron_EmptyValue(t *testing.T) {
+	tests := []struct {
+		failOnEmpty bool
+		nofail      bool
+		err         string
+		value       string
+	}{
+		{false, false, "", ""},
+		{true, false, "parameters with empty values: [empty]", "ssm://empty"},
+		{true, true, "", "ssm://empty"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:           template.Must(parseTemplate(DefaultTemplate)),
+			os:          os,
+			ssm:         c,
+			batchSize:   defaultBatchSize,
+			failOnEmpty: tt.failOnEmpty,
+		}
+
+		os.Setenv("EMPTY_SECRET", "ssm://empty")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("empty")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("empty"), Value: aws.String("")},
+			},
+		}, nil)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		v, ok := os["EMPTY_SECRET"]
+		assert.True(t, ok)
+		assert.Equal(t, tt.value, v)
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_DuplicateParameter(t *testing.T) {
+	tests := []struct {
+		failOnDuplicate bool
+		nofail          bool
+		err             string
+		value           string
+	}{
+		{false, false, "", "second"},
+		{true, false, "parameters returned more than once: [secret]", "ssm://secret"},
+		{true, true, "", "ssm://secret"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:               template.Must(parseTemplate(DefaultTemplate)),
+			os:              os,
+			ssm:             c,
+			batchSize:       defaultBatchSize,
+			failOnDuplicate: tt.failOnDuplicate,
+		}
+
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret"), Value: aws.String("first")},
+				{Name: aws.String("secret"), Value: aws.String("second")},
+				{Name: aws.String("secret"), Value: aws.String("second")},
+			},
+		}, nil)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, tt.value, os["SUPER_SECRET"])
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_RelativeNames(t *testing.T) {
+	tests := []struct {
+		template    string
+		value       string
+		namePattern *regexp.Regexp
+		err         string
+	}{
+		// Names without a leading slash are used as is, with the default
+		// template and custom ones alike.
+		{DefaultTemplate, "ssm://secret", nil, ""},
+		{`{{ if eq .Name "SUPER_SECRET" }}secret{{ end }}`, "anything", nil, ""},
+
+		// -name-pattern is how they're made an error.
+		{DefaultTemplate, "ssm://secret", regexp.MustCompile(`^/`), "SUPER_SECRET references secret, which doesn't match ^/"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:           template.Must(parseTemplate(tt.template)),
+			os:          os,
+			ssm:         c,
+			batchSize:   defaultBatchSize,
+			namePattern: tt.namePattern,
+		}
+
+		os.Setenv("SUPER_SECRET", tt.value)
+
+		if tt.err == "" {
+			c.On("GetParameters", &ssm.GetParametersInput{
+				Names:          []*string{aws.String("secret")},
+				WithDecryption: aws.Bool(false),
+			}).Return(&ssm.GetParametersOutput{
+				Parameters: []*ssm.Parameter{
+					{Name: aws.String("secret"), Value: aws.String("hehe")},
+				},
+			}, nil)
+		}
+
+		decrypt := false
+		nofail := false
+		err := e.expandEnviron(decrypt, nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, "hehe", os["SUPER_SECRET"])
+		}
+
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_NamePattern(t *testing.T) {
+	tests := []struct {
+		ref    string
+		nofail bool
+		err    string
+		value  string
+	}{
+		{"ssm:///myapp/db-password", false, "", "hehe"},
+		{"ssm:///MyApp/db_password", false, "SUPER_SECRET references /MyApp/db_password, which doesn't match ^/myapp/[a-z-]+$", "ssm:///MyApp/db_password"},
+		{"ssm:///myapp/db/password", true, "", "ssm:///myapp/db/password"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		c := new(mockSSM)
+		e := expander{
+			t:           template.Must(parseTemplate(DefaultTemplate)),
+			os:          os,
+			ssm:         c,
+			batchSize:   defaultBatchSize,
+			namePattern: regexp.MustCompile(`^/myapp/[a-z-]+$`),
+		}
+
+		os.Setenv("SUPER_SECRET", tt.ref)
+
+		if tt.value == "hehe" {
+			c.On("GetParameters", &ssm.GetParametersInput{
+				Names:          []*string{aws.String("/myapp/db-password")},
+				WithDecryption: aws.Bool(false),
+			}).Return(&ssm.GetParametersOutput{
+				Parameters: []*ssm.Parameter{
+					{Name: aws.String("/myapp/db-password"), Value: aws.String("hehe")},
+				},
+			}, nil)
+		}
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, tt.value, os["SUPER_SECRET"])
+
+		// Names that don't match aren't fetched.
+		c.AssertExpectations(t)
+	}
+}
+
+func TestExpandEnviron_NormalizePaths(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:              template.Must(parseTemplate(DefaultTemplate)),
+		os:             os,
+		ssm:            c,
+		batchSize:      defaultBatchSize,
+		normalizePaths: true,
+	}
+
+	os.Setenv("SECRET_A", "ssm://myapp/secret")
+	os.Setenv("SECRET_B", "ssm:///myapp/secret")
+	os.Setenv("SECRET_C", "ssm:////myapp//secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/myapp/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/myapp/secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SECRET_A=hehe",
+		"SECRET_B=hehe",
+		"SECRET_C=hehe",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"myapp/secret", "/myapp/secret"},
+		{"/myapp/secret", "/myapp/secret"},
+		{"//myapp/secret", "/myapp/secret"},
+		{"/myapp//secret/", "/myapp/secret"},
+		{"secret:1", "/secret:1"},
+		{"/", "/"},
+		{"arn:aws:ssm:us-east-1:111111111111:parameter/myapp/secret", "arn:aws:ssm:us-east-1:111111111111:parameter/myapp/secret"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, normalizePath(tt.in), tt.in)
+	}
+}
+
+func TestExpandEnviron_ConcurrencyPerBackend(t *testing.T) {
+	os := newFakeEnviron()
+	c := &concurrentSSM{latency: 20 * time.Millisecond}
+	k := &concurrentKMS{latency: 20 * time.Millisecond}
+	e := expander{
+		t:              template.Must(parseTemplate(DefaultTemplate)),
+		os:             os,
+		ssm:            c,
+		kms:            k,
+		batchSize:      1,
+		ssmConcurrency: 3,
+		kmsConcurrency: 2,
+	}
+
+	for i := 0; i < 10; i++ {
+		os.Setenv(fmt.Sprintf("SSM_SECRET_%d", i), fmt.Sprintf("ssm:///secret-%d", i))
+		os.Setenv(fmt.Sprintf("KMS_SECRET_%d", i), "!kms "+base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("secret-%d", i))))
+	}
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, c.max)
+	assert.Equal(t, 2, k.max)
+	assert.Equal(t, "value-/secret-3", os["SSM_SECRET_3"])
+	assert.Equal(t, "secret-3", os["KMS_SECRET_3"])
+}
+
+func TestExpandEnviron_BatchErrors(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:              template.Must(parseTemplate(DefaultTemplate)),
+		os:             os,
+		ssm:            c,
+		batchSize:      1,
+		ssmConcurrency: 3,
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+	os.Setenv("SUPER_SECRET_C", "ssm://secret-c")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(false),
+	}).Return((*ssm.GetParametersOutput)(nil), errors.New("throttled"))
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-b"), Value: aws.String("val-b")},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-c")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("secret-c")},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "throttled; invalid parameters: [secret-c]")
+	assert.Equal(t, "ssm://secret-b", os["SUPER_SECRET_B"])
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_SetOncePerVar(t *testing.T) {
+	os := &countingEnviron{fakeEnviron: newFakeEnviron(), sets: make(map[string]int)}
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: 1,
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		os.fakeEnviron.Setenv("SUPER_SECRET_"+strings.ToUpper(name), "ssm://secret-"+name)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret-" + name)},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret-" + name), Value: aws.String("val-" + name)},
+			},
+		}, nil)
+	}
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]int{
+		"SUPER_SECRET_A": 1,
+		"SUPER_SECRET_B": 1,
+		"SUPER_SECRET_C": 1,
+	}, os.sets)
+	assert.Equal(t, "val-b", os.fakeEnviron["SUPER_SECRET_B"])
+
+	c.AssertExpectations(t)
+}
+
+func TestForEach(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 4} {
+		var (
+			mu   sync.Mutex
+			busy inFlight
+			seen []int
+		)
+		forEach(10, concurrency, func(i int) {
+			busy.enter()
+			defer busy.exit()
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, i)
+		})
+
+		sort.Ints(seen)
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, seen)
+
+		max := concurrency
+		if max < 1 {
+			max = 1
+		}
+		assert.Equal(t, max, busy.max)
+	}
+}
+
+func TestApplyConcurrency(t *testing.T) {
+	tests := []struct {
+		args     []string
+		ssm, kms int
+	}{
+		{nil, defaultSSMConcurrency, defaultKMSConcurrency},
+		{[]string{"-concurrency", "8"}, 8, 8},
+		{[]string{"-concurrency", "8", "-kms-concurrency", "2"}, 8, 2},
+		{[]string{"-ssm-concurrency", "2", "-concurrency", "8"}, 2, 8},
+		{[]string{"-ssm-concurrency", "2"}, 2, defaultKMSConcurrency},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("ssm-env", flag.ContinueOnError)
+		ssmConc := fs.Int("ssm-concurrency", defaultSSMConcurrency, "")
+		kmsConc := fs.Int("kms-concurrency", defaultKMSConcurrency, "")
+		concurrency := fs.Int("concurrency", 0, "")
+		assert.NoError(t, fs.Parse(tt.args))
+
+		applyConcurrency(fs, *concurrency, ssmConc, kmsConc)
+		assert.Equal(t, tt.ssm, *ssmConc, "%v", tt.args)
+		assert.Equal(t, tt.kms, *kmsConc, "%v", tt.args)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e"}
+
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, batches(names, 2))
+	assert.Equal(t, [][]string{{"a", "b", "c", "d", "e"}}, batches(names, 10))
+	assert.Empty(t, batches(nil, 10))
+}
+
+// prefixBatcher groups names by their first path segment.
+var prefixBatcher = batcherFunc(func(names []string) [][]string {
+	var b [][]string
+	index := make(map[string]int)
+	for _, name := range names {
+		prefix := strings.SplitN(strings.TrimPrefix(name, "/"), "/", 2)[0]
+		i, ok := index[prefix]
+		if !ok {
+			i = len(b)
+			index[prefix] = i
+			b = append(b, nil)
+		}
+		b[i] = append(b[i], name)
+	}
+	return b
+})
+
+func TestExpanderBatches(t *testing.T) {
+	names := []string{"/a/1", "/a/2", "/a/3", "/b/1"}
+
+	e := expander{batchSize: 2}
+	assert.Equal(t, [][]string{{"/a/1", "/a/2"}, {"/a/3", "/b/1"}}, e.batches(names))
+
+	e.batcher = prefixBatcher
+	assert.Equal(t, [][]string{{"/a/1", "/a/2"}, {"/a/3"}, {"/b/1"}}, e.batches(names))
+}
+
+func TestExpandEnviron_Batcher(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		batcher:   prefixBatcher,
+	}
+
+	os.Setenv("APP_SECRET", "ssm:///app/secret")
+	os.Setenv("APP_TOKEN", "ssm:///app/token")
+	os.Setenv("DB_PASSWORD", "ssm:///db/password")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/app/secret"), aws.String("/app/token")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/app/secret"), Value: aws.String("secret")},
+			{Name: aws.String("/app/token"), Value: aws.String("token")},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/db/password")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/db/password"), Value: aws.String("password")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"APP_SECRET=secret",
+		"APP_TOKEN=token",
+		"DB_PASSWORD=password",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_TemplateEnv(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(`{{ if hasPrefix .Value "ssm://" }}/{{ .Env.APP_ENV }}/{{ trimPrefix .Value "ssm://" }}{{ end }}`)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("APP_ENV", "prod")
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/prod/secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/prod/secret"), Value: aws.String("hehe")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"APP_ENV=prod",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_StructuredTemplate(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t: template.Must(parseTemplate(`{{ if hasPrefix .Value "secure://" -}}
+			{"name": "{{ trimPrefix .Value "secure://" }}", "decrypt": true, "transform": "trimSpace"}
+			{{- else if hasPrefix .Value "ssm://" }}{{ trimPrefix .Value "ssm://" }}{{ end }}`)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("PLAIN", "ssm://plain")
+	os.Setenv("SUPER_SECRET", "secure://secret")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("plain")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("plain"), Value: aws.String("plain-value")},
+		},
+	}, nil)
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("  hehe\n")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"PLAIN=plain-value",
+		"SHELL=/bin/bash",
+		"SUPER_SECRET=hehe",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+}
+
+func TestParseParameterSpec(t *testing.T) {
+	spec, err := parseParameterSpec("/app/secret")
+	assert.NoError(t, err)
+	assert.Equal(t, &parameterSpec{Name: "/app/secret"}, spec)
+
+	spec, err = parseParameterSpec(`{"name": "/app/secret", "decrypt": false, "transform": "base64Decode"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, &parameterSpec{Name: "/app/secret", Decrypt: aws.Bool(false), Transform: "base64Decode"}, spec)
+
+	spec, err = parseParameterSpec(`{"decrypt": true}`)
+	assert.NoError(t, err)
+	assert.Nil(t, spec)
+
+	_, err = parseParameterSpec(`{"name": "/app/secret", "transform": "rot13"}`)
+	assert.EqualError(t, err, `unknown transform "rot13"`)
+
+	_, err = parseParameterSpec(`{"name": `)
+	assert.Error(t, err)
+}
+
+func TestParseReference(t *testing.T) {
+	e := expander{t: template.Must(parseTemplate(DefaultTemplate)), os: newFakeEnviron()}
+
+	tests := []struct {
+		v   string
+		ref reference
+	}{
+		{"value", reference{}},
+		{"ssm://secret", reference{spec: &parameterSpec{Name: "secret"}}},
+		{"ssm://secret|fallback", reference{spec: &parameterSpec{Name: "secret", Default: aws.String("fallback")}}},
+		{"ssm-or-sm://secret", reference{spec: &parameterSpec{Name: "secret"}, fallback: true}},
+		{"ssm-json://secret", reference{spec: &parameterSpec{Name: "secret"}, json: true}},
+		{"ssm-path:///app", reference{spec: &parameterSpec{Name: "/app"}, path: true}},
+		{"ssm+required://secret", reference{spec: &parameterSpec{Name: "secret"}, policy: missingRequired}},
+		{"ssm+optional://secret|", reference{spec: &parameterSpec{Name: "secret", Default: aws.String("")}, policy: missingOptional}},
+		{"ssm+secure://secret", reference{spec: &parameterSpec{Name: "secret", Decrypt: aws.Bool(true)}}},
+	}
+
+	for _, tt := range tests {
+		ref, err := e.parseReference("SECRET", tt.v)
+		assert.NoError(t, err, tt.v)
+		assert.Equal(t, tt.ref, ref, tt.v)
+	}
+}
+
+func TestExpandEnviron_TimeoutPartialResults(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: 1,
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+	os.Setenv("SUPER_SECRET_C", "!kms Y2lwaGVydGV4dA==")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// The deadline passes while the first batch is being fetched.
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(false),
+	}).Run(func(mock.Arguments) {
+		<-ctx.Done()
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnvironWithContext(ctx, decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET_A=val-a",
+		"SUPER_SECRET_B=ssm://secret-b",
+		"SUPER_SECRET_C=!kms Y2lwaGVydGV4dA==",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}
+
+func TestExpandEnviron_Timeout(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: 1,
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(false),
+	}).Run(func(mock.Arguments) {
+		cancel()
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnvironWithContext(ctx, decrypt, nofail)
+	assert.Equal(t, context.Canceled, err)
+
+	c.AssertExpectations(t)
+}
+
+// hangingSSM is an SSM client whose requests never complete on their own,
+// only when the context they're made with is done.
+type hangingSSM struct {
+	mockSSM
+}
+
+func (c *hangingSSM) GetParametersWithContext(ctx aws.Context, input *ssm.GetParametersInput, opts ...request.Option) (*ssm.GetParametersOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestExpandEnviron_TimeoutCancelsRequests(t *testing.T) {
+	for _, nofail := range []bool{false, true} {
+		os := newFakeEnviron()
+		e := expander{
+			t:         template.Must(parseTemplate(DefaultTemplate)),
+			os:        os,
+			ssm:       new(hangingSSM),
+			batchSize: defaultBatchSize,
+		}
+
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		decrypt := false
+		err := e.expandEnvironWithContext(ctx, decrypt, nofail)
+		cancel()
+		if nofail {
+			assert.NoError(t, err)
+		} else {
+			assert.Equal(t, context.DeadlineExceeded, err)
+		}
+		assert.Equal(t, "ssm://secret", os["SUPER_SECRET"])
+	}
+}
+
+func TestExpandEnviron_OnlyVars(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		only:      map[string]bool{"SUPER_SECRET_A": true},
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"SHELL=/bin/bash",
+		"SUPER_SECRET_A=val-a",
+		"SUPER_SECRET_B=ssm://secret-b",
+		"TERM=screen-256color",
+	}, os.Environ())
+	assert.Equal(t, []string{"SUPER_SECRET_A"}, e.resolvedVars())
+
+	c.AssertExpectations(t)
+}
+
+func TestDebugTemplate(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	b := new(bytes.Buffer)
+	err := e.debugTemplate(b)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `ssm-env: SHELL: ""
+ssm-env: SUPER_SECRET: "secret"
+ssm-env: TERM: ""
+`, b.String())
+
+	// Nothing is resolved.
+	c.AssertExpectations(t)
+}
+
+func TestPrintVars(t *testing.T) {
+	os := newFakeEnviron()
+	os.Setenv("SUPER_SECRET_A", "val-a")
+	os.Setenv("SUPER_SECRET_B", "val-b")
+
+	b := new(bytes.Buffer)
+	err := printVars(b, os, []string{"SUPER_SECRET_B", "MISSING", "SUPER_SECRET_A"}, FormatEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, "SUPER_SECRET_B=val-b\nSUPER_SECRET_A=val-a\n", b.String())
+}
+
+func TestPrintVars_Quoting(t *testing.T) {
+	os := newFakeEnviron()
+	os.Setenv("CERT", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----")
+	os.Setenv("QUOTED", `"hello" world`)
+	os.Setenv("DSN", "host=db password=p@ss=")
+
+	b := new(bytes.Buffer)
+	err := printVars(b, os, envNames(os), FormatEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, `CERT="-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----"
+DSN=host=db password=p@ss=
+QUOTED="\"hello\" world"
+SHELL=/bin/bash
+TERM=screen-256color
+`, b.String())
+}
+
+func TestPrintVars_Dotenv(t *testing.T) {
+	os := newFakeEnviron()
+	os.Setenv("CERT", "line 1\nline 2")
+	os.Setenv("TRICKY", `say "hi" to $USER\n`)
+
+	b := new(bytes.Buffer)
+	err := printVars(b, os, []string{"CERT", "TRICKY"}, FormatDotenv)
+	assert.NoError(t, err)
+	assert.Equal(t, `CERT="line 1\nline 2"
+TRICKY="say \"hi\" to \$USER\\n"
+`, b.String())
+}
+
+func TestPrintVars_Shell(t *testing.T) {
+	os := newFakeEnviron()
+	os.Setenv("CERT", "line 1\nline 2")
+	os.Setenv("TRICKY", `it's $HOME`)
+
+	b := new(bytes.Buffer)
+	err := printVars(b, os, []string{"CERT", "TRICKY"}, FormatShell)
+	assert.NoError(t, err)
+	assert.Equal(t, `export CERT='line 1
+line 2'
+export TRICKY='it'\''s $HOME'
+`, b.String())
+
+	// Sourcing the output gives back the values.
+	out, err := exec.Command("sh", "-c", b.String()+`printf '%s|%s' "$CERT" "$TRICKY"`).Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2|it's $HOME", string(out))
+}
+
+func TestPrintVars_DockerEnv(t *testing.T) {
+	os := newFakeEnviron()
+	os.Setenv("GREETING", ` "hello world" `)
+	os.Setenv("EMPTY", "")
+	os.Setenv("CERT", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----")
+
+	b := new(bytes.Buffer)
+	err := printVars(b, os, []string{"GREETING", "EMPTY"}, FormatDockerEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, "GREETING= \"hello world\" \nEMPTY=\n", b.String())
+
+	err = printVars(new(bytes.Buffer), os, []string{"CERT"}, FormatDockerEnv)
+	assert.EqualError(t, err, "CERT spans multiple lines, which docker's --env-file doesn't support")
+
+	err = printVars(new(bytes.Buffer), os, []string{"CERT"}, "yaml")
+	assert.EqualError(t, err, `unknown format "yaml"`)
+}
+
+// inFlight tracks how many calls are in progress at the same time, and the
+// most seen at once.
+type inFlight struct {
+	mu       sync.Mutex
+	cur, max int
+}
+
+func (f *inFlight) enter() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cur++
+	if f.cur > f.max {
+		f.max = f.cur
+	}
+}
+
+func (f *inFlight) exit() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cur--
+}
+
+// concurrentSSM is a latencySSM that tracks concurrent calls.
+type concurrentSSM struct {
+	inFlight
+	latency time.Duration
+}
+
+func (c *concurrentSSM) GetParametersWithContext(ctx aws.Context, input *ssm.GetParametersInput, opts ...request.Option) (*ssm.GetParametersOutput, error) {
+	c.enter()
+	defer c.exit()
+	return (&latencySSM{latency: c.latency}).GetParametersWithContext(ctx, input)
+}
+
+func (c *concurrentSSM) DescribeParametersWithContext(ctx aws.Context, input *ssm.DescribeParametersInput, opts ...request.Option) (*ssm.DescribeParametersOutput, error) {
+	return new(ssm.DescribeParametersOutput), nil
+}
+
+func (c *concurrentSSM) GetParametersByPathWithContext(ctx aws.Context, input *ssm.GetParametersByPathInput, opts ...request.Option) (*ssm.GetParametersByPathOutput, error) {
+	return new(ssm.GetParametersByPathOutput), nil
+}
+
+// concurrentKMS "decrypts" ciphertext to itself, and tracks concurrent calls.
+type concurrentKMS struct {
+	inFlight
+	latency time.Duration
+}
+
+func (c *concurrentKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	c.enter()
+	defer c.exit()
+	time.Sleep(c.latency)
+	return &kms.DecryptOutput{Plaintext: input.CiphertextBlob}, nil
+}
+
+type fakeEnviron map[string]string
+
+func newFakeEnviron() fakeEnviron {
+	return fakeEnviron{
+		"SHELL": "/bin/bash",
+		"TERM":  "screen-256color",
+	}
+}
+
+func (e fakeEnviron) Environ() []string {
+	var env sort.StringSlice
+	for k, v := range e {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	env.Sort()
+	return env
+}
+
+func (e fakeEnviron) Setenv(key, val string) {
+	e[key] = val
+}
+
+func (e fakeEnviron) Unsetenv(key string) {
+	delete(e, key)
+}
+
+// countingEnviron is a fakeEnviron that counts how many times each variable
+// is set.
+type countingEnviron struct {
+	fakeEnviron
+	sets map[string]int
+}
+
+func (e *countingEnviron) Setenv(key, val string) {
+	e.sets[key]++
+	e.fakeEnviron.Setenv(key, val)
+}
+
+type mockSSM struct {
+	mock.Mock
+}
+
+// The expectations of the mocks are set on the name of the method without
+// WithContext, since the context doesn't matter to what's returned.
+
+func (m *mockSSM) GetParametersWithContext(ctx aws.Context, input *ssm.GetParametersInput, opts ...request.Option) (*ssm.GetParametersOutput, error) {
+	args := m.MethodCalled("GetParameters", input)
+	return args.Get(0).(*ssm.GetParametersOutput), args.Error(1)
+}
+
+func (m *mockSSM) DescribeParametersWithContext(ctx aws.Context, input *ssm.DescribeParametersInput, opts ...request.Option) (*ssm.DescribeParametersOutput, error) {
+	args := m.MethodCalled("DescribeParameters", input)
+	return args.Get(0).(*ssm.DescribeParametersOutput), args.Error(1)
+}
+
+func (m *mockSSM) GetParametersByPathWithContext(ctx aws.Context, input *ssm.GetParametersByPathInput, opts ...request.Option) (*ssm.GetParametersByPathOutput, error) {
+	args := m.MethodCalled("GetParametersByPath", input)
+	return args.Get(0).(*ssm.GetParametersByPathOutput), args.Error(1)
+}
+
+// runWith runs ssm-env with args in env, with the SSM client c, and returns
+// its exit code and output.
+func runWith(c SSMClient, env environ, args ...string) (code int, stdout, stderr string) {
+	defer func(f clientFactory) { clients = f }(clients)
+	clients = &fakeClientFactory{region: "us-east-1", ssm: c}
+
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	code = run(args, env, out, errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestRun(t *testing.T) {
+	secret := &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}
+
+	tests := []struct {
+		args   []string
+		found  bool
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"-print"}, true, 0, "SUPER_SECRET=value\n", ""},
+		{[]string{"-format", "json"}, true, 0, `{"SUPER_SECRET":"value"}` + "\n", ""},
+		{[]string{"-format", "ndjson"}, true, 0, `{"name":"SUPER_SECRET","value":"value"}` + "\n", ""},
+		{[]string{"-print-all"}, true, 0, "SHELL=/bin/bash\nSUPER_SECRET=value\nTERM=screen-256color\n", ""},
+		{[]string{"-print"}, false, 1, "", "ssm-env: invalid parameters: [secret]\n"},
+		{[]string{"-print", "-no-fail"}, false, 0, "SUPER_SECRET=ssm://secret\n", ""},
+		{[]string{"-print", "-fail-on-missing=false"}, false, 0, "SUPER_SECRET=ssm://secret\n", ""},
+		{[]string{"-print", "-no-fail", "-fail-on-missing"}, false, 1, "", "ssm-env: invalid parameters: [secret]\n"},
+		{[]string{"-compare-prefix", "sec=other-sec", "-no-fail"}, true, 1, "SUPER_SECRET: " + valueHash("value") + " != unresolved\n", ""},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+
+		c := new(mockSSM)
+		if tt.found {
+			c.On("GetParameters", secret).Return(&ssm.GetParametersOutput{
+				Parameters: []*ssm.Parameter{
+					{Name: aws.String("secret"), Value: aws.String("value")},
+				},
+			}, nil)
+		} else {
+			c.On("GetParameters", secret).Return(&ssm.GetParametersOutput{
+				InvalidParameters: []*string{aws.String("secret")},
+			}, nil)
+		}
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("other-secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			InvalidParameters: []*string{aws.String("other-secret")},
+		}, nil).Maybe()
+
+		code, stdout, stderr := runWith(c, os, tt.args...)
+		assert.Equal(t, tt.code, code, "%v", tt.args)
+		assert.Equal(t, tt.stdout, stdout, "%v", tt.args)
+		if !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("%v: stderr %q doesn't contain %q", tt.args, stderr, tt.stderr)
+		}
+	}
+}
+
+func TestRun_Flags(t *testing.T) {
+	defer func(v string) { Version = v }(Version)
+	Version = "1.2.3"
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"-V"}, 0, "1.2.3\n", ""},
+		{nil, 1, "", "Usage of ssm-env:"},
+		{[]string{"-h"}, 0, "", "Usage of ssm-env:"},
+		{[]string{"-unknown-flag"}, 2, "", "flag provided but not defined: -unknown-flag"},
+		{[]string{"-format", "yaml"}, 1, "", "ssm-env: unknown format \"yaml\"\n"},
+		{[]string{"-path-key-case", "camel", "-print"}, 1, "", "ssm-env: unknown key case \"camel\"\n"},
+		{[]string{"-compare-prefix", "nope"}, 1, "", "ssm-env: -compare-prefix must be FROM=TO, got \"nope\"\n"},
+	}
+
+	for _, tt := range tests {
+		// Nothing is resolved, so no calls are expected.
+		c := new(mockSSM)
+		code, stdout, stderr := runWith(c, newFakeEnviron(), tt.args...)
+		assert.Equal(t, tt.code, code, "%v", tt.args)
+		assert.Equal(t, tt.stdout, stdout, "%v", tt.args)
+		if !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("%v: stderr %q doesn't contain %q", tt.args, stderr, tt.stderr)
+		}
+		c.AssertExpectations(t)
+	}
+}
+
+func TestParseTemplate_Cache(t *testing.T) {
+	defer func(f func(string) (*template.Template, error)) { compileTemplate = f }(compileTemplate)
+	compiled := 0
+	compile := compileTemplate
+	compileTemplate = func(text string) (*template.Template, error) {
+		compiled++
+		return compile(text)
+	}
+
+	text := `{{ if eq .Name "CACHED" }}cached{{ end }}`
+	t1, err := parseTemplate(text)
+	assert.NoError(t, err)
+	t2, err := parseTemplate(text)
+	assert.NoError(t, err)
+	assert.True(t, t1 == t2)
+	assert.Equal(t, 1, compiled)
+
+	t3, err := parseTemplate(text + " ")
+	assert.NoError(t, err)
+	assert.True(t, t1 != t3)
+	assert.Equal(t, 2, compiled)
+
+	// Templates that fail to parse are parsed again every time.
+	_, err = parseTemplate("{{ .Name")
+	assert.Error(t, err)
+	_, err = parseTemplate("{{ .Name")
+	assert.Error(t, err)
+	assert.Equal(t, 4, compiled)
+}
+
+func TestRun_FallbackCommand(t *testing.T) {
+	defer func(f func(string, []string, []string) error) { execve = f }(execve)
+
+	tests := []struct {
+		found bool
+		env   bool
+		args  []string
+	}{
+		// A reference resolved, so the command is executed.
+		{true, true, []string{"sh", "-c", "exit 0"}},
+		// No reference resolved.
+		{false, true, []string{"true", "--legacy"}},
+		// No reference at all.
+		{false, false, []string{"true", "--legacy"}},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		if tt.env {
+			os.Setenv("SUPER_SECRET", "ssm://secret")
+		}
+
+		c := new(mockSSM)
+		resp := &ssm.GetParametersOutput{InvalidParameters: []*string{aws.String("secret")}}
+		if tt.found {
+			resp = &ssm.GetParametersOutput{
+				Parameters: []*ssm.Parameter{
+					{Name: aws.String("secret"), Value: aws.String("value")},
+				},
+			}
+		}
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(resp, nil).Maybe()
+
+		var executed []string
+		execve = func(path string, args []string, env []string) error {
+			executed = args
+			return errors.New("executed")
+		}
+
+		code, _, stderr := runWith(c, os, "-no-fail", "-fallback-command", "true --legacy", "sh", "-c", "exit 0")
+		assert.Equal(t, 1, code)
+		assert.True(t, strings.HasSuffix(stderr, "ssm-env: executed\n"), stderr)
+		assert.Equal(t, tt.args, executed)
+	}
+}
@@ -0,0 +1,42 @@
+package ssmenv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// stats counts what resolution did, to be summarized once it's done with
+// -stats.
+type stats struct {
+	mu       sync.Mutex
+	resolved int64
+	failed   int64
+	calls    int64
+	retries  int64
+}
+
+func (s *stats) Count(name string, value int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch name {
+	case metricResolved:
+		s.resolved += value
+	case metricFailed:
+		s.failed += value
+	case metricCalls:
+		s.calls += value
+	case metricRetries:
+		s.retries += value
+	}
+}
+
+func (s *stats) Timing(name string, d time.Duration) {}
+
+// summary returns the summary of resolution, which took d.
+func (s *stats) summary(d time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("resolved %d variables (%d failed) with %d AWS calls (%d retried) in %v",
+		s.resolved, s.failed, s.calls, s.retries, d.Round(time.Millisecond))
+}
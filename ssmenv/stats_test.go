@@ -0,0 +1,68 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	env := newFakeEnviron()
+	c := new(mockSSM)
+	s := new(stats)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        env,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		metrics:   s,
+	}
+
+	env.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	env.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a"), aws.String("secret-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("hehe")},
+		},
+		InvalidParameters: []*string{aws.String("secret-b")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "resolved 1 variables (1 failed) with 1 AWS calls (0 retried) in 1.235s", s.summary(1234567*time.Microsecond))
+
+	c.AssertExpectations(t)
+}
+
+func TestRun_Stats(t *testing.T) {
+	env := newFakeEnviron()
+	env.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c := new(mockSSM)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("value")},
+		},
+	}, nil)
+
+	code, stdout, stderr := runWith(c, env, "-stats", "-print")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "SUPER_SECRET=value\n", stdout)
+	assert.Regexp(t, `^ssm-env: resolved 1 variables \(0 failed\) with 1 AWS calls \(0 retried\) in \S+\n$`, stderr)
+
+	c.AssertExpectations(t)
+}
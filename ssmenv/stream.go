@@ -0,0 +1,214 @@
+package ssmenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats that resolved variables can be written in by expandTo.
+const (
+	// FormatEnv writes a KEY=VALUE line per variable. Values spanning
+	// multiple lines, or starting with a double quote, are written as a
+	// double quoted string with escapes, so that every variable is one
+	// line.
+	FormatEnv = "env"
+
+	// FormatJSON writes a single {"KEY": "VALUE", ...} object.
+	FormatJSON = "json"
+
+	// FormatNDJSON writes a {"name": "KEY", "value": "VALUE"} object per
+	// line.
+	FormatNDJSON = "ndjson"
+
+	// FormatDockerEnv writes KEY=VALUE lines, as read by the --env-file
+	// flag of docker run. Values are used verbatim, without any quoting, so
+	// values spanning multiple lines are an error.
+	FormatDockerEnv = "docker-env"
+
+	// FormatDotenv writes KEY="VALUE" lines, with backslashes, double
+	// quotes, dollar signs and newlines escaped, as read by dotenv
+	// libraries.
+	FormatDotenv = "dotenv"
+
+	// FormatShell writes export KEY='VALUE' lines, to be sourced by a
+	// POSIX shell.
+	FormatShell = "shell"
+
+	// FormatExec isn't written anywhere: the command is executed with the
+	// resolved variables, which is what happens without -format.
+	FormatExec = "exec"
+)
+
+// expandTo is expandEnviron, also writing every variable to w in format as
+// soon as it's resolved, rather than once everything is. Variables are
+// written in the order they're resolved in, not sorted, and each of them
+// once.
+//
+// Values that are references resolved by a later phase, such as KMS
+// ciphertext held by an SSM parameter, are only written once resolved. With
+// a filter, whether a variable is kept isn't known until everything is
+// resolved, so nothing is written until then.
+func (e *expander) expandTo(w io.Writer, format string, decrypt bool, nofail bool) error {
+	write, done, err := newVarWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	var werr error
+	emit := func(k, v string) {
+		if werr == nil {
+			werr = write(k, v)
+		}
+	}
+
+	// Variables set by ssm-path:// and ssm-json:// references can be set
+	// again by a reference of higher precedence resolved later, so they're
+	// held back, and only their final value is written, once everything is
+	// resolved.
+	held := make(map[string]bool)
+	if e.filter == nil {
+		e.stream = func(k, v string) {
+			if t, ok := e.targets[k]; ok && t.precedence < precedenceExplicit {
+				held[k] = true
+				return
+			}
+			delete(held, k)
+			if !e.resolvedLater(k, v) {
+				emit(k, v)
+			}
+		}
+		defer func() { e.stream = nil }()
+	}
+
+	if err := e.expandEnviron(decrypt, nofail); err != nil {
+		return err
+	}
+
+	if len(held) > 0 {
+		vars := envMap(e.os.Environ())
+		names := make([]string, 0, len(held))
+		for k := range held {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			emit(k, vars[k])
+		}
+	}
+
+	if e.filter != nil {
+		vars := envMap(e.os.Environ())
+		for _, k := range e.resolvedVars() {
+			emit(k, vars[k])
+		}
+	}
+
+	if werr != nil {
+		return werr
+	}
+	return done()
+}
+
+// validFormat reports whether variables can be written in format.
+func validFormat(format string) bool {
+	_, ok := varWriters[format]
+	return ok || format == FormatJSON
+}
+
+// newVarWriter returns a function writing a variable to w in format, and one
+// finishing the output once every variable is written.
+func newVarWriter(w io.Writer, format string) (write func(k, v string) error, done func() error, err error) {
+	if format == FormatJSON {
+		o := &jsonObjectWriter{w: w}
+		return o.write, o.close, nil
+	}
+
+	line, ok := varWriters[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+	write = func(k, v string) error { return line(w, k, v) }
+	done = func() error { return nil }
+	return write, done, nil
+}
+
+// jsonObjectWriter writes variables as the members of a single JSON object.
+// Members are written as they come, so the object can be streamed, and it's
+// closed by close.
+type jsonObjectWriter struct {
+	w io.Writer
+	n int
+}
+
+func (o *jsonObjectWriter) write(k, v string) error {
+	key, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	sep := ","
+	if o.n == 0 {
+		sep = "{"
+	}
+	o.n++
+	_, err = fmt.Fprintf(o.w, "%s%s:%s", sep, key, value)
+	return err
+}
+
+func (o *jsonObjectWriter) close() error {
+	if o.n == 0 {
+		_, err := io.WriteString(o.w, "{}\n")
+		return err
+	}
+	_, err := io.WriteString(o.w, "}\n")
+	return err
+}
+
+// varWriters write a variable in each of the line based formats.
+var varWriters = map[string]func(w io.Writer, k, v string) error{
+	FormatEnv: func(w io.Writer, k, v string) error {
+		if strings.ContainsAny(v, "\r\n") || strings.HasPrefix(v, `"`) {
+			v = strconv.Quote(v)
+		}
+		_, err := fmt.Fprintf(w, "%s=%s\n", k, v)
+		return err
+	},
+	FormatDockerEnv: func(w io.Writer, k, v string) error {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("%s spans multiple lines, which docker's --env-file doesn't support", k)
+		}
+		_, err := fmt.Fprintf(w, "%s=%s\n", k, v)
+		return err
+	},
+	FormatDotenv: func(w io.Writer, k, v string) error {
+		_, err := fmt.Fprintf(w, "%s=\"%s\"\n", k, dotenvEscaper.Replace(v))
+		return err
+	},
+	FormatShell: func(w io.Writer, k, v string) error {
+		_, err := fmt.Fprintf(w, "export %s='%s'\n", k, strings.ReplaceAll(v, "'", `'\''`))
+		return err
+	},
+	FormatNDJSON: func(w io.Writer, k, v string) error {
+		return json.NewEncoder(w).Encode(struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		}{k, v})
+	},
+}
+
+// dotenvEscaper escapes a value to be double quoted in a dotenv file.
+var dotenvEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`$`, `\$`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
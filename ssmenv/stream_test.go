@@ -0,0 +1,193 @@
+package ssmenv
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+// newStreamExpander returns an expander for an environment referencing SSM
+// parameters, one of which holds KMS ciphertext, and a KMS value.
+func newStreamExpander() (*expander, fakeEnviron) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := &expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: 1,
+	}
+
+	os.Setenv("SECRET_A", "ssm://secret-a")
+	os.Setenv("SECRET_B", "ssm://secret-b")
+	os.Setenv("SECRET_C", "!kms Y2lwaGVydGV4dA==")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("val-a")},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-b")},
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-b"), Value: aws.String("!kms Y2lwaGVydGV4dA==")},
+		},
+	}, nil)
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("plaintext"),
+	}, nil)
+
+	return e, os
+}
+
+func TestExpandTo(t *testing.T) {
+	decrypt := true
+	nofail := false
+
+	e, os := newStreamExpander()
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	batch := new(bytes.Buffer)
+	err = printVars(batch, os, e.resolvedVars(), FormatEnv)
+	assert.NoError(t, err)
+
+	e, _ = newStreamExpander()
+	streamed := new(bytes.Buffer)
+	err = e.expandTo(streamed, FormatEnv, decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "SECRET_A=val-a\nSECRET_B=plaintext\nSECRET_C=plaintext\n", batch.String())
+	assert.Equal(t, batch.String(), sortedLines(streamed.String()))
+}
+
+func TestExpandTo_JSON(t *testing.T) {
+	e, _ := newStreamExpander()
+	e.filter = template.Must(parseTemplate(`{{ ne .Name "SECRET_C" }}`))
+
+	b := new(bytes.Buffer)
+	err := e.expandTo(b, FormatJSON, true, false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"SECRET_A":"val-a","SECRET_B":"plaintext"}`+"\n", b.String())
+}
+
+func TestExpandTo_NDJSON(t *testing.T) {
+	e, _ := newStreamExpander()
+	e.filter = template.Must(parseTemplate(`{{ ne .Name "SECRET_C" }}`))
+
+	b := new(bytes.Buffer)
+	err := e.expandTo(b, FormatNDJSON, true, false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"name":"SECRET_A","value":"val-a"}
+{"name":"SECRET_B","value":"plaintext"}
+`, b.String())
+}
+
+func TestPrintVars_JSONEmpty(t *testing.T) {
+	b := new(bytes.Buffer)
+	err := printVars(b, newFakeEnviron(), nil, FormatJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}\n", b.String())
+}
+
+func TestExpandTo_Overridden(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := &expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		vault:     &fakeVault{secrets: map[string]map[string]interface{}{"secret/data/db": {"user": "vault"}}},
+		log:       &logger{w: new(bytes.Buffer)},
+		batchSize: defaultBatchSize,
+	}
+
+	// DB_USER is set by the ssm-json:// reference, and then by its own
+	// vault:// reference, which takes precedence.
+	os.Setenv("DB", "ssm-json://db")
+	os.Setenv("DB_USER", "vault://secret/data/db#user")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("db")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("db"), Value: aws.String(`{"db_host": "localhost", "db_user": "json"}`)},
+		},
+	}, nil)
+
+	b := new(bytes.Buffer)
+	err := e.expandTo(b, FormatJSON, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"DB_USER":"vault","DB_HOST":"localhost"}`+"\n", b.String())
+}
+
+func TestExpandTo_OverriddenKMSFirst(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	e := &expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		log:       &logger{w: new(bytes.Buffer)},
+		batchSize: defaultBatchSize,
+		kmsFirst:  true,
+	}
+
+	// DB_USER is decrypted first, and then targeted by the ssm-json://
+	// reference, which it takes precedence over.
+	os.Setenv("DB", "ssm-json://db")
+	os.Setenv("DB_USER", "!kms Y2lwaGVydGV4dA==")
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("kms"),
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("db")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("db"), Value: aws.String(`{"db_host": "localhost", "db_user": "json"}`)},
+		},
+	}, nil)
+
+	b := new(bytes.Buffer)
+	err := e.expandTo(b, FormatJSON, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"DB_USER":"kms","DB_HOST":"localhost"}`+"\n", b.String())
+}
+
+func TestExpandTo_UnknownFormat(t *testing.T) {
+	e, _ := newStreamExpander()
+	err := e.expandTo(new(bytes.Buffer), "yaml", true, false)
+	assert.EqualError(t, err, `unknown format "yaml"`)
+}
+
+// sortedLines returns the lines of s in sorted order.
+func sortedLines(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
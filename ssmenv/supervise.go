@@ -0,0 +1,56 @@
+package ssmenv
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// childCommand returns the command running path with args in env, with the
+// stdin of ssm-env, and stdout and stderr.
+func childCommand(path string, args []string, env environ, stdout, stderr io.Writer) *exec.Cmd {
+	cmd := exec.Command(path)
+	cmd.Args = args
+	cmd.Env = env.Environ()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, stderr
+	return cmd
+}
+
+// runChild runs cmd as a child process, forwarding signals to it, and
+// returns its exit code. It's used instead of exec'ing the command when
+// ssm-env has to keep running alongside it.
+func runChild(cmd *exec.Cmd) (int, error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitCode(exitErr), nil
+	}
+	return 0, err
+}
+
+// exitCode returns the exit code of a command that failed with err. Like
+// shells, a command killed by a signal is given 128 plus the signal number,
+// since ExitCode is -1 for those, which isn't a valid exit code.
+func exitCode(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return err.ExitCode()
+}
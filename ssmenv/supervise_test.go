@@ -0,0 +1,57 @@
+package ssmenv
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunChild(t *testing.T) {
+	code, err := runChild(exec.Command("sh", "-c", "exit 3"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code)
+}
+
+func TestRunChild_Signaled(t *testing.T) {
+	code, err := runChild(exec.Command("sh", "-c", "kill -TERM $$"))
+	assert.NoError(t, err)
+	assert.Equal(t, 128+int(syscall.SIGTERM), code)
+}
+
+func TestRun_Supervise(t *testing.T) {
+	os := newFakeEnviron()
+	os.Setenv("SUPER_SECRET", "ssm://secret")
+
+	c := new(mockSSM)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret"), Value: aws.String("value")},
+		},
+	}, nil)
+
+	// The command runs as a child, with the resolved environment, and its
+	// exit code is ssm-env's.
+	code, stdout, stderr := runWith(c, os, "-supervise", "sh", "-c", "echo $SUPER_SECRET; exit 3")
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "value\n", stdout)
+	assert.Equal(t, "", stderr)
+
+	c.AssertExpectations(t)
+}
+
+func TestRun_SuperviseSignaled(t *testing.T) {
+	// A child killed by a signal exits ssm-env with 128 plus the signal
+	// number, like shells do.
+	c := new(mockSSM)
+	code, _, stderr := runWith(c, newFakeEnviron(), "-supervise", "sh", "-c", "kill -TERM $$")
+	assert.Equal(t, 143, code)
+	assert.Equal(t, "", stderr)
+	c.AssertExpectations(t)
+}
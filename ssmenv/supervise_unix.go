@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package ssmenv
+
+import (
+	"os"
+	"syscall"
+)
+
+// forwardedSignals are the signals passed on to the command, when it runs
+// as a child process.
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM,
+	syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGWINCH,
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package ssmenv
+
+import "os"
+
+// forwardedSignals are the signals passed on to the command, when it runs
+// as a child process.
+var forwardedSignals = []os.Signal{os.Interrupt}
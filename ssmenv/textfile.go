@@ -0,0 +1,110 @@
+package ssmenv
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// textfileMetrics collects metrics in memory, to be written in the
+// Prometheus text exposition format for node_exporter's textfile collector,
+// once resolution is done. Counters are named after the metric, prefixed
+// with ssm_env_, and timings are written as summaries in seconds.
+type textfileMetrics struct {
+	mu      sync.Mutex
+	counts  map[string]int64
+	timings map[string][]time.Duration
+}
+
+func newTextfileMetrics() *textfileMetrics {
+	m := &textfileMetrics{
+		counts:  make(map[string]int64),
+		timings: make(map[string][]time.Duration),
+	}
+	// Counters are always written, so that rates work from the first run.
+	for _, name := range []string{metricResolved, metricFailed, metricCalls, metricRetries} {
+		m.counts[name] = 0
+	}
+	return m
+}
+
+func (m *textfileMetrics) Count(name string, value int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name] += value
+}
+
+func (m *textfileMetrics) Timing(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timings[name] = append(m.timings[name], d)
+}
+
+// textfile returns the collected metrics, along with the time of the run and
+// whether it succeeded.
+func (m *textfileMetrics) textfile(now time.Time, success bool) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := new(bytes.Buffer)
+	counters := make([]string, 0, len(m.counts))
+	for name := range m.counts {
+		counters = append(counters, name)
+	}
+	sort.Strings(counters)
+	for _, name := range counters {
+		n := promName(name) + "_total"
+		fmt.Fprintf(b, "# TYPE %s counter\n%s %d\n", n, n, m.counts[name])
+	}
+
+	names := make([]string, 0, len(m.timings))
+	for name := range m.timings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var sum time.Duration
+		for _, d := range m.timings[name] {
+			sum += d
+		}
+		n := promName(name) + "_seconds"
+		fmt.Fprintf(b, "# TYPE %s summary\n%s_sum %g\n%s_count %d\n", n, n, sum.Seconds(), n, len(m.timings[name]))
+	}
+
+	ok := 0
+	if success {
+		ok = 1
+	}
+	fmt.Fprintf(b, "# TYPE ssm_env_last_run_timestamp_seconds gauge\nssm_env_last_run_timestamp_seconds %d\n", now.Unix())
+	fmt.Fprintf(b, "# TYPE ssm_env_last_run_success gauge\nssm_env_last_run_success %d\n", ok)
+	return b.Bytes()
+}
+
+// write writes the textfile to path atomically, so node_exporter never reads
+// a partial file.
+func (m *textfileMetrics) write(path string, now time.Time, success bool) error {
+	return writeFileAtomic(path, m.textfile(now, success), 0644)
+}
+
+// promName turns the name of a metric into a Prometheus metric name.
+func promName(name string) string {
+	return "ssm_env_" + strings.ReplaceAll(name, ".", "_")
+}
+
+// multiMetrics sends metrics to each of its metrics.
+type multiMetrics []metrics
+
+func (m multiMetrics) Count(name string, value int64) {
+	for _, mm := range m {
+		mm.Count(name, value)
+	}
+}
+
+func (m multiMetrics) Timing(name string, d time.Duration) {
+	for _, mm := range m {
+		mm.Timing(name, d)
+	}
+}
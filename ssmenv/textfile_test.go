@@ -0,0 +1,81 @@
+package ssmenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextfileMetrics(t *testing.T) {
+	env := newFakeEnviron()
+	c := new(mockSSM)
+	m := newTextfileMetrics()
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        env,
+		ssm:       c,
+		batchSize: defaultBatchSize,
+		metrics:   m,
+		clock:     &fakeClock{t: time.Unix(1700000000, 0)},
+	}
+
+	env.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	env.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a"), aws.String("secret-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("hehe")},
+		},
+		InvalidParameters: []*string{aws.String("secret-b")},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ssm_env.prom")
+	assert.NoError(t, m.write(path, e.now(), err == nil))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `# TYPE ssm_env_aws_calls_total counter
+ssm_env_aws_calls_total 1
+# TYPE ssm_env_aws_retries_total counter
+ssm_env_aws_retries_total 0
+# TYPE ssm_env_parameters_failed_total counter
+ssm_env_parameters_failed_total 1
+# TYPE ssm_env_parameters_resolved_total counter
+ssm_env_parameters_resolved_total 1
+# TYPE ssm_env_aws_latency_seconds summary
+ssm_env_aws_latency_seconds_sum 0
+ssm_env_aws_latency_seconds_count 1
+# TYPE ssm_env_last_run_timestamp_seconds gauge
+ssm_env_last_run_timestamp_seconds 1700000000
+# TYPE ssm_env_last_run_success gauge
+ssm_env_last_run_success 1
+`, string(b))
+	assert.NotContains(t, string(b), "hehe")
+
+	c.AssertExpectations(t)
+}
+
+func TestTextfileMetrics_Failure(t *testing.T) {
+	m := newTextfileMetrics()
+	m.Count(metricFailed, 2)
+	m.Timing(metricLatency, 1500*time.Millisecond)
+
+	b := string(m.textfile(time.Unix(1700000000, 0), false))
+	assert.Contains(t, b, "ssm_env_parameters_failed_total 2\n")
+	assert.Contains(t, b, "ssm_env_aws_latency_seconds_sum 1.5\n")
+	assert.Contains(t, b, "ssm_env_last_run_success 0\n")
+}
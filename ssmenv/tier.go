@@ -0,0 +1,128 @@
+package ssmenv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Metrics counting the tiers of the parameters that were looked up.
+const (
+	metricTierStandard = "parameters.tier.standard"
+	metricTierAdvanced = "parameters.tier.advanced"
+)
+
+// advancedParameters returns the names that refer to parameters in the
+// Advanced tier, which are billed per API interaction.
+//
+// GetParameters doesn't return the tier of parameters, so it's looked up
+// with DescribeParameters, by the names it returns: references given as
+// ARNs are looked up by the name of the parameter in them. DescribeParameters
+// only describes the parameters of the caller's account, so the tier of
+// parameters shared by other accounts isn't known, and they aren't returned.
+func (e *expander) advancedParameters(ctx context.Context, names []string) ([]string, error) {
+	byName := make(map[string][]string)
+	var filter []*string
+	for _, name := range names {
+		for _, described := range describedNames(name) {
+			if _, ok := byName[described]; !ok {
+				filter = append(filter, aws.String(described))
+			}
+			byName[described] = append(byName[described], name)
+		}
+	}
+
+	input := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{Key: aws.String("Name"), Option: aws.String("Equals"), Values: filter},
+		},
+	}
+
+	var advanced []string
+	for {
+		start := e.now()
+		resp, err := e.ssm.DescribeParametersWithContext(ctx, input)
+		e.count(metricCalls, 1)
+		e.logCall(fmt.Sprintf("ssm:DescribeParameters of %d parameters", len(names)), start, err)
+		if err != nil {
+			return nil, err
+		}
+		// DescribeParameters can't be restricted to resources.
+		e.iam.add("ssm:DescribeParameters", "*")
+
+		for _, p := range resp.Parameters {
+			if aws.StringValue(p.Tier) != ssm.ParameterTierAdvanced {
+				e.count(metricTierStandard, 1)
+				continue
+			}
+			e.count(metricTierAdvanced, 1)
+			advanced = append(advanced, byName[aws.StringValue(p.Name)]...)
+		}
+
+		if resp.NextToken == nil {
+			return advanced, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// baseName returns the name of a parameter without any version or label
+// selector. In an ARN, the selector follows the resource, after the fifth
+// colon.
+func baseName(name string) string {
+	prefix := ""
+	if isParameterARN(name) {
+		parts := strings.SplitN(name, ":", 6)
+		if len(parts) < 6 {
+			return name
+		}
+		prefix = strings.Join(parts[:5], ":") + ":"
+		name = parts[5]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		return prefix + name[:i]
+	}
+	return prefix + name
+}
+
+// describedNames returns the names DescribeParameters may describe the
+// parameter name refers to as: its name without any selector, which for an
+// ARN is the name of the parameter in its resource, after "parameter". The
+// resource of a parameter at the root of the hierarchy, like
+// parameter/secret, doesn't tell whether its name starts with a slash, so
+// both are returned.
+func describedNames(name string) []string {
+	base := baseName(name)
+	if !isParameterARN(base) {
+		return []string{base}
+	}
+
+	parts := strings.SplitN(base, ":", 6)
+	if len(parts) < 6 || !strings.HasPrefix(parts[5], "parameter/") {
+		return []string{base}
+	}
+	param := strings.TrimPrefix(parts[5], "parameter")
+	if strings.Count(param, "/") == 1 {
+		return []string{param[1:], param}
+	}
+	return []string{param}
+}
+
+// without returns the names that aren't in exclude.
+func without(names, exclude []string) []string {
+	skip := make(map[string]bool)
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	var rest []string
+	for _, name := range names {
+		if !skip[name] {
+			rest = append(rest, name)
+		}
+	}
+	return rest
+}
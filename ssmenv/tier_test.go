@@ -0,0 +1,150 @@
+package ssmenv
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpandEnviron_DenyAdvancedTier(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:                template.Must(parseTemplate(DefaultTemplate)),
+		os:               os,
+		ssm:              c,
+		batchSize:        defaultBatchSize,
+		denyAdvancedTier: true,
+	}
+
+	os.Setenv("SUPER_SECRET", "ssm:///secret:2")
+
+	c.On("DescribeParameters", &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{Key: aws.String("Name"), Option: aws.String("Equals"), Values: []*string{aws.String("/secret")}},
+		},
+	}).Return(&ssm.DescribeParametersOutput{
+		Parameters: []*ssm.ParameterMetadata{
+			{Name: aws.String("/secret"), Tier: aws.String(ssm.ParameterTierAdvanced)},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "parameters in the Advanced tier: [/secret:2]")
+
+	c.AssertExpectations(t)
+}
+
+func TestExpandEnviron_DenyAdvancedTierNoFail(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	m := newFakeMetrics()
+	e := expander{
+		t:                template.Must(parseTemplate(DefaultTemplate)),
+		os:               os,
+		ssm:              c,
+		batchSize:        defaultBatchSize,
+		metrics:          m,
+		denyAdvancedTier: true,
+	}
+
+	os.Setenv("ADVANCED", "ssm:///advanced")
+	os.Setenv("STANDARD", "ssm:///standard")
+
+	c.On("DescribeParameters", mock.Anything).Return(&ssm.DescribeParametersOutput{
+		Parameters: []*ssm.ParameterMetadata{
+			{Name: aws.String("/advanced"), Tier: aws.String(ssm.ParameterTierAdvanced)},
+			{Name: aws.String("/standard"), Tier: aws.String(ssm.ParameterTierStandard)},
+		},
+	}, nil)
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("/standard")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("/standard"), Value: aws.String("val")},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"ADVANCED=ssm:///advanced",
+		"SHELL=/bin/bash",
+		"STANDARD=val",
+		"TERM=screen-256color",
+	}, os.Environ())
+	assert.Equal(t, int64(1), m.counts[metricTierAdvanced])
+	assert.Equal(t, int64(1), m.counts[metricTierStandard])
+
+	c.AssertExpectations(t)
+}
+
+func TestBaseName(t *testing.T) {
+	assert.Equal(t, "/secret", baseName("/secret"))
+	assert.Equal(t, "/secret", baseName("/secret:2"))
+	assert.Equal(t, "/secret", baseName("/secret:prod"))
+	assert.Equal(t, allowedARN, baseName(allowedARN))
+	assert.Equal(t, allowedARN, baseName(allowedARN+":2"))
+}
+
+func TestDescribedNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+	}{
+		{"/app/secret", []string{"/app/secret"}},
+		{"/app/secret:2", []string{"/app/secret"}},
+		{"secret", []string{"secret"}},
+		{"arn:aws:ssm:us-east-1:123456789012:parameter/app/secret", []string{"/app/secret"}},
+		{"arn:aws:ssm:us-east-1:123456789012:parameter/app/secret:prod", []string{"/app/secret"}},
+		{"arn:aws:ssm:us-east-1:123456789012:parameter/secret", []string{"secret", "/secret"}},
+		{"arn:aws:ssm:us-east-1", []string{"arn:aws:ssm:us-east-1"}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.names, describedNames(tt.name), tt.name)
+	}
+}
+
+func TestExpandEnviron_DenyAdvancedTierARN(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	e := expander{
+		t:                template.Must(parseTemplate(DefaultTemplate)),
+		os:               os,
+		ssm:              c,
+		batchSize:        defaultBatchSize,
+		denyAdvancedTier: true,
+	}
+
+	arn := "arn:aws:ssm:us-east-1:123456789012:parameter/app/secret"
+	os.Setenv("SUPER_SECRET", "ssm://"+arn+":2")
+
+	c.On("DescribeParameters", &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{Key: aws.String("Name"), Option: aws.String("Equals"), Values: []*string{aws.String("/app/secret")}},
+		},
+	}).Return(&ssm.DescribeParametersOutput{
+		Parameters: []*ssm.ParameterMetadata{
+			{Name: aws.String("/app/secret"), Tier: aws.String(ssm.ParameterTierAdvanced)},
+		},
+	}, nil)
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.EqualError(t, err, "parameters in the Advanced tier: ["+arn+":2]")
+
+	c.AssertExpectations(t)
+}
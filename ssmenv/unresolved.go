@@ -0,0 +1,86 @@
+package ssmenv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// unresolvedMode is what -print, -print-all, -format and -resolve-only-vars
+// do with variables still holding a reference after resolution, under
+// -no-fail.
+type unresolvedMode string
+
+const (
+	// unresolvedKeep prints the reference, as it is.
+	unresolvedKeep unresolvedMode = "keep"
+
+	// unresolvedOmit leaves the variable out.
+	unresolvedOmit unresolvedMode = "omit"
+
+	// unresolvedPlaceholder prints unresolvedValue instead of the
+	// reference.
+	unresolvedPlaceholder unresolvedMode = "placeholder"
+
+	// unresolvedFail fails without printing anything.
+	unresolvedFail unresolvedMode = "fail"
+)
+
+// unresolvedValue is the value printed for unresolved references with
+// unresolvedPlaceholder.
+const unresolvedValue = "<unresolved>"
+
+// validUnresolvedMode reports whether m is one of the unresolved modes.
+func validUnresolvedMode(m unresolvedMode) bool {
+	return m == unresolvedKeep || m == unresolvedOmit || m == unresolvedPlaceholder || m == unresolvedFail
+}
+
+// unresolvedVars returns the names of the environment variables that still
+// hold a reference one of the phases resolves, because it couldn't be
+// resolved, in sorted order.
+func (e *expander) unresolvedVars() []string {
+	var names []string
+	for _, envvar := range e.os.Environ() {
+		k, v := splitVar(envvar)
+
+		if !e.resolved[k] && e.considered(k) && e.isReference(k, v) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply applies m to the names of the variables about to be printed, given
+// the names of the unresolved ones, and returns the names to print. With
+// unresolvedPlaceholder, the unresolved variables are set to
+// unresolvedValue in env.
+func (m unresolvedMode) apply(env environ, names, unresolved []string) ([]string, error) {
+	isUnresolved := make(map[string]bool, len(unresolved))
+	for _, name := range unresolved {
+		isUnresolved[name] = true
+	}
+
+	var kept, missing []string
+	for _, name := range names {
+		if !isUnresolved[name] {
+			kept = append(kept, name)
+			continue
+		}
+
+		missing = append(missing, name)
+		switch m {
+		case unresolvedOmit:
+		case unresolvedPlaceholder:
+			env.Setenv(name, unresolvedValue)
+			kept = append(kept, name)
+		default:
+			kept = append(kept, name)
+		}
+	}
+
+	if m == unresolvedFail && len(missing) > 0 {
+		return nil, fmt.Errorf("unresolved references: %s", strings.Join(missing, ", "))
+	}
+	return kept, nil
+}
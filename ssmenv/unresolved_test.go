@@ -0,0 +1,141 @@
+package ssmenv
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_PrintUnresolved(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			[]string{"-print-all", "-no-fail"},
+			0,
+			"MISSING_SECRET=ssm://missing\nSHELL=/bin/bash\nSUPER_SECRET=value\nTERM=screen-256color\n",
+			"",
+		},
+		{
+			[]string{"-print-all", "-no-fail", "-print-unresolved", "omit"},
+			0,
+			"SHELL=/bin/bash\nSUPER_SECRET=value\nTERM=screen-256color\n",
+			"",
+		},
+		{
+			[]string{"-print-all", "-no-fail", "-print-unresolved", "placeholder"},
+			0,
+			"MISSING_SECRET=<unresolved>\nSHELL=/bin/bash\nSUPER_SECRET=value\nTERM=screen-256color\n",
+			"",
+		},
+		{
+			[]string{"-print-all", "-no-fail", "-print-unresolved", "fail"},
+			1,
+			"",
+			"ssm-env: unresolved references: MISSING_SECRET\n",
+		},
+		{
+			[]string{"-print", "-no-fail"},
+			0,
+			"MISSING_SECRET=ssm://missing\nSUPER_SECRET=value\n",
+			"",
+		},
+		{
+			[]string{"-print", "-no-fail", "-print-unresolved", "omit"},
+			0,
+			"SUPER_SECRET=value\n",
+			"",
+		},
+		{
+			[]string{"-print", "-no-fail", "-print-unresolved", "placeholder"},
+			0,
+			"MISSING_SECRET=<unresolved>\nSUPER_SECRET=value\n",
+			"",
+		},
+		{
+			[]string{"-print", "-no-fail", "-print-unresolved", "fail"},
+			1,
+			"",
+			"ssm-env: unresolved references: MISSING_SECRET\n",
+		},
+		{
+			[]string{"-format", "env", "-no-fail", "-print-unresolved", "fail"},
+			1,
+			"",
+			"ssm-env: unresolved references: MISSING_SECRET\n",
+		},
+		{
+			[]string{"-format", "dotenv", "-no-fail", "-print-unresolved", "placeholder"},
+			0,
+			"MISSING_SECRET=\"<unresolved>\"\nSUPER_SECRET=\"value\"\n",
+			"",
+		},
+		{
+			[]string{"-format", "shell", "-resolve-only-vars", "MISSING_SECRET,SUPER_SECRET", "-no-fail", "-print-unresolved", "placeholder"},
+			0,
+			"export MISSING_SECRET='<unresolved>'\nexport SUPER_SECRET='value'\n",
+			"",
+		},
+		{
+			[]string{"-print-all", "-print-unresolved", "other"},
+			1,
+			"",
+			"ssm-env: unknown -print-unresolved \"other\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		os.Setenv("SUPER_SECRET", "ssm://secret")
+		os.Setenv("MISSING_SECRET", "ssm://missing")
+
+		c := new(mockSSM)
+		c.On("GetParameters", &ssm.GetParametersInput{
+			Names:          []*string{aws.String("missing"), aws.String("secret")},
+			WithDecryption: aws.Bool(false),
+		}).Return(&ssm.GetParametersOutput{
+			Parameters: []*ssm.Parameter{
+				{Name: aws.String("secret"), Value: aws.String("value")},
+			},
+			InvalidParameters: []*string{aws.String("missing")},
+		}, nil).Maybe()
+
+		code, stdout, stderr := runWith(c, os, tt.args...)
+		assert.Equal(t, tt.code, code, "%v", tt.args)
+		assert.Equal(t, tt.stdout, stdout, "%v", tt.args)
+		assert.Contains(t, stderr, tt.stderr, "%v", tt.args)
+	}
+}
+
+func TestUnresolvedMode_Apply(t *testing.T) {
+	tests := []struct {
+		mode  unresolvedMode
+		names []string
+		err   bool
+	}{
+		{unresolvedKeep, []string{"A", "B", "C"}, false},
+		{unresolvedOmit, []string{"A", "C"}, false},
+		{unresolvedPlaceholder, []string{"A", "B", "C"}, false},
+		{unresolvedFail, nil, true},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		os.Setenv("B", "ssm://b")
+
+		names, err := tt.mode.apply(os, []string{"A", "B", "C"}, []string{"B", "D"})
+		assert.Equal(t, tt.err, err != nil, "%v", tt.mode)
+		assert.Equal(t, tt.names, names, "%v", tt.mode)
+
+		if tt.mode == unresolvedPlaceholder {
+			assert.Contains(t, os.Environ(), "B=<unresolved>")
+		} else {
+			assert.Contains(t, os.Environ(), "B=ssm://b")
+		}
+	}
+}
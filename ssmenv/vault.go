@@ -0,0 +1,241 @@
+package ssmenv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultPrefix marks an environment variable value as a reference to a key
+// of a secret in a Vault KV secrets engine, like
+// vault://secret/data/myapp#password.
+const VaultPrefix = "vault://"
+
+// vaultTimeout is how long to wait for a response from Vault.
+const vaultTimeout = 10 * time.Second
+
+// errVaultSecretNotFound is returned by VaultClient when a secret doesn't
+// exist.
+var errVaultSecretNotFound = errors.New("secret not found")
+
+// VaultClient reads secrets from Vault.
+type VaultClient interface {
+	// ReadSecret returns the data of the secret at path, like
+	// secret/data/myapp. Secrets that don't exist are
+	// errVaultSecretNotFound.
+	ReadSecret(ctx context.Context, path string) (map[string]interface{}, error)
+}
+
+// httpVaultClient reads secrets with the Vault HTTP API.
+type httpVaultClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func (c *httpVaultClient) ReadSecret(ctx context.Context, path string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errVaultSecretNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("decoding secret: %v", err)
+	}
+
+	// Version 2 of the KV secrets engine wraps the data of the secret
+	// along with its metadata.
+	if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return data, nil
+		}
+	}
+	return secret.Data, nil
+}
+
+// lazyVaultClient reads the address of Vault and the token to authenticate
+// with from VAULT_ADDR and VAULT_TOKEN the first time a secret is read, so
+// that they're only required when there are Vault references.
+type lazyVaultClient struct {
+	// getenv looks up environment variables. os.Getenv is used if it's nil.
+	getenv func(string) string
+
+	mu    sync.Mutex
+	vault VaultClient
+}
+
+func (c *lazyVaultClient) ReadSecret(ctx context.Context, path string) (map[string]interface{}, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.ReadSecret(ctx, path)
+}
+
+// client returns the Vault client, initializing it if it hasn't been
+// already.
+func (c *lazyVaultClient) client() (VaultClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.vault == nil {
+		getenv := c.getenv
+		if getenv == nil {
+			getenv = os.Getenv
+		}
+		addr, token := getenv("VAULT_ADDR"), getenv("VAULT_TOKEN")
+		if addr == "" {
+			return nil, errors.New("VAULT_ADDR isn't set")
+		}
+		if token == "" {
+			return nil, errors.New("VAULT_TOKEN isn't set")
+		}
+		c.vault = &httpVaultClient{addr: addr, token: token, client: &http.Client{Timeout: vaultTimeout}}
+	}
+	return c.vault, nil
+}
+
+// parseVaultReference returns the path of the secret and the key a Vault
+// reference, without its prefix, refers to.
+func parseVaultReference(ref string) (path, key string, err error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return "", "", fmt.Errorf("%q has no #key", ref)
+	}
+	path, key = ref[:i], ref[i+1:]
+	if path == "" || key == "" {
+		return "", "", fmt.Errorf("%q isn't a path#key", ref)
+	}
+	return path, key, nil
+}
+
+// vaultPhase returns the phase resolving Vault references.
+func (e *expander) vaultPhase() phase {
+	return phase{
+		expand: func(ctx context.Context, decrypt bool, nofail bool) error {
+			return e.expandVault(ctx, nofail)
+		},
+		matches: func(k, v string) bool { return hasPrefixFold(v, VaultPrefix) },
+	}
+}
+
+// expandVault resolves the environment variables referencing Vault secrets.
+// Every secret is read once, however many variables reference it. Secrets
+// and keys that don't exist are treated like missing SSM parameters: the
+// reference's default value is used if it has one, and otherwise they fail
+// unless -no-fail or -fail-on-missing=false is set, in which case the
+// variable is given -missing-sentinel, or left in place.
+func (e *expander) expandVault(ctx context.Context, nofail bool) error {
+	secrets := make(map[string]map[string]interface{})
+	errs := make(map[string]error)
+
+	for _, envvar := range e.os.Environ() {
+		k, v := splitVar(envvar)
+
+		if !e.considered(k) || e.resolved[k] {
+			continue
+		}
+		v, err := e.preTransform(v)
+		if err != nil {
+			return fmt.Errorf("pre-transforming %s: %v", k, err)
+		}
+		if !hasPrefixFold(v, VaultPrefix) {
+			continue
+		}
+
+		ref, def := splitDefault(trimPrefixFold(v, VaultPrefix))
+		val, found, err := e.readVaultReference(ctx, ref, secrets, errs)
+		if err == nil && !found && def != nil {
+			e.logf("%s: default value of %s", k, ref)
+			val, found = *def, true
+		}
+		if err == nil && !found {
+			err := fmt.Errorf("reading %s from Vault: %s not found", k, ref)
+			e.count(metricFailed, 1)
+			if !e.onMissing.tolerated(nofail) {
+				return err
+			}
+			e.warnings().warn(err)
+			if e.missingSentinel != "" {
+				e.targets[k] = target{k, precedenceExplicit}
+				e.setResolved(k, e.missingSentinel)
+			}
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("reading %s from Vault: %v", k, err)
+			e.count(metricFailed, 1)
+			if !nofail {
+				return err
+			}
+			e.warnings().warn(err)
+			continue
+		}
+
+		e.targets[k] = target{k, precedenceExplicit}
+		e.setResolved(k, val)
+	}
+	return nil
+}
+
+// readVaultReference returns the value a Vault reference refers to, reading
+// its secret unless it's in secrets, or failed to be read before, in errs.
+// found is false if the secret or the key don't exist.
+func (e *expander) readVaultReference(ctx context.Context, ref string, secrets map[string]map[string]interface{}, errs map[string]error) (value string, found bool, err error) {
+	path, key, err := parseVaultReference(ref)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := errs[path]; err != nil {
+		return "", false, err
+	}
+	secret, ok := secrets[path]
+	if !ok {
+		e.logf("vault: secret %s", path)
+		start := e.now()
+		secret, err = e.vault.ReadSecret(ctx, path)
+		e.count(metricCalls, 1)
+		e.logCall("vault:read of "+path, start, err)
+		if err == errVaultSecretNotFound {
+			// Recorded as a secret without keys, so it isn't read again.
+			secret, err = nil, nil
+		}
+		if err != nil {
+			errs[path] = err
+			return "", false, err
+		}
+		secrets[path] = secret
+	}
+
+	v, ok := secret[key]
+	if !ok {
+		return "", false, nil
+	}
+	if s, ok := v.(string); ok {
+		return s, true, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), true, err
+}
@@ -0,0 +1,232 @@
+package ssmenv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault holds secrets by path, and counts the times they're read.
+type fakeVault struct {
+	secrets map[string]map[string]interface{}
+	reads   map[string]int
+}
+
+func (v *fakeVault) ReadSecret(ctx context.Context, path string) (map[string]interface{}, error) {
+	if v.reads == nil {
+		v.reads = make(map[string]int)
+	}
+	v.reads[path]++
+	secret, ok := v.secrets[path]
+	if !ok {
+		return nil, errVaultSecretNotFound
+	}
+	return secret, nil
+}
+
+func TestExpandEnviron_Vault(t *testing.T) {
+	os := newFakeEnviron()
+	v := &fakeVault{secrets: map[string]map[string]interface{}{
+		"secret/data/myapp": {"password": "hunter2", "port": float64(5432)},
+	}}
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       new(mockSSM),
+		vault:     v,
+		batchSize: defaultBatchSize,
+	}
+
+	os.Setenv("DB_PASSWORD", "vault://secret/data/myapp#password")
+	os.Setenv("DB_PORT", "vault://secret/data/myapp#port")
+
+	decrypt := false
+	nofail := false
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"DB_PASSWORD=hunter2",
+		"DB_PORT=5432",
+		"SHELL=/bin/bash",
+		"TERM=screen-256color",
+	}, os.Environ())
+
+	// The secret is only read once.
+	assert.Equal(t, map[string]int{"secret/data/myapp": 1}, v.reads)
+}
+
+func TestExpandEnviron_VaultErrors(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{"vault://secret/data/other#password", "reading DB_PASSWORD from Vault: secret/data/other#password not found"},
+		{"vault://secret/data/myapp#user", "reading DB_PASSWORD from Vault: secret/data/myapp#user not found"},
+		{"vault://secret/data/myapp", `reading DB_PASSWORD from Vault: "secret/data/myapp" has no #key`},
+	}
+
+	for _, tt := range tests {
+		for _, nofail := range []bool{false, true} {
+			os := newFakeEnviron()
+			e := expander{
+				t:         template.Must(parseTemplate(DefaultTemplate)),
+				os:        os,
+				ssm:       new(mockSSM),
+				vault:     &fakeVault{secrets: map[string]map[string]interface{}{"secret/data/myapp": {"password": "hunter2"}}},
+				log:       &logger{w: new(bytes.Buffer)},
+				batchSize: defaultBatchSize,
+			}
+
+			os.Setenv("DB_PASSWORD", tt.value)
+
+			decrypt := false
+			err := e.expandEnviron(decrypt, nofail)
+			if nofail {
+				// The reference is left in place.
+				assert.NoError(t, err)
+				assert.Equal(t, tt.value, envMap(os.Environ())["DB_PASSWORD"])
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		}
+	}
+}
+
+func TestExpandEnviron_VaultMissing(t *testing.T) {
+	tests := []struct {
+		value     string
+		onMissing missingPolicy
+		nofail    bool
+		sentinel  string
+		want      string
+		err       string
+	}{
+		// Defaults are used whatever -no-fail is.
+		{"vault://secret/data/myapp#user|app", missingDefault, false, "", "app", ""},
+		{"vault://secret/data/other#user|", missingDefault, false, "", "", ""},
+		{"vault://secret/data/myapp#password|app", missingDefault, false, "", "hunter2", ""},
+
+		// Missing secrets and keys are given -missing-sentinel, like
+		// missing parameters.
+		{"vault://secret/data/myapp#user", missingDefault, true, "MISSING", "MISSING", ""},
+		{"vault://secret/data/other#user", missingOptional, false, "MISSING", "MISSING", ""},
+		{"vault://secret/data/myapp#user", missingOptional, false, "", "vault://secret/data/myapp#user", ""},
+		{"vault://secret/data/myapp#user", missingRequired, true, "MISSING", "", "reading DB_USER from Vault: secret/data/myapp#user not found"},
+	}
+
+	for _, tt := range tests {
+		os := newFakeEnviron()
+		e := expander{
+			t:               template.Must(parseTemplate(DefaultTemplate)),
+			os:              os,
+			ssm:             new(mockSSM),
+			vault:           &fakeVault{secrets: map[string]map[string]interface{}{"secret/data/myapp": {"password": "hunter2"}}},
+			log:             &logger{w: new(bytes.Buffer)},
+			batchSize:       defaultBatchSize,
+			onMissing:       tt.onMissing,
+			missingSentinel: tt.sentinel,
+		}
+
+		os.Setenv("DB_USER", tt.value)
+
+		decrypt := false
+		err := e.expandEnviron(decrypt, tt.nofail)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.value)
+			continue
+		}
+		assert.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, envMap(os.Environ())["DB_USER"], tt.value)
+	}
+}
+
+func TestRun_VaultOptIn(t *testing.T) {
+	os := newFakeEnviron()
+	os.Setenv("SPRING_CONFIG_IMPORT", "vault://")
+
+	// Without -vault, vault:// values are left alone, and VAULT_ADDR
+	// isn't needed.
+	code, stdout, stderr := runWith(new(mockSSM), os, "-print-all")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "SPRING_CONFIG_IMPORT=vault://\n")
+
+	code, _, stderr = runWith(new(mockSSM), os, "-vault", "-print-all")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, `reading SPRING_CONFIG_IMPORT from Vault: "" has no #key`)
+}
+
+func TestHTTPVaultClient(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/myapp":
+			fmt.Fprint(w, `{"data": {"data": {"password": "hunter2"}, "metadata": {"version": 3}}}`)
+		case "/v1/kv/myapp":
+			fmt.Fprint(w, `{"data": {"password": "hunter1"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	c := &httpVaultClient{addr: s.URL + "/", token: "token", client: s.Client()}
+	ctx := context.Background()
+
+	// Version 2 of the KV secrets engine.
+	secret, err := c.ReadSecret(ctx, "secret/data/myapp")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "hunter2"}, secret)
+
+	// Version 1.
+	secret, err = c.ReadSecret(ctx, "/kv/myapp")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "hunter1"}, secret)
+
+	_, err = c.ReadSecret(ctx, "secret/data/missing")
+	assert.Equal(t, errVaultSecretNotFound, err)
+
+	c.token = "other"
+	_, err = c.ReadSecret(ctx, "secret/data/myapp")
+	assert.EqualError(t, err, "vault returned 403 Forbidden")
+}
+
+func TestLazyVaultClient(t *testing.T) {
+	env := map[string]string{}
+	c := &lazyVaultClient{getenv: func(k string) string { return env[k] }}
+
+	_, err := c.ReadSecret(context.Background(), "secret/data/myapp")
+	assert.EqualError(t, err, "VAULT_ADDR isn't set")
+
+	env["VAULT_ADDR"] = "http://127.0.0.1:8200"
+	_, err = c.ReadSecret(context.Background(), "secret/data/myapp")
+	assert.EqualError(t, err, "VAULT_TOKEN isn't set")
+
+	env["VAULT_TOKEN"] = "token"
+	client, err := c.client()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8200", client.(*httpVaultClient).addr)
+	assert.Equal(t, "token", client.(*httpVaultClient).token)
+}
+
+func TestParseVaultReference(t *testing.T) {
+	path, key, err := parseVaultReference("secret/data/myapp#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret/data/myapp", path)
+	assert.Equal(t, "password", key)
+
+	_, _, err = parseVaultReference("secret/data/myapp#")
+	assert.EqualError(t, err, `"secret/data/myapp#" isn't a path#key`)
+
+	_, _, err = parseVaultReference("secret/data/myapp")
+	assert.EqualError(t, err, `"secret/data/myapp" has no #key`)
+}
@@ -0,0 +1,27 @@
+package ssmenv
+
+import (
+	"fmt"
+	"time"
+)
+
+// logf writes a diagnostic message, if there's a verbose log. Messages must
+// never include values, only names and metadata.
+func (e *expander) logf(format string, args ...interface{}) {
+	if e.verbose == nil {
+		return
+	}
+	e.verbose.write(logEntry{Level: levelDebug, Msg: fmt.Sprintf(format, args...)})
+}
+
+// logCall logs an AWS call, started at start, and its error, if any.
+func (e *expander) logCall(call string, start time.Time, err error) {
+	if e.verbose == nil {
+		return
+	}
+	if err != nil {
+		e.verbose.write(logEntry{Level: levelDebug, Msg: fmt.Sprintf("%s failed after %v", call, e.since(start)), Error: err.Error()})
+		return
+	}
+	e.logf("%s took %v", call, e.since(start))
+}
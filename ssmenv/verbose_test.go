@@ -0,0 +1,87 @@
+package ssmenv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExpandEnviron_Verbose(t *testing.T) {
+	os := newFakeEnviron()
+	c := new(mockSSM)
+	k := new(mockKMS)
+	clk := newFakeClock()
+	log := new(bytes.Buffer)
+	e := expander{
+		t:         template.Must(parseTemplate(DefaultTemplate)),
+		os:        os,
+		ssm:       c,
+		kms:       k,
+		batchSize: 1,
+		clock:     clk,
+		verbose:   &logger{w: log},
+	}
+
+	os.Setenv("SUPER_SECRET_A", "ssm://secret-a")
+	os.Setenv("SUPER_SECRET_B", "ssm://secret-b")
+	os.Setenv("KMS_SECRET", "!kms "+base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-a")},
+		WithDecryption: aws.Bool(false),
+	}).Run(func(mock.Arguments) {
+		clk.advance(120 * time.Millisecond)
+	}).Return(&ssm.GetParametersOutput{
+		Parameters: []*ssm.Parameter{
+			{Name: aws.String("secret-a"), Value: aws.String("value-a")},
+		},
+	}, nil)
+	c.On("GetParameters", &ssm.GetParametersInput{
+		Names:          []*string{aws.String("secret-b")},
+		WithDecryption: aws.Bool(false),
+	}).Return(&ssm.GetParametersOutput{
+		InvalidParameters: []*string{aws.String("secret-b")},
+	}, nil)
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("ciphertext"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("value-kms"),
+	}, nil)
+
+	decrypt := false
+	nofail := true
+	err := e.expandEnviron(decrypt, nofail)
+	assert.NoError(t, err)
+
+	out := log.String()
+	for _, line := range []string{
+		"ssm-env: SHELL: not a reference\n",
+		"ssm-env: SUPER_SECRET_A: parameter secret-a\n",
+		"ssm-env: SUPER_SECRET_B: parameter secret-b\n",
+		"ssm-env: batch 1 of 2, with decryption false: [secret-a]\n",
+		"ssm-env: batch 2 of 2, with decryption false: [secret-b]\n",
+		"ssm-env: ssm:GetParameters of [secret-a] took 120ms\n",
+		"ssm-env: ssm:GetParameters of [secret-b] took 0s\n",
+		"ssm-env: KMS_SECRET: KMS value\n",
+		"ssm-env: kms:Decrypt took 0s\n",
+	} {
+		assert.Contains(t, out, line)
+	}
+
+	// Values are never logged, whether they're references, ciphertext
+	// or resolved.
+	for _, value := range []string{"value-a", "value-kms", "ssm://", "!kms", "/bin/bash"} {
+		assert.NotContains(t, out, value)
+	}
+
+	c.AssertExpectations(t)
+	k.AssertExpectations(t)
+}